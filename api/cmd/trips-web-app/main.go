@@ -1,14 +1,20 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/DIMO-Network/shared"
+	"github.com/dimo-network/trips-web-app/api/internal/auth"
 	"github.com/dimo-network/trips-web-app/api/internal/config"
 	"github.com/dimo-network/trips-web-app/api/internal/controllers"
+	"github.com/dimo-network/trips-web-app/api/internal/dimo"
+	"github.com/dimo-network/trips-web-app/api/internal/geocode"
+	"github.com/dimo-network/trips-web-app/api/internal/mapmatch"
+	"github.com/dimo-network/trips-web-app/api/internal/session"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/template/handlebars/v2"
@@ -53,10 +59,44 @@ func main() {
 
 	engine := handlebars.New("./views", ".hbs")
 
-	ac := controllers.NewAccountController(settings)
-	vc := controllers.NewVehiclesController(settings)
-	tc := controllers.NewTripsController(settings)
-	st := controllers.NewStreamrController(settings)
+	store, err := newSessionStore(&settings)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not create session store")
+	}
+
+	client := dimo.NewClient(&settings)
+
+	// road snapping is optional; without a match service the snapped route answers 503
+	var matcher *mapmatch.Client
+	if settings.MapMatchingURL != "" {
+		matcher, err = mapmatch.NewClient(settings.MapMatchingURL, time.Duration(settings.APITimeoutSeconds)*time.Second)
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not create map matching client")
+		}
+	}
+
+	// place names are optional too; without a geocoding service trips are listed by time alone
+	var geocoder geocode.Provider
+	if settings.GeocodingURL != "" {
+		geocodingClient, err := geocode.NewClient(settings.GeocodingURL, time.Duration(settings.APITimeoutSeconds)*time.Second, settings.GeocodingRateLimit)
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not create geocoding client")
+		}
+		geocoder = geocodingClient
+	}
+
+	// a bad SPEED_BANDS would otherwise only show up as every trip failing to load
+	if _, err := controllers.DefaultSpeedPreferences(&settings); err != nil {
+		log.Fatal().Err(err).Msg("could not load speed bands")
+	}
+
+	ac := controllers.NewAccountController(settings, client, store)
+	vc := controllers.NewVehiclesController(settings, client, store)
+	tc := controllers.NewTripsController(settings, client, store, geocoder)
+	st := controllers.NewStreamrController(settings, client)
+
+	verifier := auth.NewVerifier(settings.JWTKeySetURL, settings.ClientID, settings.JWTIssuer)
+	authMiddleware := controllers.AuthMiddleware(&settings, client, verifier, store)
 
 	app := fiber.New(fiber.Config{
 		ErrorHandler:   ErrorHandler,
@@ -64,54 +104,84 @@ func main() {
 		ReadBufferSize: 16000,
 	})
 
-	app.Use(cors.New())
+	// only the listed origins may call the app with credentials; with no list, only same-origin requests work
+	if settings.CORSAllowedOrigins != "" {
+		app.Use(cors.New(cors.Config{
+			AllowOrigins:     settings.CORSAllowedOrigins,
+			AllowHeaders:     "Origin, Content-Type, Accept, Authorization, X-Csrf-Token",
+			AllowCredentials: true,
+		}))
+	}
+	csrfMiddleware, err := controllers.CSRFMiddleware(&settings)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not create CSRF middleware")
+	}
+	app.Use(csrfMiddleware)
 
 	// View routes (protected)
-	app.Get("/account", controllers.AuthMiddleware(), ac.MyAccount)
-	app.Get("/vehicles/me", controllers.AuthMiddleware(), vc.HandleGetVehicles)
-	app.Get("/vehicles/:tokenid/status", controllers.AuthMiddleware(), vc.HandleVehicleStatus)
-	app.Get("/vehicles/:tokenid/trips", controllers.AuthMiddleware(), tc.HandleTripsList)
-	app.Get("/give-feedback", controllers.AuthMiddleware(), controllers.HandleGiveFeedback(&settings))
-	app.Get("/streamr", controllers.AuthMiddleware(), st.GetStreamr)
+	app.Get("/account", authMiddleware, ac.MyAccount)
+	app.Get("/vehicles/me", authMiddleware, vc.HandleGetVehicles)
+	app.Get("/vehicles/:tokenid/status", authMiddleware, vc.HandleVehicleStatus)
+	app.Get("/vehicles/:tokenid/trips", authMiddleware, tc.HandleTripsList)
+	app.Get("/vehicles/:tokenid/trips/export", authMiddleware, tc.HandleTripsExport)
+	app.Get("/vehicles/:tokenid/trips/places", authMiddleware, tc.HandleTripPlaces)
+	app.Get("/give-feedback", authMiddleware, controllers.HandleGiveFeedback(client))
+	app.Get("/streamr", authMiddleware, st.GetStreamr)
+
+	// Versioned JSON API mirroring the views, described by /api/v1/openapi.json
+	app.Get("/api/v1/openapi.json", controllers.HandleOpenAPI)
+	app.Get("/api/v1/vehicles", authMiddleware, vc.HandleVehiclesJSON)
+	app.Get("/api/v1/vehicles/:tokenid/status", authMiddleware, vc.HandleVehicleStatusJSON)
+	app.Get("/api/v1/vehicles/:tokenid/trips", authMiddleware, tc.HandleTripsJSON)
+	app.Get("/api/v1/account", authMiddleware, ac.HandleAccountJSON)
 
 	// API routes called via Javascript fetch
-	app.Get("/api/trip/:tripID", controllers.AuthMiddleware(), func(c *fiber.Ctx) error {
+	app.Get("/api/trip/:tripID", authMiddleware, func(c *fiber.Ctx) error {
 		tripID := c.Params("tripID")
 		startTime := c.Query("start")
 		endTime := c.Query("end")
 
 		log.Info().Msgf("Received request for tripID: %s, startTime: %s, endTime: %s", tripID, startTime, endTime)
 
-		// Retrieve the estimated start location from the query string
-		var estimatedStart *controllers.LatLon
-		if estimatedStartStr := c.Query("estimatedStart"); estimatedStartStr != "" {
-			log.Info().Msgf("Received estimatedStart: %s", estimatedStartStr)
-			if err := json.Unmarshal([]byte(estimatedStartStr), &estimatedStart); err != nil {
-				log.Error().Err(err).Msg("Invalid estimated start location")
-				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid estimated start location"})
-			}
-		} else {
-			log.Info().Msg("No estimatedStart received")
+		estimatedStart, err := controllers.ParseEstimatedStart(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Invalid estimated start location")
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid estimated start location"})
 		}
 
-		return controllers.HandleMapDataForTrip(c, &settings, tripID, startTime, endTime, estimatedStart)
+		return controllers.HandleMapDataForTrip(c, &settings, client, store, tripID, startTime, endTime, estimatedStart)
 	})
+	app.Get("/api/trip/:tripID/export", authMiddleware, controllers.HandleTripExport(&settings, client, store))
+	app.Get("/api/trip/:tripID/replay", authMiddleware, controllers.HandleTripReplay(&settings, client, store))
+	app.Get("/api/trip/:tripID/stats", authMiddleware, controllers.HandleTripStats(&settings, client, store))
+	app.Get("/api/trip/:tripID/snapped", authMiddleware, controllers.HandleSnappedTrip(client, matcher, store))
+	app.Get("/api/privacy-zones", authMiddleware, ac.HandlePrivacyZones)
+	app.Post("/api/privacy-zones", authMiddleware, ac.HandleAddPrivacyZone)
+	app.Delete("/api/privacy-zones/:zoneID", authMiddleware, ac.HandleDeletePrivacyZone)
+	app.Get("/api/speed-preferences", authMiddleware, ac.HandleSpeedPreferences)
+	app.Put("/api/speed-preferences", authMiddleware, ac.HandleSaveSpeedPreferences)
+	app.Delete("/api/speed-preferences", authMiddleware, ac.HandleResetSpeedPreferences)
 
 	// Public Routes
 	app.Post("/auth/web3/generate_challenge", func(c *fiber.Ctx) error {
-		return controllers.HandleGenerateChallenge(c, &settings)
+		return controllers.HandleGenerateChallenge(c, client)
 	})
 	app.Post("/auth/web3/submit_challenge", func(c *fiber.Ctx) error {
-		return controllers.HandleSubmitChallenge(c, &settings)
+		return controllers.HandleSubmitChallenge(c, &settings, client, verifier, store)
 	})
+	app.Get("/auth/csrf", controllers.HandleCSRFToken)
+	app.Post("/auth/logout", controllers.HandleLogout(&settings, store))
+
+	// Admin routes
+	app.Post("/admin/sessions/revoke", authMiddleware, controllers.AdminMiddleware(&settings), controllers.HandleRevokeSessions(store))
 
-	app.Post("/api/generate-token/:tokenID", controllers.AuthMiddleware(), func(c *fiber.Ctx) error {
+	app.Post("/api/generate-token/:tokenID", authMiddleware, func(c *fiber.Ctx) error {
 		tokenID, err := strconv.ParseInt(c.Params("tokenID"), 10, 64)
 		if err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid token ID"})
 		}
 
-		token, err := controllers.RequestPriviledgeToken(c, &settings, tokenID)
+		token, err := controllers.RequestPriviledgeToken(c, client, store, tokenID)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to generate privilege token", "details": err.Error()})
 		}
@@ -147,6 +217,29 @@ func main() {
 	}
 }
 
+// newSessionStore picks the session backend from SESSION_STORE. The in-memory store is the default;
+// "redis" shares sessions between replicas and keeps them across restarts.
+func newSessionStore(settings *config.Settings) (controllers.SessionStore, error) {
+	switch settings.SessionStore {
+	case "", "memory":
+		log.Warn().Msg("Using the in-memory session store; privacy zones and speed preferences can't be saved")
+		return session.NewMemoryStore(), nil
+	case "redis":
+		store := session.NewRedisStore(session.RedisOptions{
+			Addr:     settings.RedisAddr,
+			Password: settings.RedisPassword,
+			DB:       settings.RedisDB,
+			TLS:      settings.RedisTLS,
+		})
+		if err := store.Ping(context.Background()); err != nil {
+			return nil, err
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown SESSION_STORE %q", settings.SessionStore)
+	}
+}
+
 func healthCheck(c *fiber.Ctx) error {
 	return c.JSON(fiber.Map{
 		"code":    200,
@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// keySetTTL is how long a fetched key set is trusted before it is refreshed.
+	keySetTTL = time.Hour
+	// minRefreshInterval stops tokens with unknown key ids from hammering the JWKS endpoint.
+	minRefreshInterval = time.Minute
+)
+
+// Claims are the parts of a DIMO id_token the app relies on.
+type Claims struct {
+	EthereumAddress string `json:"ethereum_address"`
+	jwt.RegisteredClaims
+}
+
+// Verifier validates id_tokens against the JSON Web Key Set published by the DIMO auth server.
+// Keys are cached and re-fetched when they age out or when a token references an unknown key id,
+// so key rotation on the auth server is picked up without a restart.
+type Verifier struct {
+	keySetURL string
+	parser    *jwt.Parser
+	client    *http.Client
+
+	mu        sync.RWMutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	now       func() time.Time
+}
+
+// NewVerifier creates a Verifier for tokens issued to audience. issuer is optional; when empty the iss claim is not checked.
+func NewVerifier(keySetURL, audience, issuer string) *Verifier {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}),
+		jwt.WithAudience(audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30 * time.Second),
+	}
+	if issuer != "" {
+		opts = append(opts, jwt.WithIssuer(issuer))
+	}
+
+	return &Verifier{
+		keySetURL: keySetURL,
+		parser:    jwt.NewParser(opts...),
+		client:    &http.Client{Timeout: 10 * time.Second},
+		keys:      map[string]crypto.PublicKey{},
+		now:       time.Now,
+	}
+}
+
+// Verify checks the signature, expiry, audience and issuer of tokenString and returns its claims.
+func (v *Verifier) Verify(ctx context.Context, tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := v.parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return v.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid token")
+	}
+
+	if claims.EthereumAddress == "" {
+		return nil, errors.New("ethereum address not found in JWT")
+	}
+
+	return claims, nil
+}
+
+// key returns the public key for kid, refreshing the key set if it is stale or does not contain kid.
+func (v *Verifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.RLock()
+	key, ok := v.keys[kid]
+	age := v.now().Sub(v.fetchedAt)
+	v.mu.RUnlock()
+
+	if ok && age < keySetTTL {
+		return key, nil
+	}
+	if ok || age >= minRefreshInterval {
+		if err := v.refresh(ctx); err != nil {
+			if ok {
+				// keep serving the cached key if the auth server is briefly unreachable
+				log.Warn().Err(err).Msg("Failed to refresh JWKS, using cached key")
+				return key, nil
+			}
+			return nil, err
+		}
+	}
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	key, ok = v.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("no signing key found for kid %q", kid)
+	}
+	return key, nil
+}
+
+func (v *Verifier) refresh(ctx context.Context) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	// another request may have refreshed the set while we waited for the lock
+	if v.now().Sub(v.fetchedAt) < minRefreshInterval {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.keySetURL, nil)
+	if err != nil {
+		return errors.Wrap(err, "error creating JWKS request")
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "error fetching JWKS")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("invalid response from JWKS endpoint: %d", resp.StatusCode)
+	}
+
+	var keySet jsonWebKeySet
+	if err := json.NewDecoder(resp.Body).Decode(&keySet); err != nil {
+		return errors.Wrap(err, "error decoding JWKS")
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(keySet.Keys))
+	for _, jwk := range keySet.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			log.Warn().Err(err).Str("kid", jwk.Kid).Msg("Skipping unusable JWK")
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	v.keys = keys
+	v.fetchedAt = v.now()
+	log.Debug().Int("keys", len(keys)).Msg("Refreshed JWKS")
+
+	return nil
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid base64url value in JWK")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
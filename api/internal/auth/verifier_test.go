@@ -0,0 +1,323 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testAudience = "trips-web-app"
+	testIssuer   = "https://auth.example.com"
+	testAddress  = "0x0000000000000000000000000000000000000001"
+)
+
+// keyServer is a local JWKS endpoint whose key set can be swapped out to simulate rotation.
+type keyServer struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	keys    []jsonWebKey
+	fetches int
+}
+
+func newKeyServer(t *testing.T, keys ...jsonWebKey) *keyServer {
+	t.Helper()
+	ks := &keyServer{keys: keys}
+	ks.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		ks.mu.Lock()
+		defer ks.mu.Unlock()
+		ks.fetches++
+		_ = json.NewEncoder(w).Encode(jsonWebKeySet{Keys: ks.keys})
+	}))
+	t.Cleanup(ks.Close)
+	return ks
+}
+
+func (ks *keyServer) setKeys(keys ...jsonWebKey) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys = keys
+}
+
+func (ks *keyServer) fetchCount() int {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	return ks.fetches
+}
+
+type signingKey struct {
+	kid    string
+	method jwt.SigningMethod
+	secret crypto.Signer
+}
+
+func newRSAKey(t *testing.T, kid string) signingKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingKey{kid: kid, method: jwt.SigningMethodRS256, secret: key}
+}
+
+func newECKey(t *testing.T, kid string) signingKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingKey{kid: kid, method: jwt.SigningMethodES256, secret: key}
+}
+
+func (k signingKey) jwk() jsonWebKey {
+	encode := func(i *big.Int) string { return base64.RawURLEncoding.EncodeToString(i.Bytes()) }
+	switch pub := k.secret.Public().(type) {
+	case *rsa.PublicKey:
+		return jsonWebKey{Kid: k.kid, Kty: "RSA", Use: "sig", N: encode(pub.N), E: encode(big.NewInt(int64(pub.E)))}
+	case *ecdsa.PublicKey:
+		return jsonWebKey{Kid: k.kid, Kty: "EC", Use: "sig", Crv: "P-256", X: encode(pub.X), Y: encode(pub.Y)}
+	}
+	panic("unsupported key")
+}
+
+func (k signingKey) sign(t *testing.T, claims jwt.Claims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(k.method, claims)
+	token.Header["kid"] = k.kid
+	signed, err := token.SignedString(k.secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func validClaims() Claims {
+	return Claims{
+		EthereumAddress: testAddress,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    testIssuer,
+			Audience:  jwt.ClaimStrings{testAudience},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+}
+
+// testClock is a settable clock for the verifier's refresh limits.
+type testClock struct {
+	at time.Time
+}
+
+func (c *testClock) now() time.Time { return c.at }
+
+func newTestVerifier(ks *keyServer) (*Verifier, *testClock) {
+	clock := &testClock{at: time.Now()}
+	v := NewVerifier(ks.URL, testAudience, testIssuer)
+	v.now = clock.now
+	return v, clock
+}
+
+func TestVerifyValidTokens(t *testing.T) {
+	rsaKey, ecKey := newRSAKey(t, "rsa-1"), newECKey(t, "ec-1")
+	ks := newKeyServer(t, rsaKey.jwk(), ecKey.jwk())
+	v, _ := newTestVerifier(ks)
+
+	for _, key := range []signingKey{rsaKey, ecKey} {
+		t.Run(key.method.Alg(), func(t *testing.T) {
+			claims, err := v.Verify(context.Background(), key.sign(t, validClaims()))
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if claims.EthereumAddress != testAddress {
+				t.Errorf("EthereumAddress = %q, want %q", claims.EthereumAddress, testAddress)
+			}
+		})
+	}
+	if got := ks.fetchCount(); got != 1 {
+		t.Errorf("JWKS fetched %d times, want 1", got)
+	}
+}
+
+func TestVerifyRejectsInvalidTokens(t *testing.T) {
+	key := newRSAKey(t, "rsa-1")
+	ks := newKeyServer(t, key.jwk())
+	v, _ := newTestVerifier(ks)
+
+	// a key with the published kid but different key material
+	forger := newRSAKey(t, "rsa-1")
+
+	tests := []struct {
+		name   string
+		token  func() string
+		errMsg string
+	}{
+		{
+			name:   "bad signature",
+			token:  func() string { return forger.sign(t, validClaims()) },
+			errMsg: "signature is invalid",
+		},
+		{
+			name: "tampered payload",
+			token: func() string {
+				parts := strings.Split(key.sign(t, validClaims()), ".")
+				other := strings.Split(key.sign(t, Claims{EthereumAddress: "0xdead", RegisteredClaims: validClaims().RegisteredClaims}), ".")
+				return parts[0] + "." + other[1] + "." + parts[2]
+			},
+			errMsg: "signature is invalid",
+		},
+		{
+			name: "expired",
+			token: func() string {
+				claims := validClaims()
+				claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+				return key.sign(t, claims)
+			},
+			errMsg: "token is expired",
+		},
+		{
+			name: "no expiry",
+			token: func() string {
+				claims := validClaims()
+				claims.ExpiresAt = nil
+				return key.sign(t, claims)
+			},
+			errMsg: "exp claim is required",
+		},
+		{
+			name: "wrong audience",
+			token: func() string {
+				claims := validClaims()
+				claims.Audience = jwt.ClaimStrings{"someone-else"}
+				return key.sign(t, claims)
+			},
+			errMsg: "token has invalid audience",
+		},
+		{
+			name: "wrong issuer",
+			token: func() string {
+				claims := validClaims()
+				claims.Issuer = "https://evil.example.com"
+				return key.sign(t, claims)
+			},
+			errMsg: "token has invalid issuer",
+		},
+		{
+			name: "missing ethereum address",
+			token: func() string {
+				claims := validClaims()
+				claims.EthereumAddress = ""
+				return key.sign(t, claims)
+			},
+			errMsg: "ethereum address not found",
+		},
+		{
+			name: "unsigned",
+			token: func() string {
+				token := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims())
+				signed, err := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return signed
+			},
+			errMsg: "signing method none is invalid",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.Verify(context.Background(), tt.token())
+			if err == nil {
+				t.Fatal("Verify() succeeded, want error")
+			}
+			if !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("Verify() error = %q, want it to mention %q", err, tt.errMsg)
+			}
+		})
+	}
+}
+
+func TestVerifyUnknownKidRefreshesKeySet(t *testing.T) {
+	oldKey, newKey := newRSAKey(t, "old"), newECKey(t, "new")
+	ks := newKeyServer(t, oldKey.jwk())
+	v, clock := newTestVerifier(ks)
+
+	if _, err := v.Verify(context.Background(), oldKey.sign(t, validClaims())); err != nil {
+		t.Fatalf("Verify() with the old key error = %v", err)
+	}
+
+	// the auth server rotates to a new key
+	ks.setKeys(newKey.jwk())
+	clock.at = clock.at.Add(minRefreshInterval)
+
+	if _, err := v.Verify(context.Background(), newKey.sign(t, validClaims())); err != nil {
+		t.Fatalf("Verify() with the rotated key error = %v", err)
+	}
+	if got := ks.fetchCount(); got != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", got)
+	}
+
+	// the old key was dropped from the set, so it is no longer trusted even after another refresh
+	clock.at = clock.at.Add(minRefreshInterval)
+	if _, err := v.Verify(context.Background(), oldKey.sign(t, validClaims())); err == nil {
+		t.Error("Verify() with the retired key succeeded, want error")
+	}
+}
+
+func TestVerifyRefreshesAtMostOncePerInterval(t *testing.T) {
+	key := newRSAKey(t, "rsa-1")
+	ks := newKeyServer(t, key.jwk())
+	v, clock := newTestVerifier(ks)
+
+	if _, err := v.Verify(context.Background(), key.sign(t, validClaims())); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+
+	unknown := newRSAKey(t, "unknown")
+	for i := 0; i < 5; i++ {
+		if _, err := v.Verify(context.Background(), unknown.sign(t, validClaims())); err == nil {
+			t.Fatal("Verify() with an unknown kid succeeded, want error")
+		}
+	}
+	if got := ks.fetchCount(); got != 1 {
+		t.Errorf("JWKS fetched %d times within minRefreshInterval, want 1", got)
+	}
+
+	clock.at = clock.at.Add(minRefreshInterval)
+	if _, err := v.Verify(context.Background(), unknown.sign(t, validClaims())); err == nil {
+		t.Fatal("Verify() with an unknown kid succeeded, want error")
+	}
+	if got := ks.fetchCount(); got != 2 {
+		t.Errorf("JWKS fetched %d times after minRefreshInterval, want 2", got)
+	}
+}
+
+func TestVerifyKeepsCachedKeyWhenRefreshFails(t *testing.T) {
+	key := newRSAKey(t, "rsa-1")
+	ks := newKeyServer(t, key.jwk())
+	v, clock := newTestVerifier(ks)
+
+	if _, err := v.Verify(context.Background(), key.sign(t, validClaims())); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+
+	ks.Close()
+	clock.at = clock.at.Add(keySetTTL)
+	if _, err := v.Verify(context.Background(), key.sign(t, validClaims())); err != nil {
+		t.Errorf("Verify() with the auth server down error = %v, want the cached key to be used", err)
+	}
+}
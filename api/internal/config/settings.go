@@ -8,8 +8,11 @@ type Settings struct {
 	GrantType                 string `yaml:"GRANT_TYPE"`
 	AuthURL                   string `yaml:"AUTH_URL"`
 	SubmitChallengeURL        string `yaml:"SUBMIT_CHALLENGE_URL"`
+	TokenURL                  string `yaml:"TOKEN_URL"`
 	IdentityAPIURL            string `yaml:"IDENTITY_API_URL"`
 	TokenExchangeJWTKeySetURL string `yaml:"TOKEN_EXCHANGE_JWK_KEY_SET_URL"`
+	JWTKeySetURL              string `yaml:"JWT_KEY_SET_URL"`
+	JWTIssuer                 string `yaml:"JWT_ISSUER"`
 	TokenExchangeAPIURL       string `yaml:"TOKEN_EXCHANGE_API_URL"`
 	PrivilegeNFTContractAddr  string `yaml:"PRIVILEGE_NFT_CONTRACT_ADDR"`
 	DeviceDataAPIURL          string `yaml:"DEVICE_DATA_API_URL"`
@@ -19,4 +22,33 @@ type Settings struct {
 	TripsAPIBaseURL           string `yaml:"TRIPS_API_BASE_URL"`
 	UsersAPIBaseURL           string `yaml:"USERS_API_BASE_URL"`
 	TelemetryAPIURL           string `yaml:"TELEMETRY_API_URL"`
+	APITimeoutSeconds         int    `yaml:"API_TIMEOUT_SECONDS"`
+	APIMaxRetries             int    `yaml:"API_MAX_RETRIES"`
+	BreakerThreshold          int    `yaml:"CIRCUIT_BREAKER_THRESHOLD"`
+	BreakerCooldownSeconds    int    `yaml:"CIRCUIT_BREAKER_COOLDOWN_SECONDS"`
+	SessionStore              string `yaml:"SESSION_STORE"`
+	RedisAddr                 string `yaml:"REDIS_ADDR"`
+	RedisPassword             string `yaml:"REDIS_PASSWORD"`
+	RedisDB                   int    `yaml:"REDIS_DB"`
+	RedisTLS                  bool   `yaml:"REDIS_TLS"`
+	AdminAddresses            string `yaml:"ADMIN_ADDRESSES"`
+	CORSAllowedOrigins        string `yaml:"CORS_ALLOWED_ORIGINS"`
+	CookieDomain              string `yaml:"COOKIE_DOMAIN"`
+	CookiePath                string `yaml:"COOKIE_PATH"`
+	CookieSecure              bool   `yaml:"COOKIE_SECURE"`
+	CookieSameSite            string `yaml:"COOKIE_SAME_SITE"`
+	CSRFSecret                string `yaml:"CSRF_SECRET"`
+	BearerRateLimit           int    `yaml:"BEARER_RATE_LIMIT"`
+	BearerRateLimitSeconds    int    `yaml:"BEARER_RATE_LIMIT_WINDOW_SECONDS"`
+	MapMatchingURL            string `yaml:"MAP_MATCHING_URL"`
+	SpeedBands                string `yaml:"SPEED_BANDS"`
+	SpeedOverLimitColor       string `yaml:"SPEED_OVER_LIMIT_COLOR"`
+	SpeedUnit                 string `yaml:"SPEED_UNIT"`
+	GeocodingURL              string `yaml:"GEOCODING_URL"`
+	GeocodingRateLimit        int    `yaml:"GEOCODING_RATE_LIMIT"`
+	HarshAccelKmhPerSec       int    `yaml:"HARSH_ACCELERATION_KMH_PER_SECOND"`
+	HarshBrakeKmhPerSec       int    `yaml:"HARSH_BRAKING_KMH_PER_SECOND"`
+	SpeedingThresholdKmh      int    `yaml:"SPEEDING_THRESHOLD_KMH"`
+	SpeedingMinSeconds        int    `yaml:"SPEEDING_MIN_SECONDS"`
+	IdlingMinSeconds          int    `yaml:"IDLING_MIN_SECONDS"`
 }
@@ -0,0 +1,42 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// accountDataLockWait bounds how long an edit of a user's settings waits for another edit of the same settings.
+const accountDataLockWait = 5 * time.Second
+
+// errStoreNotDurable is returned when account settings would be saved to a store that forgets them on restart.
+var errStoreNotDurable = errors.New("settings can't be saved because the server has no persistent store")
+
+// updateAccountData runs fn, a load-modify-save of the account settings kept under key, while holding that key's
+// lock, so concurrent edits from other tabs or replicas aren't lost. Settings such as privacy zones must not
+// silently vanish on restart, so they are only saved to a durable store.
+func updateAccountData(ctx context.Context, store SessionStore, key string, fn func() error) error {
+	if !store.Durable() {
+		return errStoreNotDurable
+	}
+	return withLock(ctx, store, key, accountDataLockWait, fn)
+}
+
+// accountDataError answers a failed updateAccountData. A *fiber.Error returned by fn is passed on as it is.
+func accountDataError(c *fiber.Ctx, err error, message string) error {
+	var badRequest *fiber.Error
+	switch {
+	case errors.As(err, &badRequest):
+		return c.Status(badRequest.Code).JSON(fiber.Map{"error": badRequest.Message})
+	case errors.Is(err, errStoreNotDurable):
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": message + ": " + err.Error()})
+	case errors.Is(err, errLockTimeout):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": message + ": another change is in progress, please try again"})
+	default:
+		log.Error().Err(err).Msg(message)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": message})
+	}
+}
@@ -0,0 +1,239 @@
+package controllers
+
+import (
+	_ "embed"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/dimo-network/trips-web-app/api/internal/dimo"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// The /api/v1 handlers return the data behind the HTML views as JSON for scripts. Their response types are
+// declared here rather than reusing the upstream structs, so upstream changes can't silently change the schema
+// documented in openapi.json.
+
+//go:embed openapi.json
+var openAPIDocument []byte
+
+type VehicleResponse struct {
+	TokenID           int64                      `json:"tokenId"`
+	Name              string                     `json:"name,omitempty"`
+	Make              string                     `json:"make"`
+	Model             string                     `json:"model"`
+	Year              int                        `json:"year"`
+	TotalTokens       string                     `json:"totalTokens"`
+	AftermarketDevice *AftermarketDeviceResponse `json:"aftermarketDevice,omitempty"`
+}
+
+type AftermarketDeviceResponse struct {
+	Address      string `json:"address"`
+	Serial       string `json:"serial"`
+	Manufacturer string `json:"manufacturer"`
+}
+
+type VehicleListResponse struct {
+	View       string            `json:"view"`
+	Vehicles   []VehicleResponse `json:"vehicles"`
+	Pagination Pagination        `json:"pagination"`
+}
+
+type SignalResponse struct {
+	Name      string `json:"name"`
+	Value     string `json:"value"`
+	Timestamp string `json:"timestamp"`
+	Source    string `json:"source"`
+}
+
+type VehicleStatusResponse struct {
+	TokenID int64            `json:"tokenId"`
+	Signals []SignalResponse `json:"signals"`
+}
+
+type LatLonResponse struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// TripPointResponse leaves out Location and EstimatedLocation when they fall in one of the user's privacy zones.
+// Place is a short name for where the point is, when one could be found.
+type TripPointResponse struct {
+	Time              string          `json:"time"`
+	Location          *LatLonResponse `json:"location,omitempty"`
+	EstimatedLocation *LatLonResponse `json:"estimatedLocation,omitempty"`
+	Place             string          `json:"place,omitempty"`
+}
+
+type TripResponse struct {
+	ID    string            `json:"id"`
+	Start TripPointResponse `json:"start"`
+	End   TripPointResponse `json:"end"`
+}
+
+type TripListResponse struct {
+	TokenID    int64          `json:"tokenId"`
+	Trips      []TripResponse `json:"trips"`
+	Pagination Pagination     `json:"pagination"`
+}
+
+type PrivilegeResponse struct {
+	ID          int64  `json:"id"`
+	Description string `json:"description"`
+}
+
+type AccountResponse struct {
+	EthereumAddress string              `json:"ethereumAddress"`
+	Token           string              `json:"token"`
+	Privileges      []PrivilegeResponse `json:"privileges"`
+	Vehicles        []VehicleResponse   `json:"vehicles"`
+}
+
+// HandleOpenAPI serves the OpenAPI document describing /api/v1.
+func HandleOpenAPI(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	return c.Send(openAPIDocument)
+}
+
+func (v *VehiclesController) HandleVehiclesJSON(c *fiber.Ctx) error {
+	ethAddress := c.Locals("ethereum_address").(string)
+
+	query, vehicles, page, err := v.vehiclesPage(c, ethAddress)
+	if err != nil {
+		return apiError(c, err, "Failed to fetch vehicles")
+	}
+
+	return c.JSON(VehicleListResponse{
+		View:       query.View,
+		Vehicles:   vehicleResponses(vehicles),
+		Pagination: page,
+	})
+}
+
+func (v *VehiclesController) HandleVehicleStatusJSON(c *fiber.Ctx) error {
+	tokenID, err := strconv.ParseInt(c.Params("tokenid"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid token ID"})
+	}
+
+	rawDeviceStatus, err := QueryDeviceDataAPI(tokenID, v.client, v.store, c)
+	if err != nil {
+		return apiError(c, err, "Failed to fetch device status")
+	}
+
+	entries := ProcessRawDeviceStatus(rawDeviceStatus)
+	signals := make([]SignalResponse, 0, len(entries))
+	for _, entry := range entries {
+		signals = append(signals, SignalResponse{
+			Name:      entry.SignalName,
+			Value:     fmt.Sprint(entry.Value),
+			Timestamp: entry.Timestamp,
+			Source:    entry.Source,
+		})
+	}
+	// the upstream payload is a map, so give scripts a stable order
+	sort.Slice(signals, func(i, j int) bool { return signals[i].Name < signals[j].Name })
+
+	return c.JSON(VehicleStatusResponse{TokenID: tokenID, Signals: signals})
+}
+
+func (t *TripsController) HandleTripsJSON(c *fiber.Ctx) error {
+	tokenID, err := strconv.ParseInt(c.Params("tokenid"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid token ID"})
+	}
+
+	trips, page, err := t.tripsPage(c, tokenID)
+	if err != nil {
+		return apiError(c, err, "Failed to fetch trips")
+	}
+
+	places := resolveTripPlaces(c.UserContext(), t.geocoder, t.places, trips, true)
+	response := TripListResponse{TokenID: tokenID, Trips: make([]TripResponse, 0, len(trips)), Pagination: page}
+	for i, trip := range trips {
+		response.Trips = append(response.Trips, TripResponse{
+			ID:    trip.ID,
+			Start: tripPointResponse(trip.Start, places[i].Start),
+			End:   tripPointResponse(trip.End, places[i].End),
+		})
+	}
+
+	return c.JSON(response)
+}
+
+func (a *AccountController) HandleAccountJSON(c *fiber.Ctx) error {
+	_, session, err := currentSession(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Session expired"})
+	}
+
+	vehicles, err := a.accountVehicles(c, session.EthereumAddress)
+	if err != nil {
+		return apiError(c, err, "Failed to fetch vehicles")
+	}
+
+	privileges := make([]PrivilegeResponse, 0, len(accountPrivileges))
+	for id, description := range accountPrivileges {
+		privilegeID, _ := strconv.ParseInt(id, 10, 64)
+		privileges = append(privileges, PrivilegeResponse{ID: privilegeID, Description: description.(string)})
+	}
+	sort.Slice(privileges, func(i, j int) bool { return privileges[i].ID < privileges[j].ID })
+
+	return c.JSON(AccountResponse{
+		EthereumAddress: session.EthereumAddress,
+		Token:           session.IDToken,
+		Privileges:      privileges,
+		Vehicles:        vehicleResponses(vehicles),
+	})
+}
+
+// apiError answers an /api/v1 request that failed, with a 400 for bad params and the upstream's status otherwise.
+func apiError(c *fiber.Ctx, err error, message string) error {
+	var badRequest *fiber.Error
+	if errors.As(err, &badRequest) {
+		return c.Status(badRequest.Code).JSON(fiber.Map{"error": badRequest.Message})
+	}
+
+	log.Error().Err(err).Str("path", c.Path()).Msg(message)
+	return upstreamErrorJSON(c, err, message)
+}
+
+func vehicleResponses(vehicles []dimo.Vehicle) []VehicleResponse {
+	responses := make([]VehicleResponse, 0, len(vehicles))
+	for _, vehicle := range vehicles {
+		response := VehicleResponse{
+			TokenID:     vehicle.TokenID,
+			Name:        vehicle.Name,
+			Make:        vehicle.Definition.Make,
+			Model:       vehicle.Definition.Model,
+			Year:        vehicle.Definition.Year,
+			TotalTokens: vehicle.Earnings.TotalTokens,
+		}
+		if device := vehicle.AftermarketDevice; device.Address != "" {
+			response.AftermarketDevice = &AftermarketDeviceResponse{
+				Address:      device.Address,
+				Serial:       device.Serial,
+				Manufacturer: device.Manufacturer.Name,
+			}
+		}
+		responses = append(responses, response)
+	}
+	return responses
+}
+
+func tripPointResponse(point dimo.TripPoint, place string) TripPointResponse {
+	response := TripPointResponse{Time: point.Time, Place: place}
+	// hideTripEnds blanks locations in a privacy zone to 0,0
+	if validPosition(point.Location.Latitude, point.Location.Longitude) {
+		response.Location = &LatLonResponse{Latitude: point.Location.Latitude, Longitude: point.Location.Longitude}
+	}
+	if point.EstimatedLocation != nil {
+		response.EstimatedLocation = &LatLonResponse{
+			Latitude:  point.EstimatedLocation.Latitude,
+			Longitude: point.EstimatedLocation.Longitude,
+		}
+	}
+	return response
+}
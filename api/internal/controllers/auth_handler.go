@@ -1,69 +1,105 @@
 package controllers
 
 import (
+	"context"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/dimo-network/trips-web-app/api/internal/auth"
+	"github.com/dimo-network/trips-web-app/api/internal/config"
+	"github.com/dimo-network/trips-web-app/api/internal/dimo"
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v5"
-	"github.com/patrickmn/go-cache"
-	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
 )
 
-var CacheInstance = cache.New(cache.NoExpiration, 10*time.Hour)
-
-type ChallengeResponse struct {
-	State     string `json:"state"`
-	Challenge string `json:"challenge"`
+// SessionStore holds login sessions and the privilege tokens derived from them.
+// Implementations live in the session package; a shared store lets sessions survive restarts and span replicas.
+type SessionStore interface {
+	Get(ctx context.Context, key string) (string, bool, error)
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+	// Keys lists the keys that start with prefix.
+	Keys(ctx context.Context, prefix string) ([]string, error)
+	// SetNX sets key only if it isn't already set, and reports whether it did.
+	SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error)
+	// DeleteIfEqual deletes key only if it still holds value, as one atomic step, and reports whether it did.
+	DeleteIfEqual(ctx context.Context, key, value string) (bool, error)
+	// Incr atomically adds one to the counter at key and returns its new value. A new counter expires after ttl.
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	// Touch gives an existing key a new TTL without changing its value.
+	Touch(ctx context.Context, key string, ttl time.Duration) error
+	// Durable reports whether what is stored survives a restart and is shared by every replica.
+	Durable() bool
 }
 
-func ExtractEthereumAddressFromToken(tokenString string) (string, error) {
-	// Parsing the token without validating its signature
-	token, _, err := new(jwt.Parser).ParseUnverified(tokenString, jwt.MapClaims{})
-	if err != nil {
-		fmt.Println("Error parsing token:", err)
-		return "", fmt.Errorf("error parsing token")
-	}
-
-	// Asserting the type of the claims to access the data
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return "", errors.New("invalid claims type")
-	}
-
-	ethAddress, ok := claims["ethereum_address"].(string)
-	if !ok {
-		return "", errors.New("ethereum address not found in JWT")
-	}
-
-	return ethAddress, nil
-}
+// AuthMiddleware resolves the session_id cookie to its session and verifies the id_token's signature,
+// expiry and audience before letting the request through. Tokens close to expiry are refreshed, once per session
+// however many requests arrive together, and the session and its cookie slide forward on every request.
+//
+// Non-browser clients may instead send a DIMO JWT in an "Authorization: Bearer" header. It is verified the same
+// way, and those requests are rate limited and audit logged on their own.
+func AuthMiddleware(settings *config.Settings, client *dimo.Client, verifier *auth.Verifier, store SessionStore) fiber.Handler {
+	bearerLimiter := newBearerLimiter(settings, store)
 
-func AuthMiddleware() fiber.Handler {
 	return func(c *fiber.Ctx) error {
+		if token, ok := bearerToken(c); ok {
+			return authenticateBearer(c, verifier, bearerLimiter, token)
+		}
+
 		// check if session_id cookie exists
 		sessionCookie := c.Cookies("session_id")
 		if sessionCookie == "" {
 			fmt.Println("No session_id cookie")
-			return c.Render("session_expired", fiber.Map{})
+			return sessionExpired(c)
 		}
 
-		// check if the session_id is in the cache
-		jwtToken, found := CacheInstance.Get(sessionCookie)
+		// check if the session_id is in the store
+		session, found, err := loadSession(c.UserContext(), store, sessionCookie)
+		if err != nil {
+			log.Error().Err(err).Msg("Error reading session store")
+			return fiber.NewError(fiber.StatusServiceUnavailable, "Session store unavailable")
+		}
 		if !found {
 			fmt.Println("Session expired")
-			return c.Render("session_expired", fiber.Map{})
+			return sessionExpired(c)
 		}
 
-		// check if main auth jwt token has expired here, if not show same render of session expired etc.
-		ethAddress, err := ExtractEthereumAddressFromToken(jwtToken.(string))
+		if needsRefresh(session) {
+			session = refreshSessionOnce(c.UserContext(), client, verifier, store, sessionCookie, session)
+		}
+
+		claims, err := verifier.Verify(c.UserContext(), session.IDToken)
 		if err != nil {
-			fmt.Println("Error extracting ethereum address from token:", err)
-			return c.Render("session_expired", fiber.Map{})
+			log.Warn().Err(err).Msg("Rejected session token")
+			if err := deleteSession(c.UserContext(), store, sessionCookie); err != nil {
+				log.Error().Err(err).Msg("Error deleting rejected session")
+			}
+			return sessionExpired(c)
 		}
+		session.EthereumAddress = claims.EthereumAddress
 
-		c.Locals("ethereum_address", ethAddress)
+		// only the TTL is renewed; rewriting the session here could put back tokens a parallel refresh replaced
+		if err := store.Touch(c.UserContext(), sessionKey(sessionCookie), sessionTTL); err != nil {
+			log.Error().Err(err).Msg("Error renewing session")
+		} else {
+			setSessionCookie(c, settings, sessionCookie)
+		}
+
+		c.Locals("ethereum_address", claims.EthereumAddress)
+		c.Locals("session_id", sessionCookie)
+		c.Locals("session", session)
+		c.Locals("auth_method", "cookie")
 
 		return c.Next()
 	}
 }
+
+// sessionExpired answers a request without a usable session: the session_expired page for the views and a
+// 401 for the JSON API.
+func sessionExpired(c *fiber.Ctx) error {
+	if strings.HasPrefix(c.Path(), "/api/v1/") {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Session expired"})
+	}
+	return c.Render("session_expired", fiber.Map{})
+}
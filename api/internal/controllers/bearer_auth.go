@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dimo-network/trips-web-app/api/internal/auth"
+	"github.com/dimo-network/trips-web-app/api/internal/config"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultBearerRateLimit  = 60
+	defaultBearerRateWindow = time.Minute
+	// bearerSessionPrefix marks the session IDs derived from bearer tokens, so they can't collide with cookie sessions.
+	bearerSessionPrefix = "bearer-"
+	// bearerRateLimitPrefix keys each address's request counter in the session store. It differs from the
+	// "ratelimit_" keys the fiber limiter used to write, which hold encoded structs rather than counters.
+	bearerRateLimitPrefix = "bearer_requests_"
+)
+
+// bearerToken returns the token from an "Authorization: Bearer <token>" header, if the request has one.
+func bearerToken(c *fiber.Ctx) (string, bool) {
+	scheme, token, found := strings.Cut(c.Get(fiber.HeaderAuthorization), " ")
+	token = strings.TrimSpace(token)
+	if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+// bearerSessionID derives a stable session ID from a bearer token. Privilege tokens and cached trips are keyed on
+// the session, so a script making repeated calls with the same token reuses them the way a browser session would.
+func bearerSessionID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return bearerSessionPrefix + hex.EncodeToString(sum[:16])
+}
+
+// newBearerLimiter rate limits bearer-authenticated requests per Ethereum address, separately from browser traffic.
+// Each address gets a fixed window that opens on its first request. The counter is incremented atomically in the
+// session store, so replicas share one limit instead of each allowing the full count.
+func newBearerLimiter(settings *config.Settings, store SessionStore) fiber.Handler {
+	max := settings.BearerRateLimit
+	if max <= 0 {
+		max = defaultBearerRateLimit
+	}
+	window := defaultBearerRateWindow
+	if settings.BearerRateLimitSeconds > 0 {
+		window = time.Duration(settings.BearerRateLimitSeconds) * time.Second
+	}
+
+	return func(c *fiber.Ctx) error {
+		address, _ := c.Locals("ethereum_address").(string)
+		count, err := store.Incr(c.UserContext(), bearerRateLimitPrefix+strings.ToLower(address), window)
+		if err != nil {
+			log.Error().Err(err).Msg("Error counting bearer requests")
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to check rate limit"})
+		}
+
+		remaining := int64(max) - count
+		if remaining < 0 {
+			remaining = 0
+		}
+		c.Set("X-RateLimit-Limit", strconv.Itoa(max))
+		c.Set("X-RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+		if count > int64(max) {
+			// the window may close sooner; a whole window is the longest a client could have to wait
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(window.Seconds())))
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": "Rate limit exceeded"})
+		}
+
+		return c.Next()
+	}
+}
+
+// authenticateBearer verifies a bearer token the same way a session's id_token is verified and attaches a
+// request-scoped session for it. Nothing is written to the session store, and every request is audit logged.
+func authenticateBearer(c *fiber.Ctx, verifier *auth.Verifier, rateLimit fiber.Handler, token string) error {
+	started := time.Now()
+
+	claims, err := verifier.Verify(c.UserContext(), token)
+	if err != nil {
+		log.Warn().Err(err).Msg("Rejected bearer token")
+		auditBearerRequest(c, "", fiber.StatusUnauthorized, started)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid bearer token"})
+	}
+
+	session := &Session{
+		EthereumAddress: claims.EthereumAddress,
+		IDToken:         token,
+		Expiry:          claims.ExpiresAt.Time,
+	}
+	c.Locals("ethereum_address", claims.EthereumAddress)
+	c.Locals("session_id", bearerSessionID(token))
+	c.Locals("session", session)
+	c.Locals("auth_method", "bearer")
+
+	err = rateLimit(c)
+
+	status := c.Response().StatusCode()
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		status = fiberErr.Code
+	} else if err != nil {
+		status = fiber.StatusInternalServerError
+	}
+	auditBearerRequest(c, claims.EthereumAddress, status, started)
+
+	return err
+}
+
+// auditBearerRequest records who called what with a bearer token and how it went.
+func auditBearerRequest(c *fiber.Ctx, address string, status int, started time.Time) {
+	log.Info().
+		Str("audit", "bearer").
+		Str("ethereumAddress", address).
+		Str("method", c.Method()).
+		Str("path", c.Path()).
+		Int("status", status).
+		Dur("latency", time.Since(started)).
+		Str("ip", c.IP()).
+		Str("userAgent", c.Get(fiber.HeaderUserAgent)).
+		Msg("Bearer API request")
+}
@@ -0,0 +1,209 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dimo-network/trips-web-app/api/internal/auth"
+	"github.com/dimo-network/trips-web-app/api/internal/config"
+	"github.com/dimo-network/trips-web-app/api/internal/dimo"
+	"github.com/dimo-network/trips-web-app/api/internal/session"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// syncBuffer collects log output written from fiber's handler goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// auditEntries returns the bearer audit log lines written so far.
+func (b *syncBuffer) auditEntries(t *testing.T) []map[string]interface{} {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err == nil && entry["audit"] == "bearer" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func captureLogs(t *testing.T) *syncBuffer {
+	t.Helper()
+	logs := &syncBuffer{}
+	previous := log.Logger
+	log.Logger = zerolog.New(logs)
+	t.Cleanup(func() { log.Logger = previous })
+	return logs
+}
+
+func newBearerApp(t *testing.T, as *authServer, store SessionStore, limit int) *fiber.App {
+	t.Helper()
+	settings := &config.Settings{ClientID: "trips-web-app", BearerRateLimit: limit, BearerRateLimitSeconds: 60}
+	verifier := auth.NewVerifier(as.URL+"/keys", settings.ClientID, "")
+
+	app := fiber.New()
+	app.Get("/api/v1/vehicles", AuthMiddleware(settings, dimo.NewClient(settings), verifier, store), func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"address": c.Locals("ethereum_address")})
+	})
+	return app
+}
+
+func bearerGet(t *testing.T, app *fiber.App, token string) int {
+	t.Helper()
+	req := httptest.NewRequest(fiber.MethodGet, "/api/v1/vehicles", nil)
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode
+}
+
+func TestBearerAuthRejectsInvalidTokens(t *testing.T) {
+	as := newAuthServer(t)
+	logs := captureLogs(t)
+	app := newBearerApp(t, as, session.NewMemoryStore(), 10)
+
+	for name, token := range map[string]string{
+		"garbage": "not-a-jwt",
+		"expired": as.idToken(t, -time.Minute),
+	} {
+		if status := bearerGet(t, app, token); status != fiber.StatusUnauthorized {
+			t.Errorf("%s token: status = %d, want 401", name, status)
+		}
+	}
+
+	entries := logs.auditEntries(t)
+	if len(entries) != 2 {
+		t.Fatalf("%d audit entries, want 2", len(entries))
+	}
+	for _, entry := range entries {
+		if entry["status"] != float64(fiber.StatusUnauthorized) || entry["ethereumAddress"] != "" {
+			t.Errorf("audit entry = %v, want a 401 with no address", entry)
+		}
+	}
+}
+
+func TestBearerAuthAuditsAcceptedRequests(t *testing.T) {
+	as := newAuthServer(t)
+	logs := captureLogs(t)
+	app := newBearerApp(t, as, session.NewMemoryStore(), 10)
+
+	if status := bearerGet(t, app, as.idToken(t, time.Hour)); status != fiber.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+
+	entries := logs.auditEntries(t)
+	if len(entries) != 1 {
+		t.Fatalf("%d audit entries, want 1", len(entries))
+	}
+	entry := entries[0]
+	want := map[string]interface{}{
+		"ethereumAddress": testAddress,
+		"method":          fiber.MethodGet,
+		"path":            "/api/v1/vehicles",
+		"status":          float64(fiber.StatusOK),
+	}
+	for field, value := range want {
+		if entry[field] != value {
+			t.Errorf("audit %s = %v, want %v", field, entry[field], value)
+		}
+	}
+}
+
+func TestBearerRateLimitIsSharedAcrossReplicas(t *testing.T) {
+	as := newAuthServer(t)
+	logs := captureLogs(t)
+	server := miniredis.RunT(t)
+
+	// two replicas, each with its own connection to the same store
+	var replicas []*fiber.App
+	for i := 0; i < 2; i++ {
+		store := session.NewRedisStore(session.RedisOptions{Addr: server.Addr()})
+		t.Cleanup(func() { _ = store.Close() })
+		replicas = append(replicas, newBearerApp(t, as, store, 3))
+	}
+	token := as.idToken(t, time.Hour)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		statuses = map[int]int{}
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(app *fiber.App) {
+			defer wg.Done()
+			status := bearerGet(t, app, token)
+			mu.Lock()
+			statuses[status]++
+			mu.Unlock()
+		}(replicas[i%2])
+	}
+	wg.Wait()
+
+	if statuses[fiber.StatusOK] != 3 || statuses[fiber.StatusTooManyRequests] != 5 {
+		t.Errorf("statuses = %v, want 3 allowed and 5 limited", statuses)
+	}
+
+	limited := 0
+	for _, entry := range logs.auditEntries(t) {
+		if entry["status"] == float64(fiber.StatusTooManyRequests) {
+			limited++
+		}
+	}
+	if limited != 5 {
+		t.Errorf("%d limited requests audited, want 5", limited)
+	}
+
+	// the window closes and the address may call again
+	server.FastForward(time.Minute)
+	if status := bearerGet(t, replicas[0], token); status != fiber.StatusOK {
+		t.Errorf("status after the window = %d, want 200", status)
+	}
+}
+
+func TestBearerRateLimitHeaders(t *testing.T) {
+	as := newAuthServer(t)
+	captureLogs(t)
+	app := newBearerApp(t, as, session.NewMemoryStore(), 1)
+	token := as.idToken(t, time.Hour)
+
+	req := httptest.NewRequest(fiber.MethodGet, "/api/v1/vehicles", nil)
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.Header.Get("X-RateLimit-Remaining"); got != "0" {
+		t.Errorf("X-RateLimit-Remaining = %q, want 0", got)
+	}
+
+	req = httptest.NewRequest(fiber.MethodGet, "/api/v1/vehicles", nil)
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+	if resp, err = app.Test(req, -1); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusTooManyRequests || resp.Header.Get(fiber.HeaderRetryAfter) != "60" {
+		t.Errorf("over the limit: status %d, Retry-After %q; want 429 and 60", resp.StatusCode, resp.Header.Get(fiber.HeaderRetryAfter))
+	}
+}
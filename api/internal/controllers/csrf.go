@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"strings"
+	"time"
+
+	"github.com/dimo-network/trips-web-app/api/internal/config"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	csrfCookieName = "csrf_"
+	csrfHeaderName = "X-Csrf-Token"
+	csrfTTL        = 2 * time.Hour
+	csrfNonceSize  = 16
+)
+
+// CSRFMiddleware protects every state-changing route with the double-submit cookie pattern: safe requests get a
+// csrf_ cookie, and anything else must echo it back in the X-Csrf-Token header. Tokens are signed with
+// CSRF_SECRET and carry their own expiry, so a forged cookie is rejected without keeping anything server side,
+// and any replica sharing the secret accepts them.
+func CSRFMiddleware(settings *config.Settings) (fiber.Handler, error) {
+	secret, err := csrfSecret(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(c *fiber.Ctx) error {
+		// static assets and health checks never change state, so don't mint tokens for them
+		if strings.HasPrefix(c.Path(), "/static/") || c.Path() == "/health" {
+			return c.Next()
+		}
+		// a bearer token has to be attached by the caller's own code, which a forged cross-site request
+		// can't do, so those requests don't need the cookie dance
+		if _, bearer := bearerToken(c); bearer {
+			return c.Next()
+		}
+
+		token := c.Cookies(csrfCookieName)
+		expiry, valid := verifyCSRFToken(secret, token, time.Now())
+
+		switch c.Method() {
+		case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions, fiber.MethodTrace:
+			// hand out a fresh token before the old one runs out under a page that is still open
+			if !valid || time.Until(expiry) < csrfTTL/2 {
+				token, expiry = newCSRFToken(secret, time.Now())
+				setCSRFCookie(c, settings, token, expiry)
+			}
+		default:
+			if !valid || subtle.ConstantTimeCompare([]byte(token), []byte(c.Get(csrfHeaderName))) != 1 {
+				log.Warn().Bool("validCookie", valid).Str("path", c.Path()).Msg("Rejected request failing CSRF check")
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Invalid CSRF token"})
+			}
+		}
+
+		c.Locals("csrf_token", token)
+		return c.Next()
+	}, nil
+}
+
+// HandleCSRFToken returns the caller's CSRF token, for clients such as the login app that cannot read the cookie.
+func HandleCSRFToken(c *fiber.Ctx) error {
+	token, _ := c.Locals("csrf_token").(string)
+	return c.JSON(fiber.Map{"csrfToken": token})
+}
+
+// csrfSecret is the key CSRF tokens are signed with. Without CSRF_SECRET a random one is made, which only works
+// while there is a single replica and is lost, along with every issued token, on restart.
+func csrfSecret(settings *config.Settings) ([]byte, error) {
+	if settings.CSRFSecret != "" {
+		return []byte(settings.CSRFSecret), nil
+	}
+
+	log.Warn().Msg("CSRF_SECRET is not set; using a random one, so CSRF tokens won't survive a restart or work across replicas")
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, errors.Wrap(err, "error generating CSRF secret")
+	}
+	return secret, nil
+}
+
+// newCSRFToken makes a token valid until now+csrfTTL: a random nonce and the expiry, followed by their HMAC.
+func newCSRFToken(secret []byte, now time.Time) (string, time.Time) {
+	expiry := now.Add(csrfTTL).Truncate(time.Second)
+
+	payload := make([]byte, csrfNonceSize+8)
+	// crypto/rand only fails if the OS has no entropy source, and a predictable nonce is still signed
+	_, _ = rand.Read(payload[:csrfNonceSize])
+	binary.BigEndian.PutUint64(payload[csrfNonceSize:], uint64(expiry.Unix()))
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(csrfMAC(secret, payload)), expiry
+}
+
+// verifyCSRFToken checks a token's signature and expiry, returning the expiry of a valid one.
+func verifyCSRFToken(secret []byte, token string, now time.Time) (time.Time, bool) {
+	encodedPayload, encodedMAC, found := strings.Cut(token, ".")
+	if !found {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil || len(payload) != csrfNonceSize+8 {
+		return time.Time{}, false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if err != nil || !hmac.Equal(mac, csrfMAC(secret, payload)) {
+		return time.Time{}, false
+	}
+
+	expiry := time.Unix(int64(binary.BigEndian.Uint64(payload[csrfNonceSize:])), 0)
+	if !now.Before(expiry) {
+		return time.Time{}, false
+	}
+	return expiry, true
+}
+
+func csrfMAC(secret, payload []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func setCSRFCookie(c *fiber.Ctx, settings *config.Settings, token string, expiry time.Time) {
+	c.Cookie(&fiber.Cookie{
+		Name:     csrfCookieName,
+		Value:    token,
+		Domain:   settings.CookieDomain,
+		Path:     cookiePath(settings),
+		Expires:  expiry,
+		Secure:   settings.CookieSecure,
+		SameSite: cookieSameSite(settings),
+		// the pages' scripts read the cookie to echo it back, so it cannot be HTTP only
+		HTTPOnly: false,
+	})
+}
+
+func cookiePath(settings *config.Settings) string {
+	if settings.CookiePath == "" {
+		return "/"
+	}
+	return settings.CookiePath
+}
+
+func cookieSameSite(settings *config.Settings) string {
+	if settings.CookieSameSite == "" {
+		return fiber.CookieSameSiteLaxMode
+	}
+	return settings.CookieSameSite
+}
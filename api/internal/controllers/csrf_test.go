@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dimo-network/trips-web-app/api/internal/config"
+	"github.com/gofiber/fiber/v2"
+)
+
+func newCSRFTestApp(t *testing.T, secret string) *fiber.App {
+	t.Helper()
+	middleware, err := CSRFMiddleware(&config.Settings{CSRFSecret: secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New()
+	app.Use(middleware)
+	app.Get("/", func(c *fiber.Ctx) error { return c.SendString("ok") })
+	app.Post("/", func(c *fiber.Ctx) error { return c.SendString("ok") })
+	return app
+}
+
+func csrfCookie(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == csrfCookieName {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+func postWithToken(app *fiber.App, cookie, header string) (*http.Response, error) {
+	req := httptest.NewRequest(fiber.MethodPost, "/", nil)
+	if cookie != "" {
+		req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: cookie})
+	}
+	if header != "" {
+		req.Header.Set(csrfHeaderName, header)
+	}
+	return app.Test(req)
+}
+
+func TestCSRFIssuesTokenOnSafeRequests(t *testing.T) {
+	app := newCSRFTestApp(t, "secret")
+
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := csrfCookie(t, resp)
+	if token == "" {
+		t.Fatal("GET did not set a CSRF cookie")
+	}
+
+	// a still-fresh token is reused rather than reissued on every page
+	req := httptest.NewRequest(fiber.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: token})
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := csrfCookie(t, resp); got != "" {
+		t.Errorf("GET with a valid token reissued it as %q", got)
+	}
+}
+
+func TestCSRFChecksUnsafeRequests(t *testing.T) {
+	app := newCSRFTestApp(t, "secret")
+	secret := []byte("secret")
+	token, _ := newCSRFToken(secret, time.Now())
+	other, _ := newCSRFToken(secret, time.Now())
+	forged, _ := newCSRFToken([]byte("someone else's secret"), time.Now())
+	expired, _ := newCSRFToken(secret, time.Now().Add(-csrfTTL-time.Minute))
+
+	tests := []struct {
+		name   string
+		cookie string
+		header string
+		want   int
+	}{
+		{name: "matching token", cookie: token, header: token, want: fiber.StatusOK},
+		{name: "no token", want: fiber.StatusForbidden},
+		{name: "cookie without header", cookie: token, want: fiber.StatusForbidden},
+		{name: "header without cookie", header: token, want: fiber.StatusForbidden},
+		{name: "mismatched header", cookie: token, header: other, want: fiber.StatusForbidden},
+		{name: "unsigned token", cookie: "made-up", header: "made-up", want: fiber.StatusForbidden},
+		{name: "signed with another secret", cookie: forged, header: forged, want: fiber.StatusForbidden},
+		{name: "expired", cookie: expired, header: expired, want: fiber.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := postWithToken(app, tt.cookie, tt.header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSRFTokensWorkAcrossReplicas(t *testing.T) {
+	issuer, verifier := newCSRFTestApp(t, "shared"), newCSRFTestApp(t, "shared")
+
+	resp, err := issuer.Test(httptest.NewRequest(fiber.MethodGet, "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := csrfCookie(t, resp)
+
+	resp, err = postWithToken(verifier, token, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Errorf("status = %d from another replica, want %d", resp.StatusCode, fiber.StatusOK)
+	}
+}
+
+func TestCSRFSkipsBearerRequests(t *testing.T) {
+	app := newCSRFTestApp(t, "secret")
+
+	req := httptest.NewRequest(fiber.MethodPost, "/", nil)
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer token")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Errorf("status = %d, want %d", resp.StatusCode, fiber.StatusOK)
+	}
+}
@@ -1,15 +1,9 @@
 package controllers
 
 import (
-	"encoding/json"
-	"fmt"
-	"io"
-	"net/http"
-	"net/url"
-	"strings"
-	"time"
-
+	"github.com/dimo-network/trips-web-app/api/internal/auth"
 	"github.com/dimo-network/trips-web-app/api/internal/config"
+	"github.com/dimo-network/trips-web-app/api/internal/dimo"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
@@ -24,108 +18,54 @@ type SignatureRequest struct {
 	Signature string `json:"signature"`
 }
 
-func HandleGenerateChallenge(c *fiber.Ctx, settings *config.Settings) error {
+// HandleGenerateChallenge fetches a challenge for the posted address to sign.
+func HandleGenerateChallenge(c *fiber.Ctx, client *dimo.Client) error {
 	var challengeReq ChallengeRequest
 	if err := c.BodyParser(&challengeReq); err != nil {
 		return c.Status(fiber.StatusBadRequest).SendString("Invalid request body")
 	}
 
-	address := challengeReq.Address
-
-	formData := url.Values{}
-	formData.Add("client_id", settings.ClientID)
-	formData.Add("domain", settings.Domain)
-	formData.Add("scope", settings.Scope)
-	formData.Add("response_type", settings.ResponseType)
-	formData.Add("address", address)
-
-	encodedFormData := formData.Encode()
-	reqURL := settings.AuthURL
-
-	resp, err := http.Post(reqURL, "application/x-www-form-urlencoded", strings.NewReader(encodedFormData))
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).SendString("Failed to make request to external service")
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
+	challenge, err := client.GenerateChallenge(c.UserContext(), challengeReq.Address)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).SendString("Error reading external response")
-	}
-
-	var apiResp ChallengeResponse
-	if err := json.Unmarshal(body, &apiResp); err != nil {
-		return c.Status(fiber.StatusInternalServerError).SendString("Error processing response from external service")
+		log.Error().Err(err).Msg("Error generating challenge")
+		return upstreamErrorJSON(c, err, "Failed to generate challenge")
 	}
 
-	if apiResp.State == "" || apiResp.Challenge == "" {
-		return c.Status(fiber.StatusInternalServerError).SendString("State or Challenge incomplete from external service")
-	}
-
-	log.Info().Msgf("Response from generate challenge: %+v", apiResp)
-
-	return c.JSON(apiResp)
+	return c.JSON(challenge)
 }
 
-func HandleSubmitChallenge(c *fiber.Ctx, settings *config.Settings) error {
+// HandleSubmitChallenge trades a signed challenge for tokens and starts a session with them.
+func HandleSubmitChallenge(c *fiber.Ctx, settings *config.Settings, client *dimo.Client, verifier *auth.Verifier, store SessionStore) error {
 	var signatureReq SignatureRequest
 	if err := c.BodyParser(&signatureReq); err != nil {
 		return c.Status(fiber.StatusBadRequest).SendString("Invalid request body")
 	}
 
-	log.Info().Msgf("State: %s, Signature: %s", signatureReq.State, signatureReq.Signature)
-
-	formData := url.Values{}
-	formData.Add("client_id", settings.ClientID)
-	formData.Add("domain", settings.Domain)
-	formData.Add("grant_type", settings.GrantType)
-	formData.Add("state", signatureReq.State)
-	formData.Add("signature", signatureReq.Signature)
-
-	log.Info().Msgf("Response from submit challenge: %+v", formData)
-
-	encodedFormData := formData.Encode()
-	reqURL := settings.SubmitChallengeURL
-
-	resp, err := http.Post(reqURL, "application/x-www-form-urlencoded", strings.NewReader(encodedFormData))
+	resp, err := client.SubmitChallenge(c.UserContext(), signatureReq.State, signatureReq.Signature)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).SendString("Failed to make request to external service")
-	}
-	defer resp.Body.Close()
-
-	// Check the HTTP status code here
-	if resp.StatusCode >= 300 {
-		return c.Status(fiber.StatusInternalServerError).SendString(fmt.Sprintf("Received non-success status code: %d", resp.StatusCode))
+		log.Error().Err(err).Msg("Error submitting challenge")
+		return upstreamErrorJSON(c, err, "Failed to submit challenge")
 	}
+	tokens := TokenResponse(*resp)
 
-	respBody, err := io.ReadAll(resp.Body)
+	claims, err := verifier.Verify(c.UserContext(), tokens.IDToken)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).SendString("Failed to read response from external service")
+		log.Warn().Err(err).Msg("Rejected token from submit challenge")
+		return c.Status(fiber.StatusUnauthorized).SendString("Invalid token from external service")
 	}
 
-	var responseMap map[string]interface{}
-	if err := json.Unmarshal(respBody, &responseMap); err != nil {
-		return c.Status(fiber.StatusInternalServerError).SendString("Error processing response")
-	}
-
-	log.Info().Msgf("Response from submit challenge: %+v", responseMap) //debugging
-
-	token, exists := responseMap["id_token"]
-	if !exists {
-		return c.Status(fiber.StatusInternalServerError).SendString("Token not found in response")
-	}
+	// jwt token storage; access and refresh tokens are kept alongside when the auth server issues them
+	session := &Session{EthereumAddress: claims.EthereumAddress}
+	tokens.apply(session)
+	session.Expiry = claims.ExpiresAt.Time
 
-	//jwt token storage
 	sessionID := uuid.New().String()
-	CacheInstance.Set(sessionID, token, 2*time.Hour)
-
-	cookie := new(fiber.Cookie)
-	cookie.Name = "session_id"
-	cookie.Value = sessionID
-	cookie.Expires = time.Now().Add(2 * time.Hour)
-	cookie.HTTPOnly = true
+	if err := saveSession(c.UserContext(), store, sessionID, session); err != nil {
+		log.Error().Err(err).Msg("Error storing session")
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to start session")
+	}
 
-	c.Cookie(cookie)
+	setSessionCookie(c, settings, sessionID)
 
-	return c.JSON(fiber.Map{"message": "Challenge accepted and session started!", "id_token": token})
+	return c.JSON(fiber.Map{"message": "Challenge accepted and session started!", "id_token": tokens.IDToken})
 }
@@ -0,0 +1,225 @@
+package controllers
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/dimo-network/trips-web-app/api/internal/config"
+	"github.com/dimo-network/trips-web-app/api/internal/dimo"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// maxHarshSampleGap is the longest time between two samples that a change of speed is still judged over.
+	// Across longer gaps the aggregated speeds smooth out any sudden change, so they would only ever hide one.
+	maxHarshSampleGap = 10 * time.Second
+	// maxHarshSamples bounds the detailed speed series. Trips too long to fit it at the coarsest of harshIntervals
+	// are judged on the map's own samples.
+	maxHarshSamples = 5000
+)
+
+// harshIntervals are the intervals the detailed speed series is fetched at, finest first. They are fixed, whatever
+// interval the map is drawn at, since a harsh stop is over in a few seconds.
+var harshIntervals = []time.Duration{
+	2 * time.Second,
+	5 * time.Second,
+}
+
+const (
+	eventHarshAcceleration = "harsh_acceleration"
+	eventHarshBraking      = "harsh_braking"
+	eventSpeeding          = "speeding"
+	eventIdling            = "idling"
+)
+
+// DrivingEvent is something worth coaching a driver on, at the time and place it started. Speed is the peak
+// speed, and Rate the peak change of speed per second for harsh events, both in the user's unit.
+type DrivingEvent struct {
+	Type            string  `json:"type"`
+	Time            string  `json:"time"`
+	Latitude        float64 `json:"latitude"`
+	Longitude       float64 `json:"longitude"`
+	DurationSeconds float64 `json:"durationSeconds"`
+	Speed           float64 `json:"speed"`
+	Rate            float64 `json:"rate,omitempty"`
+}
+
+// drivingThresholds are the limits driving events are detected at, from the settings. A zero limit turns that
+// kind of event off.
+type drivingThresholds struct {
+	accelerationKmhPerSecond float64
+	brakingKmhPerSecond      float64
+	speedingKmh              float64
+	speedingMin              time.Duration
+	idlingMin                time.Duration
+}
+
+func drivingThresholdsFrom(settings *config.Settings) drivingThresholds {
+	return drivingThresholds{
+		accelerationKmhPerSecond: float64(settings.HarshAccelKmhPerSec),
+		brakingKmhPerSecond:      float64(settings.HarshBrakeKmhPerSec),
+		speedingKmh:              float64(settings.SpeedingThresholdKmh),
+		speedingMin:              time.Duration(settings.SpeedingMinSeconds) * time.Second,
+		idlingMin:                time.Duration(settings.IdlingMinSeconds) * time.Second,
+	}
+}
+
+func (t drivingThresholds) detectsHarshEvents() bool {
+	return t.accelerationKmhPerSecond > 0 || t.brakingKmhPerSecond > 0
+}
+
+// harshInterval picks the finest of harshIntervals that keeps a trip of the given length to maxHarshSamples.
+func harshInterval(duration time.Duration) (time.Duration, bool) {
+	for _, interval := range harshIntervals {
+		if duration/interval <= maxHarshSamples {
+			return interval, true
+		}
+	}
+	return 0, false
+}
+
+// detailedSpeedSamples fetches the trip picked by query again as a fine-grained, averaged speed series, cleaned like
+// the track, for when the harshDetail param asks for it. The map's own samples are too coarse for harsh events on
+// all but short trips, and their peak speeds understate how sharply speed changed, but the extra Telemetry API
+// call isn't worth making on every load. The track is returned as it is when it is already as fine, the trip is
+// too long for a detailed series, or the fetch fails.
+func detailedSpeedSamples(c *fiber.Ctx, client *dimo.Client, store SessionStore, query dimo.SignalsQuery, zones []PrivacyZone, locations []LocationData) []LocationData {
+	interval, ok := harshInterval(query.To.Sub(query.From))
+	if !ok {
+		return locations
+	}
+	if sampled := samplingInterval(locations); sampled > 0 && sampled <= interval {
+		return locations
+	}
+
+	fine := dimo.SignalsQuery{
+		TokenID:      query.TokenID,
+		Interval:     formatInterval(interval),
+		From:         query.From,
+		To:           query.To,
+		AverageSpeed: true,
+	}
+	samples, err := queryTelemetryData(fine, client, store, c)
+	if err != nil {
+		log.Warn().Err(err).Int64("tokenId", query.TokenID).Msg("Failed to fetch detailed speeds for driving events")
+		return locations
+	}
+	samples, _ = cleanTrack(samples, zones)
+	return samples
+}
+
+// harshDetectionAvailable reports whether harsh events can show up in samples at all. Changes of speed are only
+// judged between samples up to maxHarshSampleGap apart, so a series sampled more coarsely never has any.
+func harshDetectionAvailable(samples []LocationData, thresholds drivingThresholds) bool {
+	return !thresholds.detectsHarshEvents() || samplingInterval(samples) <= maxHarshSampleGap
+}
+
+// detectDrivingEvents finds harsh acceleration and braking, sustained speeding and long idling in a cleaned series
+// of samples, in time order. Only samples with a speed are used, and nothing is judged across a segment gap, so the
+// events stay clear of privacy zones.
+func detectDrivingEvents(locations []LocationData, thresholds drivingThresholds, prefs SpeedPreferences) []DrivingEvent {
+	events := []DrivingEvent{}
+	samples := speedFixes(locations)
+
+	events = append(events, harshEvents(samples, thresholds, prefs)...)
+	events = append(events, sustainedEvents(samples, eventSpeeding, thresholds.speedingMin, prefs, func(kmh float64) bool {
+		return thresholds.speedingKmh > 0 && kmh > thresholds.speedingKmh
+	})...)
+	events = append(events, sustainedEvents(samples, eventIdling, thresholds.idlingMin, prefs, func(kmh float64) bool {
+		return thresholds.idlingMin > 0 && kmh <= idleSpeedKmh
+	})...)
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time < events[j].Time })
+	return events
+}
+
+// speedFixes are the timed fixes that have a speed.
+func speedFixes(locations []LocationData) []timedFix {
+	var fixes []timedFix
+	for _, fix := range timedFixes(locations) {
+		if fix.Speed != nil {
+			fixes = append(fixes, fix)
+		}
+	}
+	return fixes
+}
+
+// harshEvents flags every pair of close samples whose speed changes faster than the thresholds allow. Runs of such
+// pairs in the same direction are one event.
+func harshEvents(samples []timedFix, thresholds drivingThresholds, prefs SpeedPreferences) []DrivingEvent {
+	var (
+		events []DrivingEvent
+		// last is the event the previous pair was part of, which this pair extends if it's the same kind
+		last *DrivingEvent
+	)
+	for i := 1; i < len(samples); i++ {
+		from, to := samples[i-1], samples[i]
+		elapsed := to.at.Sub(from.at)
+		if from.Segment != to.Segment || elapsed <= 0 || elapsed > maxHarshSampleGap {
+			last = nil
+			continue
+		}
+
+		rate := (*to.Speed - *from.Speed) / elapsed.Seconds()
+		var eventType string
+		switch {
+		case thresholds.accelerationKmhPerSecond > 0 && rate >= thresholds.accelerationKmhPerSecond:
+			eventType = eventHarshAcceleration
+		case thresholds.brakingKmhPerSecond > 0 && -rate >= thresholds.brakingKmhPerSecond:
+			eventType = eventHarshBraking
+		default:
+			last = nil
+			continue
+		}
+
+		if last != nil && last.Type == eventType {
+			last.DurationSeconds += elapsed.Seconds()
+			last.Speed = math.Max(last.Speed, prefs.speed(math.Max(*from.Speed, *to.Speed)))
+			last.Rate = math.Max(last.Rate, prefs.speed(math.Abs(rate)))
+		} else {
+			events = append(events, DrivingEvent{
+				Type:            eventType,
+				Time:            from.Timestamp,
+				Latitude:        *from.Latitude,
+				Longitude:       *from.Longitude,
+				DurationSeconds: elapsed.Seconds(),
+				Speed:           prefs.speed(math.Max(*from.Speed, *to.Speed)),
+				Rate:            prefs.speed(math.Abs(rate)),
+			})
+			last = &events[len(events)-1]
+		}
+	}
+	return events
+}
+
+// sustainedEvents finds runs of consecutive samples in one segment that all match, lasting at least minimum.
+func sustainedEvents(samples []timedFix, eventType string, minimum time.Duration, prefs SpeedPreferences, matches func(kmh float64) bool) []DrivingEvent {
+	var events []DrivingEvent
+	for start := 0; start < len(samples); {
+		if !matches(*samples[start].Speed) {
+			start++
+			continue
+		}
+		end := start
+		peak := *samples[start].Speed
+		for end+1 < len(samples) && samples[end+1].Segment == samples[start].Segment && matches(*samples[end+1].Speed) {
+			end++
+			peak = math.Max(peak, *samples[end].Speed)
+		}
+
+		if duration := samples[end].at.Sub(samples[start].at); duration >= minimum && duration > 0 {
+			events = append(events, DrivingEvent{
+				Type:            eventType,
+				Time:            samples[start].Timestamp,
+				Latitude:        *samples[start].Latitude,
+				Longitude:       *samples[start].Longitude,
+				DurationSeconds: duration.Seconds(),
+				Speed:           prefs.speed(peak),
+			})
+		}
+		start = end + 1
+	}
+	return events
+}
@@ -0,0 +1,165 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dimo-network/trips-web-app/api/internal/dimo"
+	"github.com/dimo-network/trips-web-app/api/internal/session"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestHarshInterval(t *testing.T) {
+	tests := []struct {
+		duration time.Duration
+		want     time.Duration
+		ok       bool
+	}{
+		{duration: 20 * time.Minute, want: 2 * time.Second, ok: true},
+		{duration: 2 * time.Hour, want: 2 * time.Second, ok: true},
+		{duration: 4 * time.Hour, want: 5 * time.Second, ok: true},
+		{duration: 8 * time.Hour, ok: false},
+	}
+	for _, tt := range tests {
+		got, ok := harshInterval(tt.duration)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("harshInterval(%s) = %s, %v, want %s, %v", tt.duration, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestDetectDrivingEventsFindsEveryKindInOneSeries(t *testing.T) {
+	prefs := SpeedPreferences{Unit: unitKmh}
+	thresholds := drivingThresholds{
+		accelerationKmhPerSecond: 12,
+		brakingKmhPerSecond:      14,
+		speedingKmh:              100,
+		speedingMin:              4 * time.Second,
+		idlingMin:                6 * time.Second,
+	}
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+
+	var samples []LocationData
+	for i, kmh := range []float64{110, 110, 110, 60, 20, 0, 0, 0, 0, 30} {
+		samples = append(samples, statsSample(start.Add(time.Duration(2*i)*time.Second), 52.5+float64(i)*0.0005, kmh, 0))
+	}
+
+	events := detectDrivingEvents(samples, thresholds, prefs)
+	want := []struct {
+		eventType string
+		seconds   float64
+	}{
+		{eventSpeeding, 4},
+		{eventHarshBraking, 4},
+		{eventIdling, 6},
+		{eventHarshAcceleration, 2},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("detectDrivingEvents() = %+v, want %d events", events, len(want))
+	}
+	for i, w := range want {
+		if events[i].Type != w.eventType || events[i].DurationSeconds != w.seconds {
+			t.Errorf("event %d = %s over %g s, want %s over %g s", i, events[i].Type, events[i].DurationSeconds, w.eventType, w.seconds)
+		}
+	}
+	if braking := events[1]; braking.Rate != 25 || braking.Speed != 110 {
+		t.Errorf("braking rate = %g up to %g, want 25 up to 110", braking.Rate, braking.Speed)
+	}
+}
+
+func TestHarshDetectionAvailable(t *testing.T) {
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	series := func(every time.Duration) []LocationData {
+		var samples []LocationData
+		for i := 0; i < 5; i++ {
+			samples = append(samples, statsSample(start.Add(time.Duration(i)*every), 52.5, 30, 0))
+		}
+		return samples
+	}
+	harsh := drivingThresholds{brakingKmhPerSecond: 14}
+
+	tests := []struct {
+		name       string
+		samples    []LocationData
+		thresholds drivingThresholds
+		want       bool
+	}{
+		{"fine samples", series(2 * time.Second), harsh, true},
+		{"samples at the limit", series(maxHarshSampleGap), harsh, true},
+		{"coarse samples", series(30 * time.Second), harsh, false},
+		{"harsh events off", series(30 * time.Second), drivingThresholds{speedingKmh: 100}, true},
+		{"no samples", nil, harsh, true},
+	}
+	for _, tt := range tests {
+		if got := harshDetectionAvailable(tt.samples, tt.thresholds); got != tt.want {
+			t.Errorf("%s: harshDetectionAvailable() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMapDataOnlyFetchesDetailedSpeedsWhenAsked(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         string
+		harshBrakes   int
+		wantTelemetry int
+	}{
+		{name: "default", query: "", harshBrakes: 14, wantTelemetry: 1},
+		{name: "detailed", query: "&harshDetail=true", harshBrakes: 14, wantTelemetry: 2},
+		{name: "detailed without harsh events", query: "&harshDetail=true", harshBrakes: 0, wantTelemetry: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFakeDIMO(t)
+			settings := upstream.settings()
+			settings.SpeedBands = "30:blue,50:green"
+			settings.SpeedOverLimitColor = "red"
+			settings.SpeedUnit = unitKmh
+			settings.HarshBrakeKmhPerSec = tt.harshBrakes
+			settings.SpeedingThresholdKmh = 100
+			client := dimo.NewClient(&settings)
+
+			store := session.NewMemoryStore()
+			// the trip was listed in this session, so it resolves to vehicle 7 without a lookup
+			if err := store.Set(context.Background(), tripVehiclePrefix("session-1")+"trip-0", "7", time.Hour); err != nil {
+				t.Fatal(err)
+			}
+			app := fiber.New()
+			app.Use(withTestSession("session-1", &Session{EthereumAddress: testEthAddress, IDToken: "id-token"}))
+			app.Get("/api/trip/:tripID", func(c *fiber.Ctx) error {
+				return HandleMapDataForTrip(c, &settings, client, store, c.Params("tripID"), c.Query("start"), c.Query("end"), nil)
+			})
+
+			url := "/api/trip/trip-0?start=2024-05-01T08:00:00Z&end=2024-05-01T08:20:00Z" + tt.query
+			resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, url, nil), -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != fiber.StatusOK {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, fiber.StatusOK)
+			}
+			var body struct {
+				Events                    []DrivingEvent `json:"events"`
+				HarshDetectionUnavailable bool           `json:"harshDetectionUnavailable"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := upstream.callCount("telemetry"); got != tt.wantTelemetry {
+				t.Errorf("Telemetry API called %d times, want %d", got, tt.wantTelemetry)
+			}
+			// the fake's samples are 10 s apart, close enough to judge harsh events on
+			if body.HarshDetectionUnavailable {
+				t.Error("harsh detection reported unavailable")
+			}
+			if body.Events == nil {
+				t.Error("events = null, want a list")
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package controllers
+
+import "math"
+
+const earthRadiusKm = 6371.0088
+
+// haversineKm is the great-circle distance between two points in kilometres.
+func haversineKm(lat1, lon1, lat2, lon2 float64) float64 {
+	toRadians := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dLat := toRadians(lat2 - lat1)
+	dLon := toRadians(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// pathDistanceKm sums the distance between consecutive samples, skipping samples without a position. Only legs
+// within a segment count: where the track was split at a gap in the telemetry or a privacy zone, where the vehicle
+// went in between isn't known, and the straight line across would be a guess.
+func pathDistanceKm(locations []LocationData) float64 {
+	var (
+		total float64
+		prev  *LocationData
+	)
+	for i := range locations {
+		loc := &locations[i]
+		if loc.Latitude == nil || loc.Longitude == nil {
+			continue
+		}
+		if prev != nil && prev.Segment == loc.Segment {
+			total += haversineKm(*prev.Latitude, *prev.Longitude, *loc.Latitude, *loc.Longitude)
+		}
+		prev = loc
+	}
+	return total
+}
+
+// maxSpeedKmh is the highest speed sampled, or zero when none were.
+func maxSpeedKmh(locations []LocationData) float64 {
+	var max float64
+	for _, loc := range locations {
+		if loc.Speed != nil && *loc.Speed > max {
+			max = *loc.Speed
+		}
+	}
+	return max
+}
+
+// initialBearing is the compass direction, in degrees clockwise from north, of the great circle from the first
+// point towards the second.
+func initialBearing(lat1, lon1, lat2, lon2 float64) float64 {
+	toRadians := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	phi1, phi2 := toRadians(lat1), toRadians(lat2)
+	dLon := toRadians(lon2 - lon1)
+	y := math.Sin(dLon) * math.Cos(phi2)
+	x := math.Cos(phi1)*math.Sin(phi2) - math.Sin(phi1)*math.Cos(phi2)*math.Cos(dLon)
+
+	return math.Mod(math.Atan2(y, x)*180/math.Pi+360, 360)
+}
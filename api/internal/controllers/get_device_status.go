@@ -1,14 +1,9 @@
 package controllers
 
 import (
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
 
-	"github.com/rs/zerolog/log"
-
-	"github.com/dimo-network/trips-web-app/api/internal/config"
+	"github.com/dimo-network/trips-web-app/api/internal/dimo"
 	"github.com/gofiber/fiber/v2"
 	"github.com/pkg/errors"
 )
@@ -59,43 +54,11 @@ func ProcessRawDeviceStatus(rawDeviceStatus map[string]interface{}) DeviceStatus
 	return entries
 }
 
-func QueryDeviceDataAPI(tokenID int64, settings *config.Settings, c *fiber.Ctx) (map[string]interface{}, error) {
-	var rawDeviceStatus map[string]interface{}
-
-	privilegeToken, err := RequestPriviledgeToken(c, settings, tokenID)
-
-	if err != nil {
-		return rawDeviceStatus, errors.Wrap(err, "error getting privilege token")
-	}
-
-	url := fmt.Sprintf("%s/vehicle/%d/status-raw", settings.DeviceDataAPIURL, tokenID)
-	log.Debug().Msgf("Request URL: %s", url)
-
-	req, err := http.NewRequest("GET", url, nil)
+func QueryDeviceDataAPI(tokenID int64, client *dimo.Client, store SessionStore, c *fiber.Ctx) (dimo.RawDeviceStatus, error) {
+	privilegeToken, err := RequestPriviledgeToken(c, client, store, tokenID)
 	if err != nil {
-		return rawDeviceStatus, err
-	}
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", *privilegeToken))
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return rawDeviceStatus, err
-	}
-	defer resp.Body.Close()
-
-	// Read the raw response body
-	responseBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Error().Interface("response", resp).Msgf("Error reading response body: %v", err)
-		return rawDeviceStatus, err
-	}
-
-	// Dynamically parse the JSON response
-	if err := json.Unmarshal(responseBody, &rawDeviceStatus); err != nil {
-		log.Error().Str("body", string(responseBody)).Msgf("Error parsing JSON response: %v", err)
-		return rawDeviceStatus, err
+		return nil, errors.Wrap(err, "error getting privilege token")
 	}
 
-	return rawDeviceStatus, nil
+	return client.DeviceStatus(c.UserContext(), *privilegeToken, tokenID)
 }
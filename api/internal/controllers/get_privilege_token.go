@@ -1,95 +1,80 @@
 package controllers
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
-	"net/http"
 	"time"
 
-	"github.com/dimo-network/trips-web-app/api/internal/config"
+	"github.com/dimo-network/trips-web-app/api/internal/dimo"
 	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 )
 
-func RequestPriviledgeToken(c *fiber.Ctx, settings *config.Settings, tokenID int64) (*string, error) {
-	sessionCookie := c.Cookies("session_id")
-	privilegeTokenKey := fmt.Sprintf("privilegeToken_%s_%d", sessionCookie, tokenID)
+// privilegeTokenTTL is how long an exchanged privilege token is reused before a fresh one is fetched.
+const privilegeTokenTTL = 30 * time.Second
 
-	privilegeToken, exists := CacheInstance.Get(privilegeTokenKey)
-
-	if exists {
-		privilegeTokenString, ok := privilegeToken.(string)
-		if !ok {
-			return nil, fmt.Errorf("privilege token value is not valid")
-		}
-		return &privilegeTokenString, nil
-	}
-
-	jwtToken, found := CacheInstance.Get(sessionCookie)
-	if !found {
-		return nil, fmt.Errorf("JWT token not found in cache")
-	}
-
-	accessToken, ok := jwtToken.(string)
-	if !ok {
-		return nil, fmt.Errorf("JWT token value is not valid")
-	}
-
-	privileges := []int{1, 2, 3, 4, 5, 6}
-	requestBody := map[string]interface{}{
-		"nftContractAddress": settings.PrivilegeNFTContractAddr,
-		"privileges":         privileges,
-		"tokenID":            tokenID,
-	}
-
-	requestBodyBytes, err := json.Marshal(requestBody)
+func RequestPriviledgeToken(c *fiber.Ctx, client *dimo.Client, store SessionStore, tokenID int64) (*string, error) {
+	tokens, err := sessionPrivilegeTokens(c, client, store)
 	if err != nil {
-		return nil, fmt.Errorf("error marshalling request body")
+		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", settings.TokenExchangeAPIURL, bytes.NewBuffer(requestBodyBytes))
+	privilegeToken, err := tokens.token(c.UserContext(), tokenID, false)
 	if err != nil {
-		return nil, fmt.Errorf("error creating request to token exchange API")
+		return nil, err
 	}
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
-	req.Header.Set("Content-Type", "application/json")
+	return &privilegeToken, nil
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// privilegeTokens hands out privilege tokens for one session without needing its request, so work that outlives
+// the request, such as a streamed export, can keep fetching them as they expire.
+type privilegeTokens struct {
+	client    *dimo.Client
+	store     SessionStore
+	sessionID string
+	idToken   string
+}
 
+func sessionPrivilegeTokens(c *fiber.Ctx, client *dimo.Client, store SessionStore) (*privilegeTokens, error) {
+	sessionID, session, err := currentSession(c)
 	if err != nil {
-		return nil, fmt.Errorf("error making request to token exchange API")
+		return nil, err
 	}
-	defer resp.Body.Close()
+	return &privilegeTokens{client: client, store: store, sessionID: sessionID, idToken: session.IDToken}, nil
+}
 
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("error reading response from token exchange API")
-	}
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("invalid response from token exchange server: %d,  %s", resp.StatusCode, string(respBody))
-	}
+// token returns a privilege token for tokenID, reusing the session's cached one unless fresh is set, as it should
+// be once the upstream has rejected the cached token.
+func (p *privilegeTokens) token(ctx context.Context, tokenID int64, fresh bool) (string, error) {
+	privilegeTokenKey := fmt.Sprintf("%s%d", privilegeTokenPrefix(p.sessionID), tokenID)
 
-	var responseMap map[string]interface{}
-	if err := json.Unmarshal(respBody, &responseMap); err != nil {
-		log.Error().Err(err).Str("body", string(respBody)).Msg("Error processing response")
-		return nil, fmt.Errorf("error processing response from token exchange API")
+	if !fresh {
+		privilegeToken, exists, err := p.store.Get(ctx, privilegeTokenKey)
+		if err != nil {
+			return "", errors.Wrap(err, "error reading privilege token from session store")
+		}
+		if exists {
+			return privilegeToken, nil
+		}
 	}
 
-	token, exists := responseMap["token"]
-	if !exists {
-		log.Error().Interface("response", responseMap).Msg("Token not found in response")
-		return nil, fmt.Errorf("token not found in response from token exchange API")
+	privileges := []int64{1, 2, 3, 4, 5, 6}
+	privilegeToken, err := p.client.ExchangeToken(ctx, p.idToken, tokenID, privileges)
+	if err != nil {
+		return "", errors.Wrap(err, "error exchanging token")
 	}
 
-	privilegeTokenString, ok := token.(string)
-	if !ok {
-		return nil, fmt.Errorf("token value is not valid")
+	if err := p.store.Set(ctx, privilegeTokenKey, privilegeToken, privilegeTokenTTL); err != nil {
+		log.Error().Err(err).Msg("Error caching privilege token")
 	}
 
-	CacheInstance.Set(privilegeTokenKey, token, time.Second*30)
+	return privilegeToken, nil
+}
 
-	return &privilegeTokenString, nil
+// unauthorized reports whether an upstream turned a request away for its token, which for a privilege token
+// usually means it has expired.
+func unauthorized(err error) bool {
+	var apiErr *dimo.APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == fiber.StatusUnauthorized
 }
@@ -2,31 +2,33 @@ package controllers
 
 import (
 	"github.com/dimo-network/trips-web-app/api/internal/config"
+	"github.com/dimo-network/trips-web-app/api/internal/dimo"
 	"github.com/gofiber/fiber/v2"
 	"github.com/rs/zerolog/log"
 )
 
 type StreamrController struct {
 	settings config.Settings
+	client   *dimo.Client
 }
 
-func NewStreamrController(settings config.Settings) StreamrController {
-	return StreamrController{settings: settings}
+func NewStreamrController(settings config.Settings, client *dimo.Client) StreamrController {
+	return StreamrController{settings: settings, client: client}
 }
 
 func (tc *StreamrController) GetStreamr(c *fiber.Ctx) error {
 	ethAddress := c.Locals("ethereum_address").(string)
 
-	vehicles, err := QueryIdentityAPIForVehicles(ethAddress, &tc.settings)
+	vehicles, err := tc.client.OwnedVehicles(c.UserContext(), ethAddress)
 	if err != nil {
 		log.Printf("Error querying My Vehicles: %v", err)
-		return c.Status(fiber.StatusInternalServerError).SendString("Error querying my vehicles: " + err.Error())
+		return renderUpstreamError(c, err, "Error querying my vehicles")
 	}
 
-	sharedVehicles, err := QuerySharedVehicles(ethAddress, &tc.settings)
+	sharedVehicles, err := tc.client.SharedVehicles(c.UserContext(), ethAddress)
 	if err != nil {
 		log.Printf("Error querying Shared Vehicles: %v", err)
-		return c.Status(fiber.StatusInternalServerError).SendString("Error querying shared vehicles: " + err.Error())
+		return renderUpstreamError(c, err, "Error querying shared vehicles")
 	}
 	return c.Render("streamr_live", fiber.Map{
 		"Title":          "Streamr Live",
@@ -1,93 +1,54 @@
 package controllers
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
 	"sort"
 	"strconv"
 	"time"
 
 	"github.com/dimo-network/trips-web-app/api/internal/config"
+	"github.com/dimo-network/trips-web-app/api/internal/dimo"
+	"github.com/dimo-network/trips-web-app/api/internal/geocode"
 	"github.com/gofiber/fiber/v2"
 	geojson "github.com/paulmach/go.geojson"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 )
 
-type Trip struct {
-	ID    string    `json:"id"`
-	Start TripPoint `json:"start"`
-	End   TripPoint `json:"end"`
-}
-
-// EstimatedLocation
-type TripPoint struct {
-	Time              string  `json:"time"`
-	Location          LatLon  `json:"location"`
-	EstimatedLocation *LatLon `json:"estimatedLocation"`
-}
-
-// LatLon represents latitude and longitude coordinates.
-type LatLon struct {
-	Latitude  float64 `json:"latitude"`
-	Longitude float64 `json:"longitude"`
-}
-
 type TimeEntry struct {
 	Time string `json:"time"`
 }
 
-type TripsResponse struct {
-	Trips []Trip `json:"trips"`
-}
-
-var TripIDToTokenIDMap = make(map[string]int64)
-
 type LocationData struct {
 	Longitude *float64
 	Latitude  *float64
 	Speed     *float64
 	Timestamp string
-}
-
-type TelemetryAPIResponse struct {
-	Data struct {
-		Signals []Signal `json:"signals"`
-	} `json:"data"`
-
-	Errors []struct {
-		Message string   `json:"message"`
-		Path    []string `json:"path"`
-	} `json:"errors"`
-}
-
-type Signal struct {
-	Timestamp                time.Time `json:"timestamp"`
-	CurrentLocationLongitude *float64  `json:"currentLocationLongitude"`
-	CurrentLocationLatitude  *float64  `json:"currentLocationLatitude"`
-	Speed                    *float64  `json:"speed"`
-}
-
-var SpeedGradient = []struct {
-	Threshold float64
-	Color     string
-}{
-	{10, "blue"},
-	{30, "green"},
-	{50, "yellow"},
-	{70, "orange"},
-	{90, "red"},
+	// Signals holds the extra signals the caller asked for, keyed by their dimo.ExtraSignals name.
+	Signals map[string]float64
+	// Segment numbers the stretches of track between gaps in the telemetry; see cleanTrack.
+	Segment int
 }
 
 type TripsController struct {
 	settings config.Settings
+	client   *dimo.Client
+	store    SessionStore
+	// geocoder names where trips start and end; nil leaves them unnamed
+	geocoder geocode.Provider
+	places   *placeCache
+}
+
+func NewTripsController(settings config.Settings, client *dimo.Client, store SessionStore, geocoder geocode.Provider) TripsController {
+	return TripsController{settings: settings, client: client, store: store, geocoder: geocoder, places: newPlaceCache(store, maxRecentPlaces)}
 }
 
-func NewTripsController(settings config.Settings) TripsController {
-	return TripsController{settings: settings}
+// tripListItem is a trip as the trips list shows it, with the places it started and ended at.
+type tripListItem struct {
+	dimo.Trip
+	Places TripPlaces
 }
 
 func (t *TripsController) HandleTripsList(c *fiber.Ctx) error {
@@ -98,148 +59,266 @@ func (t *TripsController) HandleTripsList(c *fiber.Ctx) error {
 		})
 	}
 
-	trips, err := QueryTripsAPI(tokenID, &t.settings, c)
+	trips, pagination, err := t.tripsPage(c, tokenID)
 	if err != nil {
+		var badRequest *fiber.Error
+		if errors.As(err, &badRequest) {
+			return c.Status(badRequest.Code).JSON(fiber.Map{"error": badRequest.Message})
+		}
 		log.Error().Err(err).Msg("Failed to query trips API")
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		if _, open := circuitOpen(err); open {
+			return renderUpstreamError(c, err, "Failed to fetch trips")
+		}
+		return c.Status(upstreamStatus(err)).JSON(fiber.Map{
 			"error": "Failed to fetch trips",
 		})
 	}
 
-	// Populate TripIDToTokenIDMap
-	for _, trip := range trips {
-		TripIDToTokenIDMap[trip.ID] = tokenID
+	// only cached names, so the page isn't held up by the geocoder; the rest are filled in by HandleTripPlaces
+	places := resolveTripPlaces(c.UserContext(), t.geocoder, t.places, trips, false)
+	items := make([]tripListItem, len(trips))
+	for i, trip := range trips {
+		items[i] = tripListItem{Trip: trip, Places: places[i]}
 	}
 
 	return c.Render("vehicle_trips", fiber.Map{
-		"TokenID": tokenID,
-		"Trips":   trips,
+		"TokenID":    tokenID,
+		"Trips":      items,
+		"From":       c.Query("from"),
+		"To":         c.Query("to"),
+		"Pagination": pagination,
 	})
 }
 
-func QueryTripsAPI(tokenID int64, settings *config.Settings, c *fiber.Ctx) ([]Trip, error) {
-	var tripsResponse TripsResponse
-	privilegeToken, err := RequestPriviledgeToken(c, settings, tokenID)
-
+// HandleTripPlaces answers /vehicles/:tokenid/trips/places with the start and end names of the same page of trips
+// the list shows for the same params, keyed by trip ID, looking up any that aren't cached yet. The trips page
+// calls it after loading for the rows whose names were still pending.
+func (t *TripsController) HandleTripPlaces(c *fiber.Ctx) error {
+	tokenID, err := strconv.ParseInt(c.Params("tokenid"), 10, 64)
 	if err != nil {
-		return []Trip{}, errors.Wrap(err, "error getting privilege token")
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid token ID"})
 	}
 
-	url := fmt.Sprintf("%s/vehicle/%d/trips", settings.TripsAPIBaseURL, tokenID)
-
-	req, err := http.NewRequest("GET", url, nil)
+	trips, _, err := t.tripsPage(c, tokenID)
 	if err != nil {
-		return nil, err
+		return apiError(c, err, "Failed to fetch trips")
 	}
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", *privilegeToken))
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+	places := resolveTripPlaces(c.UserContext(), t.geocoder, t.places, trips, true)
+	byTrip := make(map[string]TripPlaces, len(trips))
+	for i, trip := range trips {
+		byTrip[trip.ID] = places[i]
 	}
-	defer resp.Body.Close()
 
-	// Read the raw response body
-	responseBody, err := io.ReadAll(resp.Body)
+	return c.JSON(fiber.Map{"places": byTrip})
+}
+
+// tripsPage loads the page of a vehicle's trips picked by the from, to, page and limit query params. Invalid
+// params are returned as a 400 *fiber.Error.
+func (t *TripsController) tripsPage(c *fiber.Ctx, tokenID int64) ([]dimo.Trip, Pagination, error) {
+	from, to, err := parseTripRange(c)
 	if err != nil {
-		log.Error().Interface("response", resp).Msgf("Error reading response body: %v", err)
-		return nil, err
+		return nil, Pagination{}, fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	page, limit, err := parsePaging(c)
+	if err != nil {
+		return nil, Pagination{}, fiber.NewError(fiber.StatusBadRequest, err.Error())
 	}
 
-	log.Info().Msgf("Trips API response body: %s", string(responseBody))
+	var (
+		trips      []dimo.Trip
+		pagination Pagination
+	)
+	if from.IsZero() && to.IsZero() {
+		trips, pagination, err = t.upstreamTripsPage(c, tokenID, page, limit)
+		if err != nil {
+			return nil, Pagination{}, err
+		}
+	} else {
+		var truncated bool
+		trips, truncated, err = QueryTripsAPI(tokenID, t.client, t.store, c)
+		if err != nil {
+			return nil, Pagination{}, err
+		}
 
-	// Dynamically parse the JSON response
-	if err := json.Unmarshal(responseBody, &tripsResponse); err != nil {
-		log.Error().Str("body", string(responseBody)).Msgf("Error parsing JSON response: %v", err)
-		return nil, err
+		trips = filterTripsByRange(trips, from, to)
+		pagination = paginate(c, len(trips), page, limit)
+		pagination.Truncated = truncated
+		trips = trips[pagination.start:pagination.end]
 	}
 
-	log.Info().Msgf("Fetched trips: %+v", tripsResponse.Trips)
+	zones, err := userPrivacyZones(c, t.store)
+	if err != nil {
+		return nil, Pagination{}, err
+	}
+	hideTripEnds(trips, zones)
 
-	sort.Slice(tripsResponse.Trips, func(i, j int) bool {
-		return tripsResponse.Trips[i].End.Time > tripsResponse.Trips[j].End.Time
-	})
+	rememberTrips(c, t.store, tokenID, trips)
 
-	// 20 latest trips
-	latestTrips := tripsResponse.Trips
-	if len(latestTrips) > 20 {
-		latestTrips = latestTrips[:20]
+	return trips, pagination, nil
+}
+
+// upstreamTripsPage loads one page of trips straight from the Trips API, for listings without a date range that
+// don't need the whole history. The Trips API pages a vehicle's trips newest first, so its pages follow on from
+// each other in the same order as the full history; each page is sorted as well, so trips ending at nearly the same
+// time still list the way they do in a date-filtered view. The API reports how many pages there are but not how
+// many trips, and may not honour limit, so the total is left unknown rather than guessed.
+func (t *TripsController) upstreamTripsPage(c *fiber.Ctx, tokenID int64, page, limit int) ([]dimo.Trip, Pagination, error) {
+	privilegeToken, err := RequestPriviledgeToken(c, t.client, t.store, tokenID)
+	if err != nil {
+		return nil, Pagination{}, errors.Wrap(err, "error getting privilege token")
 	}
 
-	for _, trip := range latestTrips {
-		TripIDToTokenIDMap[trip.ID] = tokenID
-		if trip.Start.EstimatedLocation != nil {
-			log.Info().Msgf("Trip ID: %s, EstimatedLocation: %+v", trip.ID, *trip.Start.EstimatedLocation)
+	resp, err := t.client.Trips(c.UserContext(), *privilegeToken, tokenID, page, limit)
+	if err != nil {
+		return nil, Pagination{}, err
+	}
+	// a page past the end is clamped to the last one, as paginate does
+	if page > 1 && page > resp.TotalPages && resp.TotalPages > 0 {
+		page = resp.TotalPages
+		if resp, err = t.client.Trips(c.UserContext(), *privilegeToken, tokenID, page, limit); err != nil {
+			return nil, Pagination{}, err
 		}
-
 	}
 
-	return latestTrips, nil
+	trips := resp.Trips
+	sortTripsNewestFirst(trips)
+
+	return trips, upstreamPagination(c, page, limit, resp.TotalPages), nil
 }
 
-func queryTelemetryData(tokenID int64, startTime string, endTime string, settings *config.Settings, c *fiber.Ctx) ([]LocationData, error) {
-	graphqlQuery := fmt.Sprintf(` 
-	{
-	  signals(
-		tokenId: %d
-		interval: "30s"
-		from: "%s"
-		to: "%s"
-	  ) {
-		timestamp
-		speed(agg: MAX)
-		currentLocationLatitude(agg: AVG)
-		currentLocationLongitude(agg: AVG)
-	  }
-	}`, tokenID, startTime, endTime)
-
-	requestPayload := GraphQLRequest{Query: graphqlQuery}
-	payloadBytes, err := json.Marshal(requestPayload)
+// QueryTripsAPI returns a vehicle's whole trip history, newest first, and whether it was cut short at the Trips
+// API page limit. The history is cached for the session briefly, so paging through it doesn't walk every Trips API
+// page on each click.
+func QueryTripsAPI(tokenID int64, client *dimo.Client, store SessionStore, c *fiber.Ctx) ([]dimo.Trip, bool, error) {
+	sessionID, _, err := currentSession(c)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
-	privilegeToken, err := RequestPriviledgeToken(c, settings, tokenID)
+	cacheKey := fmt.Sprintf("%s%d", tripsCachePrefix(sessionID), tokenID)
+	if cached, found, err := store.Get(c.UserContext(), cacheKey); err != nil {
+		log.Warn().Err(err).Msg("Failed to read cached trips")
+	} else if found {
+		var history tripHistory
+		if err := json.Unmarshal([]byte(cached), &history); err == nil {
+			return history.Trips, history.Truncated, nil
+		}
+	}
+
+	privilegeToken, err := RequestPriviledgeToken(c, client, store, tokenID)
 	if err != nil {
-		return nil, errors.Wrap(err, "error getting privilege token")
+		return []dimo.Trip{}, false, errors.Wrap(err, "error getting privilege token")
 	}
 
-	req, err := http.NewRequest("POST", settings.TelemetryAPIURL, bytes.NewBuffer(payloadBytes))
+	trips, truncated, err := client.AllTrips(c.UserContext(), *privilegeToken, tokenID)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", *privilegeToken))
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	log.Info().Int64("tokenId", tokenID).Int("trips", len(trips)).Bool("truncated", truncated).Msg("Fetched trips")
+
+	sortTripsNewestFirst(trips)
+
+	if raw, err := json.Marshal(tripHistory{Trips: trips, Truncated: truncated}); err == nil {
+		if err := store.Set(c.UserContext(), cacheKey, string(raw), tripsCacheTTL); err != nil {
+			log.Warn().Err(err).Msg("Failed to cache trips")
+		}
+	}
+
+	return trips, truncated, nil
+}
+
+// tripHistory is how QueryTripsAPI caches a vehicle's trips.
+type tripHistory struct {
+	Trips     []dimo.Trip `json:"trips"`
+	Truncated bool        `json:"truncated"`
+}
+
+func sortTripsNewestFirst(trips []dimo.Trip) {
+	sort.Slice(trips, func(i, j int) bool {
+		return trips[i].End.Time > trips[j].End.Time
+	})
+}
+
+// parseTripRange reads the optional from and to query params, each either an RFC 3339 timestamp or a
+// YYYY-MM-DD date. A date in to covers the whole day.
+func parseTripRange(c *fiber.Ctx) (from, to time.Time, err error) {
+	if raw := c.Query("from"); raw != "" {
+		if from, err = parseTripBound(raw, false); err != nil {
+			return from, to, errors.New("from must be a date or an RFC 3339 timestamp")
+		}
+	}
+	if raw := c.Query("to"); raw != "" {
+		if to, err = parseTripBound(raw, true); err != nil {
+			return from, to, errors.New("to must be a date or an RFC 3339 timestamp")
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		return from, to, errors.New("to must not be before from")
+	}
+	return from, to, nil
+}
+
+func parseTripBound(raw string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	day, err := time.Parse("2006-01-02", raw)
 	if err != nil {
-		return nil, err
+		return time.Time{}, err
+	}
+	if endOfDay {
+		return day.Add(24*time.Hour - time.Nanosecond), nil
+	}
+	return day, nil
+}
+
+// filterTripsByRange keeps the trips that started within [from, to]. A zero bound is open.
+func filterTripsByRange(trips []dimo.Trip, from, to time.Time) []dimo.Trip {
+	if from.IsZero() && to.IsZero() {
+		return trips
+	}
+
+	filtered := make([]dimo.Trip, 0, len(trips))
+	for _, trip := range trips {
+		start, err := time.Parse(time.RFC3339, trip.Start.Time)
+		if err != nil {
+			continue
+		}
+		if (!from.IsZero() && start.Before(from)) || (!to.IsZero() && start.After(to)) {
+			continue
+		}
+		filtered = append(filtered, trip)
 	}
-	defer resp.Body.Close()
+	return filtered
+}
 
-	body, err := io.ReadAll(resp.Body)
+func queryTelemetryData(query dimo.SignalsQuery, client *dimo.Client, store SessionStore, c *fiber.Ctx) ([]LocationData, error) {
+	privilegeToken, err := RequestPriviledgeToken(c, client, store, query.TokenID)
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrap(err, "error getting privilege token")
 	}
-	log.Info().Msgf("Telemetry API response body: %s", string(body))
 
-	var respData TelemetryAPIResponse
-	if err := json.Unmarshal(body, &respData); err != nil {
+	return fetchLocations(c.UserContext(), client, *privilegeToken, query)
+}
+
+// fetchLocations loads a vehicle's location and speed samples with a privilege token already in hand. It doesn't
+// touch the request, so bulk exports can call it from worker goroutines.
+func fetchLocations(ctx context.Context, client *dimo.Client, privilegeToken string, query dimo.SignalsQuery) ([]LocationData, error) {
+	signals, err := client.Signals(ctx, privilegeToken, query)
+	if err != nil {
 		return nil, err
 	}
-	if len(respData.Errors) > 0 {
-		log.Error().Interface("errors", respData.Errors).Msg("Error in telemetry API response")
-	}
 
-	locations := make([]LocationData, 0, len(respData.Data.Signals))
-	for _, signal := range respData.Data.Signals {
+	locations := make([]LocationData, 0, len(signals))
+	for _, signal := range signals {
 		loc := LocationData{
-			Timestamp: signal.Timestamp.String(),
+			Timestamp: signal.Timestamp.UTC().Format(time.RFC3339),
 			Latitude:  signal.CurrentLocationLatitude,
 			Longitude: signal.CurrentLocationLongitude,
 			Speed:     signal.Speed,
+			Signals:   signal.Values,
 		}
 		locations = append(locations, loc)
 	}
@@ -247,91 +326,196 @@ func queryTelemetryData(tokenID int64, startTime string, endTime string, setting
 	return locations, nil
 }
 
-func HandleMapDataForTrip(c *fiber.Ctx, settings *config.Settings, tripID, startTime, endTime string, estimatedStart *LatLon) error {
-	tokenID, exists := TripIDToTokenIDMap[tripID]
-	if !exists {
-		log.Error().Msgf("Trip not found for tripID: %s", tripID)
-		return c.Status(fiber.StatusNotFound).SendString("Trip not found")
+// HandleMapDataForTrip answers /api/trip/:tripID with the trip's cleaned track, its speed gradient, stats and driving
+// events in the user's units, and a report of the samples cleaning dropped or hid in the user's privacy zones. The
+// optional geometry, simplify and tolerance params shrink the track drawn for long trips; the stats are still
+// worked out from every sample that survived cleaning. harshDetail=true judges driving events on a detailed speed
+// series instead, at the cost of a second Telemetry API call.
+func HandleMapDataForTrip(c *fiber.Ctx, settings *config.Settings, client *dimo.Client, store SessionStore, tripID, startTime, endTime string, estimatedStart *dimo.LatLon) error {
+	shape, err := trackShapeQuery(c)
+	if err != nil {
+		return tripError(c, tripID, err)
 	}
 
-	log.Info().Msgf("Fetching map data for TripID: %s, StartTime: %s, EndTime: %s, TokenID: %d", tripID, startTime, endTime, tokenID)
+	zones, err := userPrivacyZones(c, store)
+	if err != nil {
+		return tripError(c, tripID, err)
+	}
 
-	// Fetch telemetry data
-	locations, err := queryTelemetryData(tokenID, startTime, endTime, settings, c)
+	prefs, err := speedPreferences(c, settings, store)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to fetch historical data")
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch historical data: " + err.Error()})
+		return tripError(c, tripID, err)
 	}
 
-	if len(locations) == 0 {
-		log.Warn().Msg("No location data received")
+	locations, query, err := tripLocations(c, client, store, tripID, startTime, endTime)
+	if err != nil {
+		return tripError(c, tripID, err)
+	}
+	locations, cleaning := cleanTrack(locations, zones)
+
+	// driving events are judged on the samples already fetched for the map unless a detailed series is asked for
+	thresholds := drivingThresholdsFrom(settings)
+	eventSamples := locations
+	if c.QueryBool("harshDetail") && thresholds.detectsHarshEvents() {
+		eventSamples = detailedSpeedSamples(c, client, store, query, zones, locations)
+	}
+	estimatedStart = maskLocation(estimatedStart, zones)
+
+	track := locations
+	if shape.tolerance > 0 {
+		track = simplifyTrack(locations, shape.algorithm, shape.tolerance)
 	}
 
-	geoJSON := convertToGeoJSON(locations, estimatedStart)
-	speedGradient := calculateSpeedGradient(locations)
+	geoJSON := trackGeoJSON(track, estimatedStart, shape.geometry, prefs)
+	speedGradient := calculateSpeedGradient(track, prefs)
 
 	response := map[string]interface{}{
 		"geojson":       geoJSON,
 		"speedGradient": speedGradient,
+		"stats":         calculateTripStats(locations, prefs),
+		"events":        detectDrivingEvents(eventSamples, thresholds, prefs),
+		"units":         prefs.units(),
+		"cleaning":      cleaning,
+		// harsh acceleration and braking couldn't be looked for, because the samples are too far apart
+		"harshDetectionUnavailable": !harshDetectionAvailable(eventSamples, thresholds),
 	}
 
 	return c.JSON(response)
 }
 
-func convertToGeoJSON(locations []LocationData, estimatedStart *LatLon) *geojson.FeatureCollection {
+// tripLocations checks the caller may see tripID and fetches its telemetry between startTime and endTime, returning
+// the query it ran as well. Bad params, unknown trips and missing privileges are returned as a *fiber.Error;
+// anything else came from upstream.
+func tripLocations(c *fiber.Ctx, client *dimo.Client, store SessionStore, tripID, startTime, endTime string) ([]LocationData, dimo.SignalsQuery, error) {
+	query, err := tripTelemetryQuery(c, client, store, tripID, startTime, endTime)
+	if err != nil {
+		return nil, query, err
+	}
+
+	log.Info().Msgf("Fetching map data for TripID: %s, StartTime: %s, EndTime: %s, TokenID: %d, Interval: %s", tripID, startTime, endTime, query.TokenID, query.Interval)
+
+	locations, err := queryTelemetryData(query, client, store, c)
+	if err != nil {
+		return nil, query, errors.Wrap(err, "error fetching historical data")
+	}
+
+	if len(locations) == 0 {
+		log.Warn().Msg("No location data received")
+	}
+
+	return locations, query, nil
+}
+
+// tripTelemetryQuery checks the caller may see tripID and builds the Telemetry API query for it, without running it.
+func tripTelemetryQuery(c *fiber.Ctx, client *dimo.Client, store SessionStore, tripID, startTime, endTime string) (dimo.SignalsQuery, error) {
+	var query dimo.SignalsQuery
+
+	// tokenId is optional; it lets trips this session hasn't listed yet be looked up
+	hint, err := strconv.ParseInt(c.Query("tokenId", "0"), 10, 64)
+	if err != nil {
+		return query, fiber.NewError(fiber.StatusBadRequest, "Invalid token ID")
+	}
+
+	tokenID, err := resolveTripVehicle(c, client, store, tripID, hint)
+	switch {
+	case errors.Is(err, errTripNotFound):
+		log.Error().Msgf("Trip not found for tripID: %s", tripID)
+		return query, fiber.NewError(fiber.StatusNotFound, "Trip not found")
+	case errors.Is(err, errNoPrivilege):
+		return query, fiber.NewError(fiber.StatusForbidden, "You do not have location access to this vehicle")
+	case err != nil:
+		return query, errors.Wrap(err, "error looking up trip")
+	}
+
+	// the range ends up in a Telemetry API query, so only well-formed timestamps are let through
+	start, err := time.Parse(time.RFC3339, startTime)
+	if err != nil {
+		return query, fiber.NewError(fiber.StatusBadRequest, "start must be an RFC 3339 timestamp")
+	}
+	end, err := time.Parse(time.RFC3339, endTime)
+	if err != nil {
+		return query, fiber.NewError(fiber.StatusBadRequest, "end must be an RFC 3339 timestamp")
+	}
+	if end.Before(start) {
+		return query, fiber.NewError(fiber.StatusBadRequest, "end must not be before start")
+	}
+
+	query, err = telemetryQuery(c, tokenID, start, end)
+	if err != nil {
+		return query, fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	return query, nil
+}
+
+// tripError answers a trip request that tripLocations failed.
+func tripError(c *fiber.Ctx, tripID string, err error) error {
+	var badRequest *fiber.Error
+	if errors.As(err, &badRequest) {
+		return c.Status(badRequest.Code).JSON(fiber.Map{"error": badRequest.Message})
+	}
+
+	log.Error().Err(err).Str("tripId", tripID).Msg("Failed to fetch trip data")
+	return upstreamErrorJSON(c, err, "Failed to fetch trip data")
+}
+
+// ParseEstimatedStart reads the optional estimatedStart query param, a JSON {latitude, longitude} object.
+func ParseEstimatedStart(c *fiber.Ctx) (*dimo.LatLon, error) {
+	raw := c.Query("estimatedStart")
+	if raw == "" {
+		return nil, nil
+	}
+
+	var estimatedStart *dimo.LatLon
+	if err := json.Unmarshal([]byte(raw), &estimatedStart); err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Invalid estimated start location")
+	}
+	return estimatedStart, nil
+}
+
+// convertToGeoJSON returns the track as a Point feature per sample, with speeds in the user's unit.
+func convertToGeoJSON(locations []LocationData, estimatedStart *dimo.LatLon, prefs SpeedPreferences) *geojson.FeatureCollection {
 	featureCollection := geojson.NewFeatureCollection()
 
 	// Add the estimated start location if it exists
 	if estimatedStart != nil {
-		estimatedStartFeature := geojson.NewPointFeature([]float64{estimatedStart.Longitude, estimatedStart.Latitude})
-		estimatedStartFeature.Properties["point_type"] = "estimated_start"
-		estimatedStartFeature.Properties["privacy_zone"] = 1
-		estimatedStartFeature.Properties["color"] = "black"
-		featureCollection.AddFeature(estimatedStartFeature)
+		featureCollection.AddFeature(estimatedStartFeature(estimatedStart))
 	}
 
 	// Iterate through the locations and add each as a point feature
-	for i, loc := range locations {
-		if loc.Longitude != nil && loc.Latitude != nil {
-			point := geojson.NewPointFeature([]float64{*loc.Longitude, *loc.Latitude})
-			if loc.Speed != nil {
-				point.Properties["speed"] = *loc.Speed
-			}
-			point.Properties["timestamp"] = loc.Timestamp
-			point.Properties["privacy_zone"] = 1
-			point.Properties["color"] = "black"
-
-			// Mark the last point as the end point
-			if i == len(locations)-1 {
-				point.Properties["point_type"] = "end"
-				point.Properties["privacy_zone"] = 1
-				point.Properties["color"] = "red"
-			}
-
-			featureCollection.AddFeature(point)
+	points := positionedLocations(locations)
+	for i, loc := range points {
+		point := geojson.NewPointFeature([]float64{*loc.Longitude, *loc.Latitude})
+		if loc.Speed != nil {
+			point.Properties["speed"] = prefs.speed(*loc.Speed)
 		}
+		point.Properties["timestamp"] = loc.Timestamp
+		for name, value := range loc.Signals {
+			point.Properties[name] = value
+		}
+		point.Properties["segment"] = loc.Segment
+		point.Properties["color"] = "black"
+
+		// Mark the first and last points with a position as the start and end points
+		switch i {
+		case len(points) - 1:
+			point.Properties["point_type"] = "end"
+			point.Properties["color"] = "red"
+		case 0:
+			point.Properties["point_type"] = "start"
+		}
+
+		featureCollection.AddFeature(point)
 	}
 
 	return featureCollection
 }
 
-func calculateSpeedGradient(locations []LocationData) []string {
+// calculateSpeedGradient is the colour of each sample's speed, for drawing the track as a gradient.
+func calculateSpeedGradient(locations []LocationData, prefs SpeedPreferences) []string {
 	colors := make([]string, len(locations))
 	for i, loc := range locations {
-		if loc.Speed != nil {
-			colors[i] = getSpeedColor(*loc.Speed)
-		} else {
-			colors[i] = "black" // Default color if speed data is missing
-		}
+		colors[i] = prefs.speedColor(loc.Speed)
 	}
 	return colors
 }
-
-func getSpeedColor(speed float64) string {
-	for _, sg := range SpeedGradient {
-		if speed <= sg.Threshold {
-			return sg.Color
-		}
-	}
-	return "black"
-}
@@ -0,0 +1,250 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dimo-network/trips-web-app/api/internal/config"
+	"github.com/dimo-network/trips-web-app/api/internal/dimo"
+	"github.com/dimo-network/trips-web-app/api/internal/session"
+	"github.com/gofiber/fiber/v2"
+)
+
+// fakeDIMO stands in for the Trips, Token Exchange and Identity APIs. Trips are served in pages as given.
+type fakeDIMO struct {
+	*httptest.Server
+
+	mu sync.Mutex
+	// tripPages holds each vehicle's Trips API pages, in the order the API serves them
+	tripPages map[int64][][]dimo.Trip
+	// access is what the Identity API reports for each vehicle
+	access map[int64]dimo.VehicleAccess
+	// tokenUses makes the Telemetry API reject each privilege token with a 401 once it has been used this many
+	// times, as if it had expired; 0 never does
+	tokenUses  int
+	usedTokens map[string]int
+	calls      map[string]int
+}
+
+func newFakeDIMO(t *testing.T) *fakeDIMO {
+	t.Helper()
+	f := &fakeDIMO{
+		tripPages:  map[int64][][]dimo.Trip{},
+		access:     map[int64]dimo.VehicleAccess{},
+		usedTokens: map[string]int{},
+		calls:      map[string]int{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token-exchange", func(w http.ResponseWriter, r *http.Request) {
+		var req dimo.TokenExchangeRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		calls := f.count("token-exchange")
+		_ = json.NewEncoder(w).Encode(dimo.TokenExchangeResponse{Token: fmt.Sprintf("privilege-%d-%d", req.TokenID, calls)})
+	})
+	mux.HandleFunc("/trips/vehicle/", func(w http.ResponseWriter, r *http.Request) {
+		f.count("trips")
+		tokenID, _ := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/trips/vehicle/"), "/trips"), 10, 64)
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+
+		f.mu.Lock()
+		pages := f.tripPages[tokenID]
+		f.mu.Unlock()
+		resp := dimo.TripsResponse{Trips: []dimo.Trip{}, CurrentPage: page, TotalPages: len(pages)}
+		if page >= 1 && page <= len(pages) {
+			resp.Trips = pages[page-1]
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	})
+	mux.HandleFunc("/identity", func(w http.ResponseWriter, r *http.Request) {
+		f.count("identity")
+		var req dimo.GraphQLRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		tokenID, _ := req.Variables["tokenId"].(float64)
+
+		f.mu.Lock()
+		access := f.access[int64(tokenID)]
+		f.mu.Unlock()
+		var data struct {
+			Vehicle struct {
+				Owner      string `json:"owner"`
+				Privileges struct {
+					Nodes []dimo.Privilege `json:"nodes"`
+				} `json:"privileges"`
+			} `json:"vehicle"`
+		}
+		data.Vehicle.Owner = access.Owner
+		data.Vehicle.Privileges.Nodes = access.Privileges
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	})
+	mux.HandleFunc("/telemetry", func(w http.ResponseWriter, r *http.Request) {
+		f.count("telemetry")
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		f.mu.Lock()
+		f.usedTokens[token]++
+		expired := f.tokenUses > 0 && f.usedTokens[token] > f.tokenUses
+		f.mu.Unlock()
+		if expired {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var req dimo.GraphQLRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		from, _ := time.Parse(time.RFC3339, req.Variables["from"].(string))
+		signals := make([]map[string]interface{}, 3)
+		for i := range signals {
+			signals[i] = map[string]interface{}{
+				"timestamp":                from.Add(time.Duration(i) * 10 * time.Second).Format(time.RFC3339),
+				"currentLocationLatitude":  52.5,
+				"currentLocationLongitude": 13.4 + float64(i)*0.001,
+				"speed":                    30,
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"signals": signals}})
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeDIMO) count(endpoint string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls[endpoint]++
+	return f.calls[endpoint]
+}
+
+func (f *fakeDIMO) callCount(endpoint string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[endpoint]
+}
+
+func (f *fakeDIMO) settings() config.Settings {
+	return config.Settings{
+		TripsAPIBaseURL:     f.URL + "/trips",
+		TokenExchangeAPIURL: f.URL + "/token-exchange",
+		IdentityAPIURL:      f.URL + "/identity",
+		TelemetryAPIURL:     f.URL + "/telemetry",
+		APIMaxRetries:       -1,
+	}
+}
+
+// withTestSession runs handlers as if AuthMiddleware had let the request through for session sessionID.
+func withTestSession(sessionID string, s *Session) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Locals("ethereum_address", s.EthereumAddress)
+		c.Locals("session_id", sessionID)
+		c.Locals("session", s)
+		return c.Next()
+	}
+}
+
+// testTrips makes n trips ending an hour apart, newest first, with IDs prefix-0, prefix-1, ...
+func testTrips(prefix string, newest time.Time, n int) []dimo.Trip {
+	trips := make([]dimo.Trip, n)
+	for i := range trips {
+		end := newest.Add(-time.Duration(i) * time.Hour)
+		trips[i] = dimo.Trip{
+			ID:    fmt.Sprintf("%s-%d", prefix, i),
+			Start: dimo.TripPoint{Time: end.Add(-30 * time.Minute).Format(time.RFC3339)},
+			End:   dimo.TripPoint{Time: end.Format(time.RFC3339)},
+		}
+	}
+	return trips
+}
+
+type tripsPageResponse struct {
+	IDs        []string   `json:"ids"`
+	Pagination Pagination `json:"pagination"`
+}
+
+func getTripsPage(t *testing.T, app *fiber.App, query string) tripsPageResponse {
+	t.Helper()
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/vehicles/7/trips?"+query, nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	var page tripsPageResponse
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	return page
+}
+
+func TestTripsPageFollowsUpstreamPagesNewestFirst(t *testing.T) {
+	upstream := newFakeDIMO(t)
+	newest := time.Date(2024, 5, 1, 18, 0, 0, 0, time.UTC)
+	history := testTrips("trip", newest, 5)
+	// the Trips API pages newest first; within a page, trips may come in any order
+	upstream.tripPages[7] = [][]dimo.Trip{
+		{history[1], history[0]},
+		{history[2], history[3]},
+		{history[4]},
+	}
+
+	settings := upstream.settings()
+	store := session.NewMemoryStore()
+	tc := NewTripsController(settings, dimo.NewClient(&settings), store, nil)
+	app := fiber.New()
+	app.Use(withTestSession("session-1", &Session{EthereumAddress: testEthAddress, IDToken: "id-token"}))
+	app.Get("/vehicles/:tokenid/trips", func(c *fiber.Ctx) error {
+		trips, pagination, err := tc.tripsPage(c, 7)
+		if err != nil {
+			return err
+		}
+		ids := make([]string, len(trips))
+		for i, trip := range trips {
+			ids[i] = trip.ID
+		}
+		return c.JSON(tripsPageResponse{IDs: ids, Pagination: pagination})
+	})
+
+	var listed []string
+	for page := 1; page <= 3; page++ {
+		got := getTripsPage(t, app, fmt.Sprintf("page=%d&limit=2", page))
+		listed = append(listed, got.IDs...)
+
+		if !got.Pagination.TotalUnknown || got.Pagination.Total != 0 {
+			t.Errorf("page %d: total = %d, unknown %v; want an unknown total", page, got.Pagination.Total, got.Pagination.TotalUnknown)
+		}
+		if got.Pagination.TotalPages != 3 {
+			t.Errorf("page %d: totalPages = %d, want 3", page, got.Pagination.TotalPages)
+		}
+		if (got.Pagination.NextURL != "") != (page < 3) {
+			t.Errorf("page %d: next = %q", page, got.Pagination.NextURL)
+		}
+	}
+	if want := []string{"trip-0", "trip-1", "trip-2", "trip-3", "trip-4"}; strings.Join(listed, ",") != strings.Join(want, ",") {
+		t.Errorf("pages listed %v, want %v", listed, want)
+	}
+	// one Trips API call per page, and none to count the total
+	if got := upstream.callCount("trips"); got != 3 {
+		t.Errorf("Trips API called %d times, want 3", got)
+	}
+
+	// a page past the end shows the last one
+	if got := getTripsPage(t, app, "page=9&limit=2"); got.Pagination.Page != 3 || strings.Join(got.IDs, ",") != "trip-4" {
+		t.Errorf("page past the end = page %d %v, want page 3 [trip-4]", got.Pagination.Page, got.IDs)
+	}
+
+	// a date range searches the whole history and can count it
+	got := getTripsPage(t, app, "from=2024-05-01&limit=2")
+	if got.Pagination.TotalUnknown || got.Pagination.Total != 5 {
+		t.Errorf("date-filtered total = %d, unknown %v; want 5", got.Pagination.Total, got.Pagination.TotalUnknown)
+	}
+	if strings.Join(got.IDs, ",") != "trip-0,trip-1" {
+		t.Errorf("date-filtered first page = %v, want [trip-0 trip-1]", got.IDs)
+	}
+}
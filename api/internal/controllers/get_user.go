@@ -1,112 +1,84 @@
 package controllers
 
 import (
-	"encoding/json"
-	"fmt"
-	"io"
-	"net/http"
-
 	"github.com/dimo-network/trips-web-app/api/internal/config"
+	"github.com/dimo-network/trips-web-app/api/internal/dimo"
 	"github.com/gofiber/fiber/v2"
 	"github.com/rs/zerolog/log"
 )
 
-type UserResponse struct {
-	Email struct {
-		Address string `json:"address"`
-	} `json:"email"`
-}
-
-func GetEmailFromUsersAPI(c *fiber.Ctx, settings *config.Settings) (string, error) {
-	sessionCookie := c.Cookies("session_id")
-	jwtToken, found := CacheInstance.Get(sessionCookie)
-	if !found {
-		return "", fmt.Errorf("JWT token not found in cache")
-	}
-
-	accessToken, ok := jwtToken.(string)
-	if !ok {
-		return "", fmt.Errorf("JWT token value is not valid")
-	}
-
-	url := fmt.Sprintf("%s/user", settings.UsersAPIBaseURL)
-
-	req, err := http.NewRequest("GET", url, nil)
+func GetEmailFromUsersAPI(c *fiber.Ctx, client *dimo.Client) (string, error) {
+	_, session, err := currentSession(c)
 	if err != nil {
 		return "", err
 	}
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	user, err := client.User(c.UserContext(), session.IDToken)
 	if err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
 
-	responseBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Error().Interface("response", resp).Msgf("Error reading response body: %v", err)
-		return "", err
-	}
-
-	log.Info().Msgf("API Response Body: %s", string(responseBody))
-
-	var userResponse UserResponse
-	if err := json.Unmarshal(responseBody, &userResponse); err != nil {
-		log.Error().Str("body", string(responseBody)).Msgf("Error parsing JSON response: %v", err)
-		return "", err
-	}
-
-	return userResponse.Email.Address, nil
+	return user.Email.Address, nil
 }
 
 type AccountController struct {
 	settings config.Settings
+	client   *dimo.Client
+	store    SessionStore
 }
 
-func NewAccountController(settings config.Settings) AccountController {
-	return AccountController{settings: settings}
+func NewAccountController(settings config.Settings, client *dimo.Client, store SessionStore) AccountController {
+	return AccountController{settings: settings, client: client, store: store}
+}
+
+// accountPrivileges are the privileges the account page offers to mint tokens for.
+var accountPrivileges = fiber.Map{
+	"1": "1: All-time, non-location data",
+	"4": "4: All-time location",
 }
 
 func (a *AccountController) MyAccount(c *fiber.Ctx) error {
-	sessionCookie := c.Cookies("session_id")
-	if sessionCookie == "" {
-		fmt.Println("No session_id cookie")
+	_, session, err := currentSession(c)
+	if err != nil {
 		return c.Render("session_expired", fiber.Map{})
 	}
 
-	// check if the session_id is in the cache
-	jwtToken, found := CacheInstance.Get(sessionCookie)
-	if !found {
-		fmt.Println("Session expired")
-		return c.Render("session_expired", fiber.Map{})
+	vehicles, err := a.accountVehicles(c, c.Locals("ethereum_address").(string))
+	if err != nil {
+		return renderUpstreamError(c, err, "Error querying identity API")
 	}
 
-	ethAddress := c.Locals("ethereum_address").(string)
+	zones, err := userPrivacyZones(c, a.store)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load privacy zones")
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to load privacy zones")
+	}
+
+	return c.Render("account", fiber.Map{
+		"Token":        session.IDToken,
+		"Privileges":   accountPrivileges,
+		"Vehicles":     vehicles,
+		"PrivacyZones": zones,
+		// without a durable store, zones and speed preferences would be lost on the next restart, so their forms are
+		// turned off
+		"CanSaveSettings": a.store.Durable(),
+	})
+}
 
-	vehicles, err := QueryIdentityAPIForVehicles(ethAddress, &a.settings)
+// accountVehicles lists the vehicles the account can mint tokens for: its own, or the ones shared with it if it
+// owns none.
+func (a *AccountController) accountVehicles(c *fiber.Ctx, ethAddress string) ([]dimo.Vehicle, error) {
+	vehicles, err := a.client.OwnedVehicles(c.UserContext(), ethAddress)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).SendString("Error querying identity API: " + err.Error())
+		return nil, err
 	}
 
 	if len(vehicles) == 0 {
-		vehicles, err = QuerySharedVehicles(ethAddress, &a.settings)
+		vehicles, err = a.client.SharedVehicles(c.UserContext(), ethAddress)
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).SendString("Error querying shared vehicles: " + err.Error())
+			return nil, err
 		}
 	}
 
-	if err != nil {
-		return c.Render("session_expired", fiber.Map{})
-	}
-
-	return c.Render("account", fiber.Map{
-		"Token": jwtToken,
-		"Privileges": fiber.Map{
-			"1": "1: All-time, non-location data",
-			"4": "4: All-time location",
-		},
-		"Vehicles": vehicles,
-	})
+	return vehicles, nil
 }
@@ -1,10 +1,7 @@
 package controllers
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -13,43 +10,22 @@ import (
 	"github.com/rs/zerolog/log"
 
 	"github.com/dimo-network/trips-web-app/api/internal/config"
+	"github.com/dimo-network/trips-web-app/api/internal/dimo"
 	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
 )
 
-type GraphQLRequest struct {
-	Query string `json:"query"`
-}
-
-type Vehicle struct {
-	TokenID  int64 `json:"tokenId"`
-	Earnings struct {
-		TotalTokens string `json:"totalTokens"`
-	} `json:"earnings"`
-	Definition struct {
-		Make  string `json:"make"`
-		Model string `json:"model"`
-		Year  int    `json:"year"`
-	} `json:"definition"`
-	AftermarketDevice struct {
-		Address      string `json:"address"`
-		Serial       string `json:"serial"`
-		Manufacturer struct {
-			Name string `json:"name"`
-		} `json:"manufacturer"`
-	} `json:"aftermarketDevice"`
-	DeviceStatusEntries []DeviceDataEntry `json:"deviceStatusEntries"`
-	Trips               []Trip            `json:"trips"`
-}
-
 type VehiclesController struct {
 	settings config.Settings
+	client   *dimo.Client
+	store    SessionStore
 }
 
-func NewVehiclesController(settings config.Settings) VehiclesController {
-	return VehiclesController{settings: settings}
+func NewVehiclesController(settings config.Settings, client *dimo.Client, store SessionStore) VehiclesController {
+	return VehiclesController{settings: settings, client: client, store: store}
 }
 
-func HandleGiveFeedback(settings *config.Settings) fiber.Handler {
+func HandleGiveFeedback(client *dimo.Client) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		ethAddress, ok := c.Locals("ethereum_address").(string)
 		if !ok {
@@ -57,17 +33,17 @@ func HandleGiveFeedback(settings *config.Settings) fiber.Handler {
 			// For example, return an error or set a default value
 			return c.Status(fiber.StatusBadRequest).SendString("Ethereum address not provided")
 		}
-		email, err := GetEmailFromUsersAPI(c, settings)
+		email, err := GetEmailFromUsersAPI(c, client)
 		if err != nil {
 			log.Error().Err(err).Msg("Error querying User API for email")
-			return c.Status(fiber.StatusInternalServerError).SendString("Error querying user data: " + err.Error())
+			return renderUpstreamError(c, err, "Error querying user data")
 		}
 
 		var deviceType string
-		vehicles, err := QueryIdentityAPIForVehicles(ethAddress, settings)
+		vehicles, err := client.OwnedVehicles(c.UserContext(), ethAddress)
 		if err != nil {
 			log.Error().Err(err).Msg("Error querying My Vehicles")
-			return c.Status(fiber.StatusInternalServerError).SendString("Error querying my vehicles: " + err.Error())
+			return renderUpstreamError(c, err, "Error querying my vehicles")
 		}
 
 		if len(vehicles) > 0 {
@@ -89,26 +65,62 @@ func HandleGiveFeedback(settings *config.Settings) fiber.Handler {
 func (v *VehiclesController) HandleGetVehicles(c *fiber.Ctx) error {
 	ethAddress := c.Locals("ethereum_address").(string)
 
-	vehicles, err := QueryIdentityAPIForVehicles(ethAddress, &v.settings)
+	query, vehicles, page, err := v.vehiclesPage(c, ethAddress)
 	if err != nil {
+		var badRequest *fiber.Error
+		if errors.As(err, &badRequest) {
+			return c.Status(badRequest.Code).SendString(badRequest.Message)
+		}
+		if query.View == "shared" {
+			log.Printf("Error querying Shared Vehicles: %v", err)
+			return renderUpstreamError(c, err, "Error querying shared vehicles")
+		}
 		log.Printf("Error querying My Vehicles: %v", err)
-		return c.Status(fiber.StatusInternalServerError).SendString("Error querying my vehicles: " + err.Error())
+		return renderUpstreamError(c, err, "Error querying my vehicles")
 	}
 
-	sharedVehicles, err := QuerySharedVehicles(ethAddress, &v.settings)
-	if err != nil {
-		log.Printf("Error querying Shared Vehicles: %v", err)
-		return c.Status(fiber.StatusInternalServerError).SendString("Error querying shared vehicles: " + err.Error())
+	title := "My Vehicles"
+	if query.View == "shared" {
+		title = "Vehicles Shared With Me"
 	}
 
 	return c.Render("vehicles", fiber.Map{
-		"Title":          "My Vehicles",
-		"Vehicles":       vehicles,
-		"SharedVehicles": sharedVehicles,
-		"EthAddress":     ethAddress,
+		"Title":       title,
+		"Vehicles":    vehicles,
+		"SharedView":  query.View == "shared",
+		"Query":       query,
+		"SortOptions": query.sortOptions(),
+		"Descending":  query.Order == "desc",
+		"Pagination":  page,
+		"EthAddress":  ethAddress,
 	})
 }
 
+// vehiclesPage loads the page of owned or shared vehicles picked by the view, q, sort, order, page and limit
+// query params. Invalid params are returned as a 400 *fiber.Error.
+func (v *VehiclesController) vehiclesPage(c *fiber.Ctx, ethAddress string) (VehicleListQuery, []dimo.Vehicle, Pagination, error) {
+	query, err := parseVehicleListQuery(c)
+	if err != nil {
+		return query, nil, Pagination{}, fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	var vehicles []dimo.Vehicle
+	if query.View == "shared" {
+		vehicles, err = v.client.SharedVehicles(c.UserContext(), ethAddress)
+	} else {
+		vehicles, err = v.client.OwnedVehicles(c.UserContext(), ethAddress)
+	}
+	if err != nil {
+		return query, nil, Pagination{}, err
+	}
+
+	vehicles = filterVehicles(vehicles, query.Search)
+	sortVehicles(vehicles, query.Sort, query.Order)
+	page := paginate(c, len(vehicles), query.Page, query.Limit)
+
+	return query, vehicles[page.start:page.end], page, nil
+}
+
 func (v *VehiclesController) HandleVehicleStatus(c *fiber.Ctx) error {
 	tokenID, err := strconv.ParseInt(c.Params("tokenid"), 10, 64)
 	if err != nil {
@@ -117,10 +129,13 @@ func (v *VehiclesController) HandleVehicleStatus(c *fiber.Ctx) error {
 		})
 	}
 
-	rawDeviceStatus, err := QueryDeviceDataAPI(tokenID, &v.settings, c)
+	rawDeviceStatus, err := QueryDeviceDataAPI(tokenID, v.client, v.store, c)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to query device data API")
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		if _, open := circuitOpen(err); open {
+			return renderUpstreamError(c, err, "Failed to fetch device status")
+		}
+		return c.Status(upstreamStatus(err)).JSON(fiber.Map{
 			"error": "Failed to fetch device status",
 		})
 	}
@@ -133,104 +148,3 @@ func (v *VehiclesController) HandleVehicleStatus(c *fiber.Ctx) error {
 		"Privileges":          []any{},
 	})
 }
-
-func QueryIdentityAPIForVehicles(ethAddress string, settings *config.Settings) ([]Vehicle, error) {
-	// GraphQL query
-	graphqlQuery := `{
-        vehicles(first: 50, filterBy: { owner: "` + ethAddress + `" }) {
-            nodes {
-                tokenId,
-                earnings {
-                    totalTokens
-                },
-                definition {
-                    make,
-                    model,
-                    year
-                },
-                aftermarketDevice {
-                    address,
-                    serial,
-                    manufacturer {
-                        name
-                    }
-                }
-            }
-        }
-    }`
-
-	return fetchVehiclesWithQuery(graphqlQuery, settings)
-}
-
-func QuerySharedVehicles(ethAddress string, settings *config.Settings) ([]Vehicle, error) {
-	graphqlQuery := `{
-        vehicles(first: 50, filterBy: {privileged: "` + ethAddress + `" }) {
-            nodes {
-                tokenId,
-                name,
-                earnings {
-                    totalTokens
-                },
-                definition {
-                    make,
-                    model,
-                    year
-                },
-                aftermarketDevice {
-                    address,
-                    serial,
-                    manufacturer {
-                        name
-                    }
-                }
-            }
-        }
-    }`
-
-	return fetchVehiclesWithQuery(graphqlQuery, settings)
-}
-
-func fetchVehiclesWithQuery(query string, settings *config.Settings) ([]Vehicle, error) {
-	// GraphQL request
-	requestPayload := GraphQLRequest{Query: query}
-	payloadBytes, err := json.Marshal(requestPayload)
-	if err != nil {
-		return nil, err
-	}
-
-	// POST request
-	req, err := http.NewRequest("POST", settings.IdentityAPIURL, bytes.NewBuffer(payloadBytes))
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	var vehicleResponse struct {
-		Data struct {
-			Vehicles struct {
-				Nodes []Vehicle `json:"nodes"`
-			} `json:"vehicles"`
-		} `json:"data"`
-	}
-
-	if err := json.Unmarshal(body, &vehicleResponse); err != nil {
-		return nil, err
-	}
-
-	vehicles := make([]Vehicle, 0, len(vehicleResponse.Data.Vehicles.Nodes))
-	vehicles = append(vehicles, vehicleResponse.Data.Vehicles.Nodes...)
-
-	return vehicles, nil
-}
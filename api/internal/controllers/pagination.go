@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"net/url"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// Pagination describes the page of a list being rendered, with links to its neighbours that keep the
+// request's other query params. Truncated is set when the list had to be cut short before it was paged, so
+// Total undercounts. TotalUnknown is set when the list was paged upstream by a service that only reports how many
+// pages there are; Total is then left at zero.
+type Pagination struct {
+	Page         int    `json:"page"`
+	Limit        int    `json:"limit"`
+	Total        int    `json:"total"`
+	TotalPages   int    `json:"totalPages"`
+	Truncated    bool   `json:"truncated"`
+	TotalUnknown bool   `json:"totalUnknown,omitempty"`
+	PrevURL      string `json:"prev,omitempty"`
+	NextURL      string `json:"next,omitempty"`
+
+	start int
+	end   int
+}
+
+// parsePaging reads the page and limit query params. page is 1-based; limit defaults to 20 and is capped at 100.
+func parsePaging(c *fiber.Ctx) (page, limit int, err error) {
+	page, limit = 1, defaultPageLimit
+
+	if raw := c.Query("page"); raw != "" {
+		if page, err = strconv.Atoi(raw); err != nil || page < 1 {
+			return 0, 0, errors.New("page must be a positive integer")
+		}
+	}
+	if raw := c.Query("limit"); raw != "" {
+		if limit, err = strconv.Atoi(raw); err != nil || limit < 1 {
+			return 0, 0, errors.New("limit must be a positive integer")
+		}
+		if limit > maxPageLimit {
+			limit = maxPageLimit
+		}
+	}
+
+	return page, limit, nil
+}
+
+// paginate works out which slice of total items falls on page. A page past the end is clamped to the last one.
+func paginate(c *fiber.Ctx, total, page, limit int) Pagination {
+	totalPages := (total + limit - 1) / limit
+	if totalPages == 0 {
+		totalPages = 1
+	}
+	if page > totalPages {
+		page = totalPages
+	}
+
+	p := Pagination{
+		Page:       page,
+		Limit:      limit,
+		Total:      total,
+		TotalPages: totalPages,
+		start:      (page - 1) * limit,
+	}
+	p.end = p.start + limit
+	if p.end > total {
+		p.end = total
+	}
+	p.setLinks(c)
+
+	return p
+}
+
+// upstreamPagination describes a page of a list that was paged by the upstream, which reported only how many
+// pages there are.
+func upstreamPagination(c *fiber.Ctx, page, limit, totalPages int) Pagination {
+	if totalPages < 1 {
+		totalPages = 1
+	}
+	p := Pagination{Page: page, Limit: limit, TotalPages: totalPages, TotalUnknown: true}
+	p.setLinks(c)
+
+	return p
+}
+
+// setLinks points PrevURL and NextURL at the pages either side of p, where there are any.
+func (p *Pagination) setLinks(c *fiber.Ctx) {
+	if p.Page > 1 {
+		p.PrevURL = pageURL(c, p.Page-1)
+	}
+	if p.Page < p.TotalPages {
+		p.NextURL = pageURL(c, p.Page+1)
+	}
+}
+
+// pageURL links to the current path with page replaced and every other query param kept.
+func pageURL(c *fiber.Ctx, page int) string {
+	values := url.Values{}
+	for key, value := range c.Queries() {
+		values.Set(key, value)
+	}
+	values.Set("page", strconv.Itoa(page))
+
+	return c.Path() + "?" + values.Encode()
+}
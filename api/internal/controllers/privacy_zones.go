@@ -0,0 +1,219 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/dimo-network/trips-web-app/api/internal/dimo"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	maxPrivacyZones       = 10
+	minZoneRadiusMeters   = 50.0
+	maxZoneRadiusMeters   = 5000.0
+	maxZonePolygonSize    = 100
+	maxPrivacyZoneNameLen = 50
+)
+
+// PrivacyZone is an area, such as home or work, whose fixes never leave the server. It is either a circle, set by
+// Center and RadiusMeters, or a Polygon of [longitude, latitude] vertices.
+type PrivacyZone struct {
+	ID           string       `json:"id"`
+	Name         string       `json:"name"`
+	Center       *dimo.LatLon `json:"center,omitempty"`
+	RadiusMeters float64      `json:"radiusMeters,omitempty"`
+	Polygon      [][]float64  `json:"polygon,omitempty"`
+}
+
+// privacyZonesKey is where a user's zones are kept. They belong to the account rather than a session, so they
+// outlive logging out and are shared by the cookie and bearer sessions of the same address. They are only ever
+// saved to a durable store; see updateAccountData.
+func privacyZonesKey(ethAddress string) string {
+	return "privacyZones_" + strings.ToLower(ethAddress)
+}
+
+func (z PrivacyZone) validate() error {
+	if z.Name == "" || len(z.Name) > maxPrivacyZoneNameLen {
+		return errors.Errorf("name must be between 1 and %d characters", maxPrivacyZoneNameLen)
+	}
+	switch {
+	case z.Center != nil && z.Polygon == nil:
+		if !validPosition(z.Center.Latitude, z.Center.Longitude) {
+			return errors.New("center must be a valid latitude and longitude")
+		}
+		if z.RadiusMeters < minZoneRadiusMeters || z.RadiusMeters > maxZoneRadiusMeters {
+			return errors.Errorf("radiusMeters must be between %g and %g", minZoneRadiusMeters, maxZoneRadiusMeters)
+		}
+	case z.Center == nil && z.Polygon != nil:
+		if len(z.Polygon) < 3 || len(z.Polygon) > maxZonePolygonSize {
+			return errors.Errorf("polygon must have between 3 and %d vertices", maxZonePolygonSize)
+		}
+		for _, vertex := range z.Polygon {
+			if len(vertex) != 2 || !validPosition(vertex[1], vertex[0]) {
+				return errors.New("polygon vertices must be valid [longitude, latitude] pairs")
+			}
+		}
+	default:
+		return errors.New("a zone needs either a center and radiusMeters, or a polygon")
+	}
+	return nil
+}
+
+// contains reports whether a position falls inside the zone.
+func (z PrivacyZone) contains(latitude, longitude float64) bool {
+	if z.Center != nil {
+		return haversineKm(z.Center.Latitude, z.Center.Longitude, latitude, longitude)*1000 <= z.RadiusMeters
+	}
+
+	// ray casting; zones are small enough for longitude and latitude to be treated as planar
+	inside := false
+	for i, j := 0, len(z.Polygon)-1; i < len(z.Polygon); j, i = i, i+1 {
+		xi, yi := z.Polygon[i][0], z.Polygon[i][1]
+		xj, yj := z.Polygon[j][0], z.Polygon[j][1]
+		if (yi > latitude) != (yj > latitude) && longitude < (xj-xi)*(latitude-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+func inPrivacyZone(zones []PrivacyZone, latitude, longitude float64) bool {
+	for _, zone := range zones {
+		if zone.contains(latitude, longitude) {
+			return true
+		}
+	}
+	return false
+}
+
+func loadPrivacyZones(ctx context.Context, store SessionStore, ethAddress string) ([]PrivacyZone, error) {
+	raw, found, err := store.Get(ctx, privacyZonesKey(ethAddress))
+	if err != nil {
+		return nil, errors.Wrap(err, "error loading privacy zones")
+	}
+	if !found {
+		return nil, nil
+	}
+
+	var zones []PrivacyZone
+	if err := json.Unmarshal([]byte(raw), &zones); err != nil {
+		return nil, errors.Wrap(err, "error decoding privacy zones")
+	}
+	return zones, nil
+}
+
+func savePrivacyZones(ctx context.Context, store SessionStore, ethAddress string, zones []PrivacyZone) error {
+	raw, err := json.Marshal(zones)
+	if err != nil {
+		return err
+	}
+	// no TTL: zones are kept until the user deletes them
+	return store.Set(ctx, privacyZonesKey(ethAddress), string(raw), 0)
+}
+
+// userPrivacyZones loads the zones of whoever is making the request. Callers must fail rather than carry on
+// without them, or a store outage would leak the very places the user asked to hide.
+func userPrivacyZones(c *fiber.Ctx, store SessionStore) ([]PrivacyZone, error) {
+	ethAddress, _ := c.Locals("ethereum_address").(string)
+	return loadPrivacyZones(c.UserContext(), store, ethAddress)
+}
+
+// maskLocation returns nil for a location inside one of the zones.
+func maskLocation(location *dimo.LatLon, zones []PrivacyZone) *dimo.LatLon {
+	if location != nil && inPrivacyZone(zones, location.Latitude, location.Longitude) {
+		return nil
+	}
+	return location
+}
+
+// hideTripEnds blanks the start and end locations of trips that begin or finish inside a zone. A blanked
+// Location is 0,0, which is never a real fix.
+func hideTripEnds(trips []dimo.Trip, zones []PrivacyZone) {
+	if len(zones) == 0 {
+		return
+	}
+	for i := range trips {
+		for _, point := range []*dimo.TripPoint{&trips[i].Start, &trips[i].End} {
+			if inPrivacyZone(zones, point.Location.Latitude, point.Location.Longitude) {
+				point.Location = dimo.LatLon{}
+			}
+			point.EstimatedLocation = maskLocation(point.EstimatedLocation, zones)
+		}
+	}
+}
+
+// HandlePrivacyZones lists the caller's privacy zones.
+func (a *AccountController) HandlePrivacyZones(c *fiber.Ctx) error {
+	zones, err := userPrivacyZones(c, a.store)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load privacy zones")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to load privacy zones"})
+	}
+	if zones == nil {
+		zones = []PrivacyZone{}
+	}
+	return c.JSON(fiber.Map{"zones": zones})
+}
+
+// HandleAddPrivacyZone adds a zone from a JSON PrivacyZone body; the ID is assigned here.
+func (a *AccountController) HandleAddPrivacyZone(c *fiber.Ctx) error {
+	var zone PrivacyZone
+	if err := c.BodyParser(&zone); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid privacy zone"})
+	}
+	zone.Name = strings.TrimSpace(zone.Name)
+	if err := zone.validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	zone.ID = uuid.New().String()
+
+	ethAddress := c.Locals("ethereum_address").(string)
+	err := updateAccountData(c.UserContext(), a.store, privacyZonesKey(ethAddress), func() error {
+		zones, err := loadPrivacyZones(c.UserContext(), a.store, ethAddress)
+		if err != nil {
+			return err
+		}
+		if len(zones) >= maxPrivacyZones {
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("At most %d privacy zones can be set", maxPrivacyZones))
+		}
+		return savePrivacyZones(c.UserContext(), a.store, ethAddress, append(zones, zone))
+	})
+	if err != nil {
+		return accountDataError(c, err, "Failed to save privacy zone")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(zone)
+}
+
+// HandleDeletePrivacyZone removes the zone named by the zoneID param.
+func (a *AccountController) HandleDeletePrivacyZone(c *fiber.Ctx) error {
+	ethAddress := c.Locals("ethereum_address").(string)
+	err := updateAccountData(c.UserContext(), a.store, privacyZonesKey(ethAddress), func() error {
+		zones, err := loadPrivacyZones(c.UserContext(), a.store, ethAddress)
+		if err != nil {
+			return err
+		}
+
+		kept := make([]PrivacyZone, 0, len(zones))
+		for _, zone := range zones {
+			if zone.ID != c.Params("zoneID") {
+				kept = append(kept, zone)
+			}
+		}
+		if len(kept) == len(zones) {
+			return fiber.NewError(fiber.StatusNotFound, "Privacy zone not found")
+		}
+		return savePrivacyZones(c.UserContext(), a.store, ethAddress, kept)
+	})
+	if err != nil {
+		return accountDataError(c, err, "Failed to delete privacy zone")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dimo-network/trips-web-app/api/internal/session"
+	"github.com/gofiber/fiber/v2"
+)
+
+const testEthAddress = "0x0000000000000000000000000000000000000001"
+
+func newPrivacyZonesApp(store SessionStore) *fiber.App {
+	a := &AccountController{store: store}
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("ethereum_address", testEthAddress)
+		return c.Next()
+	})
+	app.Post("/api/privacy-zones", a.HandleAddPrivacyZone)
+	app.Delete("/api/privacy-zones/:zoneID", a.HandleDeletePrivacyZone)
+	return app
+}
+
+func postZone(t *testing.T, app *fiber.App, name string) int {
+	t.Helper()
+	body := fmt.Sprintf(`{"name":%q,"center":{"latitude":52.5,"longitude":13.4},"radiusMeters":300}`, name)
+	req := httptest.NewRequest(fiber.MethodPost, "/api/privacy-zones", bytes.NewBufferString(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Error(err)
+		return 0
+	}
+	return resp.StatusCode
+}
+
+func TestPrivacyZonesRefuseEphemeralStore(t *testing.T) {
+	store := session.NewMemoryStore()
+	app := newPrivacyZonesApp(store)
+
+	if got := postZone(t, app, "Home"); got != fiber.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", got, fiber.StatusServiceUnavailable)
+	}
+	if _, found, _ := store.Get(context.Background(), privacyZonesKey(testEthAddress)); found {
+		t.Error("zone was saved to the in-memory store")
+	}
+}
+
+func TestPrivacyZonesConcurrentAddsAreAllKept(t *testing.T) {
+	store := session.NewRedisStore(session.RedisOptions{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { _ = store.Close() })
+	app := newPrivacyZonesApp(store)
+
+	var wg sync.WaitGroup
+	for i := 0; i < maxPrivacyZones; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if got := postZone(t, app, fmt.Sprintf("Zone %d", i)); got != fiber.StatusCreated {
+				t.Errorf("status = %d, want %d", got, fiber.StatusCreated)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	zones, err := loadPrivacyZones(context.Background(), store, testEthAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zones) != maxPrivacyZones {
+		t.Errorf("%d zones saved, want %d", len(zones), maxPrivacyZones)
+	}
+
+	// the limit still holds once they are all in
+	if got := postZone(t, app, "One too many"); got != fiber.StatusBadRequest {
+		t.Errorf("status = %d past the limit, want %d", got, fiber.StatusBadRequest)
+	}
+}
@@ -0,0 +1,199 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dimo-network/trips-web-app/api/internal/auth"
+	"github.com/dimo-network/trips-web-app/api/internal/config"
+	"github.com/dimo-network/trips-web-app/api/internal/dimo"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// sessionTTL is how long a session, and its cookie, survives without activity. Every authenticated
+	// request pushes it forward again.
+	sessionTTL = 2 * time.Hour
+	// tokenRefreshWindow is how close to expiry the id_token has to be before it is refreshed.
+	tokenRefreshWindow = 5 * time.Minute
+	// sessionRefreshWait is how long a request waits for another one to finish refreshing the same session.
+	sessionRefreshWait = 15 * time.Second
+	// tripsCacheTTL is how long a vehicle's trip history is reused while the user pages through it.
+	tripsCacheTTL = time.Minute
+)
+
+// Session is what the session store keeps for a logged-in user.
+type Session struct {
+	EthereumAddress string    `json:"ethereumAddress"`
+	IDToken         string    `json:"idToken"`
+	AccessToken     string    `json:"accessToken,omitempty"`
+	RefreshToken    string    `json:"refreshToken,omitempty"`
+	Expiry          time.Time `json:"expiry,omitempty"`
+}
+
+// TokenResponse is the token payload returned by both submit_challenge and the token endpoint.
+type TokenResponse struct {
+	IDToken      string `json:"id_token"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// apply copies the tokens in r onto s. Refresh responses may omit the refresh token, in which case the old one is kept.
+func (r TokenResponse) apply(s *Session) {
+	s.IDToken = r.IDToken
+	if r.AccessToken != "" {
+		s.AccessToken = r.AccessToken
+	}
+	if r.RefreshToken != "" {
+		s.RefreshToken = r.RefreshToken
+	}
+	if r.ExpiresIn > 0 {
+		s.Expiry = time.Now().Add(time.Duration(r.ExpiresIn) * time.Second)
+	}
+}
+
+func sessionKey(sessionID string) string {
+	return "session_" + sessionID
+}
+
+func privilegeTokenPrefix(sessionID string) string {
+	return fmt.Sprintf("privilegeToken_%s_", sessionID)
+}
+
+func tripsCachePrefix(sessionID string) string {
+	return fmt.Sprintf("trips_%s_", sessionID)
+}
+
+func tripVehiclePrefix(sessionID string) string {
+	return fmt.Sprintf("tripVehicle_%s_", sessionID)
+}
+
+// deleteSession removes a session together with every privilege token and cached result that belongs to it.
+func deleteSession(ctx context.Context, store SessionStore, sessionID string) error {
+	keys := []string{sessionKey(sessionID)}
+	for _, prefix := range []string{privilegeTokenPrefix(sessionID), tripsCachePrefix(sessionID), tripVehiclePrefix(sessionID)} {
+		scoped, err := store.Keys(ctx, prefix)
+		if err != nil {
+			return errors.Wrap(err, "error listing session keys")
+		}
+		keys = append(keys, scoped...)
+	}
+	return store.Delete(ctx, keys...)
+}
+
+func loadSession(ctx context.Context, store SessionStore, sessionID string) (*Session, bool, error) {
+	raw, found, err := store.Get(ctx, sessionKey(sessionID))
+	if err != nil || !found {
+		return nil, false, err
+	}
+
+	var s Session
+	if err := json.Unmarshal([]byte(raw), &s); err != nil {
+		return nil, false, errors.Wrap(err, "error decoding session")
+	}
+	return &s, true, nil
+}
+
+func saveSession(ctx context.Context, store SessionStore, sessionID string, s *Session) error {
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return errors.Wrap(err, "error encoding session")
+	}
+	return store.Set(ctx, sessionKey(sessionID), string(raw), sessionTTL)
+}
+
+// currentSession returns the session AuthMiddleware attached to the request.
+func currentSession(c *fiber.Ctx) (string, *Session, error) {
+	sessionID, _ := c.Locals("session_id").(string)
+	s, ok := c.Locals("session").(*Session)
+	if sessionID == "" || !ok {
+		return "", nil, errors.New("no session on request")
+	}
+	return sessionID, s, nil
+}
+
+func setSessionCookie(c *fiber.Ctx, settings *config.Settings, sessionID string) {
+	cookie := sessionCookie(settings)
+	cookie.Value = sessionID
+	cookie.Expires = time.Now().Add(sessionTTL)
+
+	c.Cookie(cookie)
+}
+
+func clearSessionCookie(c *fiber.Ctx, settings *config.Settings) {
+	cookie := sessionCookie(settings)
+	cookie.Expires = time.Unix(0, 0)
+	cookie.MaxAge = -1
+
+	c.Cookie(cookie)
+}
+
+// sessionCookie returns the session_id cookie with the attributes configured in settings.
+func sessionCookie(settings *config.Settings) *fiber.Cookie {
+	return &fiber.Cookie{
+		Name:     "session_id",
+		Domain:   settings.CookieDomain,
+		Path:     cookiePath(settings),
+		Secure:   settings.CookieSecure,
+		SameSite: cookieSameSite(settings),
+		HTTPOnly: true,
+	}
+}
+
+// needsRefresh reports whether a session's id_token is close enough to expiry to refresh, and can be.
+func needsRefresh(s *Session) bool {
+	return s.RefreshToken != "" && !s.Expiry.IsZero() && time.Until(s.Expiry) < tokenRefreshWindow
+}
+
+// refreshSessionOnce refreshes a session's tokens while holding its lock, so parallel requests spend its refresh
+// token only once. A request that finds the lock taken waits for it, then picks up whatever the holder saved. A
+// failed refresh is not fatal while the current id_token is still valid, so the session is then returned as it was.
+func refreshSessionOnce(ctx context.Context, client *dimo.Client, verifier *auth.Verifier, store SessionStore, sessionID string, s *Session) *Session {
+	current := s
+	err := withLock(ctx, store, sessionKey(sessionID), sessionRefreshWait, func() error {
+		// another request may have refreshed the session while this one waited for the lock
+		latest, found, err := loadSession(ctx, store, sessionID)
+		if err != nil || !found {
+			return err
+		}
+		current = latest
+		if !needsRefresh(latest) {
+			return nil
+		}
+
+		refreshed := *latest
+		if err := refreshSession(ctx, client, verifier, &refreshed); err != nil {
+			return err
+		}
+		current = &refreshed
+		return saveSession(ctx, store, sessionID, &refreshed)
+	})
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to refresh session tokens")
+	}
+	return current
+}
+
+// refreshSession exchanges the session's refresh token for a new set of tokens at the auth server's token endpoint,
+// and checks the new id_token before taking them.
+func refreshSession(ctx context.Context, client *dimo.Client, verifier *auth.Verifier, s *Session) error {
+	resp, err := client.RefreshTokens(ctx, s.RefreshToken)
+	if err != nil {
+		return errors.Wrap(err, "error refreshing tokens")
+	}
+
+	claims, err := verifier.Verify(ctx, resp.IDToken)
+	if err != nil {
+		return errors.Wrap(err, "refreshed id_token rejected")
+	}
+
+	TokenResponse(*resp).apply(s)
+	s.EthereumAddress = claims.EthereumAddress
+	s.Expiry = claims.ExpiresAt.Time
+	return nil
+}
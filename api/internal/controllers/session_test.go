@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dimo-network/trips-web-app/api/internal/auth"
+	"github.com/dimo-network/trips-web-app/api/internal/config"
+	"github.com/dimo-network/trips-web-app/api/internal/dimo"
+	"github.com/dimo-network/trips-web-app/api/internal/session"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testAddress = "0x0000000000000000000000000000000000000001"
+
+// authServer is a local stand-in for the DIMO auth server: a JWKS endpoint and a token endpoint that rotates the
+// refresh token on every use, as DIMO's does.
+type authServer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu           sync.Mutex
+	refreshToken string
+	refreshes    int
+}
+
+func newAuthServer(t *testing.T) *authServer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	as := &authServer{key: key, refreshToken: "refresh-0"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, _ *http.Request) {
+		encode := func(i *big.Int) string { return base64.RawURLEncoding.EncodeToString(i.Bytes()) }
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kid": "test", "kty": "RSA", "use": "sig",
+			"n": encode(key.N), "e": encode(big.NewInt(int64(key.E))),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		as.mu.Lock()
+		defer as.mu.Unlock()
+		// give parallel callers time to pile up behind the first one
+		time.Sleep(20 * time.Millisecond)
+
+		if r.FormValue("grant_type") != "refresh_token" || r.FormValue("refresh_token") != as.refreshToken {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		as.refreshes++
+		as.refreshToken = "refresh-" + strconv.Itoa(as.refreshes)
+		_ = json.NewEncoder(w).Encode(TokenResponse{
+			IDToken:      as.idToken(t, time.Hour),
+			RefreshToken: as.refreshToken,
+			ExpiresIn:    3600,
+		})
+	})
+	as.Server = httptest.NewServer(mux)
+	t.Cleanup(as.Close)
+	return as
+}
+
+func (as *authServer) idToken(t *testing.T, validFor time.Duration) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, auth.Claims{
+		EthereumAddress: testAddress,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{"trips-web-app"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(validFor)),
+		},
+	})
+	token.Header["kid"] = "test"
+	signed, err := token.SignedString(as.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestRefreshSessionOnceSpendsRefreshTokenOnce(t *testing.T) {
+	as := newAuthServer(t)
+	settings := &config.Settings{ClientID: "trips-web-app", TokenURL: as.URL + "/token", APIMaxRetries: -1}
+	client := dimo.NewClient(settings)
+	verifier := auth.NewVerifier(as.URL+"/keys", settings.ClientID, "")
+	store := session.NewMemoryStore()
+	ctx := context.Background()
+
+	// a session whose id_token is about to expire
+	const sessionID = "session-1"
+	stale := &Session{
+		EthereumAddress: testAddress,
+		IDToken:         as.idToken(t, time.Minute),
+		RefreshToken:    "refresh-0",
+		Expiry:          time.Now().Add(time.Minute),
+	}
+	if err := saveSession(ctx, store, sessionID, stale); err != nil {
+		t.Fatal(err)
+	}
+
+	const parallel = 8
+	results := make([]*Session, parallel)
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// each request works from the copy it loaded before any refresh
+			copied := *stale
+			results[i] = refreshSessionOnce(ctx, client, verifier, store, sessionID, &copied)
+		}(i)
+	}
+	wg.Wait()
+
+	if as.refreshes != 1 {
+		t.Errorf("token endpoint refreshed %d times, want 1", as.refreshes)
+	}
+	saved, found, err := loadSession(ctx, store, sessionID)
+	if err != nil || !found {
+		t.Fatalf("loadSession() = %v, %v", found, err)
+	}
+	if saved.RefreshToken != as.refreshToken {
+		t.Errorf("stored refresh token = %q, want the rotated %q", saved.RefreshToken, as.refreshToken)
+	}
+	if needsRefresh(saved) {
+		t.Errorf("stored session still needs a refresh, expiry %s", saved.Expiry)
+	}
+	for i, s := range results {
+		if s.IDToken != saved.IDToken {
+			t.Errorf("request %d got a different id_token from the stored one", i)
+		}
+	}
+	if _, found, _ := store.Get(ctx, lockKey(sessionKey(sessionID))); found {
+		t.Error("refresh lock was not released")
+	}
+}
+
+func TestRefreshSessionOnceKeepsSessionWhenRefreshFails(t *testing.T) {
+	as := newAuthServer(t)
+	settings := &config.Settings{ClientID: "trips-web-app", TokenURL: as.URL + "/token", APIMaxRetries: -1}
+	client := dimo.NewClient(settings)
+	verifier := auth.NewVerifier(as.URL+"/keys", settings.ClientID, "")
+	store := session.NewMemoryStore()
+	ctx := context.Background()
+
+	const sessionID = "session-1"
+	original := &Session{
+		EthereumAddress: testAddress,
+		IDToken:         as.idToken(t, time.Minute),
+		RefreshToken:    "already-spent",
+		Expiry:          time.Now().Add(time.Minute),
+	}
+	if err := saveSession(ctx, store, sessionID, original); err != nil {
+		t.Fatal(err)
+	}
+
+	got := refreshSessionOnce(ctx, client, verifier, store, sessionID, original)
+	if got.IDToken != original.IDToken || got.RefreshToken != original.RefreshToken {
+		t.Error("a failed refresh changed the session")
+	}
+	saved, _, _ := loadSession(ctx, store, sessionID)
+	if saved.RefreshToken != original.RefreshToken {
+		t.Errorf("stored refresh token = %q, want it untouched", saved.RefreshToken)
+	}
+}
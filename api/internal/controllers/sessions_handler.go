@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"strings"
+
+	"github.com/dimo-network/trips-web-app/api/internal/config"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+type RevokeSessionsRequest struct {
+	Address string `json:"address"`
+}
+
+// HandleLogout ends the caller's session server-side, along with the privilege tokens derived from it,
+// and clears the session cookie. It works without a valid session so a stale cookie can always be cleared.
+func HandleLogout(settings *config.Settings, store SessionStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if sessionCookie := c.Cookies("session_id"); sessionCookie != "" {
+			if err := deleteSession(c.UserContext(), store, sessionCookie); err != nil {
+				log.Error().Err(err).Msg("Error deleting session on logout")
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to end session"})
+			}
+		}
+
+		clearSessionCookie(c, settings)
+
+		return c.JSON(fiber.Map{"message": "Logged out"})
+	}
+}
+
+// AdminMiddleware only lets through authenticated users whose address is listed in ADMIN_ADDRESSES.
+// It must run after AuthMiddleware.
+func AdminMiddleware(settings *config.Settings) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ethAddress, _ := c.Locals("ethereum_address").(string)
+		for _, admin := range strings.Split(settings.AdminAddresses, ",") {
+			admin = strings.TrimSpace(admin)
+			if admin != "" && strings.EqualFold(admin, ethAddress) {
+				return c.Next()
+			}
+		}
+
+		log.Warn().Str("address", ethAddress).Str("path", c.Path()).Msg("Rejected non-admin request")
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Forbidden"})
+	}
+}
+
+// HandleRevokeSessions ends every session belonging to an Ethereum address.
+func HandleRevokeSessions(store SessionStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var revokeReq RevokeSessionsRequest
+		if err := c.BodyParser(&revokeReq); err != nil || revokeReq.Address == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+
+		keys, err := store.Keys(c.UserContext(), sessionKey(""))
+		if err != nil {
+			log.Error().Err(err).Msg("Error listing sessions")
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to list sessions"})
+		}
+
+		revoked := 0
+		for _, key := range keys {
+			sessionID := strings.TrimPrefix(key, sessionKey(""))
+			session, found, err := loadSession(c.UserContext(), store, sessionID)
+			if err != nil {
+				log.Warn().Err(err).Str("session", sessionID).Msg("Skipping unreadable session")
+				continue
+			}
+			if !found || !strings.EqualFold(session.EthereumAddress, revokeReq.Address) {
+				continue
+			}
+
+			if err := deleteSession(c.UserContext(), store, sessionID); err != nil {
+				log.Error().Err(err).Msg("Error revoking session")
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to revoke sessions", "revoked": revoked})
+			}
+			revoked++
+		}
+
+		log.Info().Str("admin", c.Locals("ethereum_address").(string)).Str("address", revokeReq.Address).Int("revoked", revoked).Msg("Revoked sessions")
+
+		return c.JSON(fiber.Map{"revoked": revoked})
+	}
+}
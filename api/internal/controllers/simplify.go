@@ -0,0 +1,181 @@
+package controllers
+
+import (
+	"container/heap"
+	"math"
+)
+
+// planarPoint is a sample projected onto a flat plane in metres. Trips are short enough that an equirectangular
+// projection around their first point is accurate to well within any useful simplification tolerance.
+type planarPoint struct {
+	x, y float64
+}
+
+// simplifyTrack drops the samples that make no visible difference to the track's shape at the given tolerance in
+// metres, using Douglas-Peucker, or Visvalingam-Whyatt when algorithm is "vw". Samples without a position are
+// dropped as well, since they aren't drawn. Each segment is simplified on its own and keeps its first and last
+// samples, so gaps stay where they were.
+func simplifyTrack(locations []LocationData, algorithm string, tolerance float64) []LocationData {
+	points := positionedLocations(locations)
+	if tolerance <= 0 {
+		return points
+	}
+
+	simplified := make([]LocationData, 0, len(points))
+	for _, segment := range trackSegments(points) {
+		simplified = append(simplified, simplifySegment(segment, algorithm, tolerance)...)
+	}
+	return simplified
+}
+
+func simplifySegment(points []LocationData, algorithm string, tolerance float64) []LocationData {
+	if len(points) < 3 {
+		return points
+	}
+
+	projected := projectLocations(points)
+	var keep []bool
+	if algorithm == "vw" {
+		keep = visvalingamWhyatt(projected, tolerance*tolerance)
+	} else {
+		keep = douglasPeucker(projected, tolerance)
+	}
+
+	simplified := make([]LocationData, 0, len(points))
+	for i, loc := range points {
+		if keep[i] {
+			simplified = append(simplified, loc)
+		}
+	}
+	return simplified
+}
+
+// positionedLocations is the samples that have a position, in order.
+func positionedLocations(locations []LocationData) []LocationData {
+	points := make([]LocationData, 0, len(locations))
+	for _, loc := range locations {
+		if loc.Latitude != nil && loc.Longitude != nil {
+			points = append(points, loc)
+		}
+	}
+	return points
+}
+
+func projectLocations(points []LocationData) []planarPoint {
+	metresPerDegree := earthRadiusKm * 1000 * math.Pi / 180
+	scaleX := metresPerDegree * math.Cos(*points[0].Latitude*math.Pi/180)
+
+	projected := make([]planarPoint, len(points))
+	for i, loc := range points {
+		projected[i] = planarPoint{x: *loc.Longitude * scaleX, y: *loc.Latitude * metresPerDegree}
+	}
+	return projected
+}
+
+// douglasPeucker marks the points to keep so that no dropped point is further than tolerance from the line.
+func douglasPeucker(points []planarPoint, tolerance float64) []bool {
+	keep := make([]bool, len(points))
+	keep[0], keep[len(points)-1] = true, true
+
+	type span struct{ first, last int }
+	// an explicit stack rather than recursion, since long trips on a straight road would go thousands deep
+	stack := []span{{0, len(points) - 1}}
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		farthest, maxDistance := -1, tolerance
+		for i := s.first + 1; i < s.last; i++ {
+			if d := segmentDistance(points[i], points[s.first], points[s.last]); d > maxDistance {
+				farthest, maxDistance = i, d
+			}
+		}
+		if farthest < 0 {
+			continue
+		}
+		keep[farthest] = true
+		stack = append(stack, span{s.first, farthest}, span{farthest, s.last})
+	}
+	return keep
+}
+
+// segmentDistance is the distance from p to the segment between a and b.
+func segmentDistance(p, a, b planarPoint) float64 {
+	dx, dy := b.x-a.x, b.y-a.y
+	if lengthSquared := dx*dx + dy*dy; lengthSquared > 0 {
+		t := math.Max(0, math.Min(1, ((p.x-a.x)*dx+(p.y-a.y)*dy)/lengthSquared))
+		a = planarPoint{x: a.x + t*dx, y: a.y + t*dy}
+	}
+	return math.Hypot(p.x-a.x, p.y-a.y)
+}
+
+// vwVertex is a point still in the line being simplified, linked to its current neighbours.
+type vwVertex struct {
+	index, prev, next int
+	area              float64
+	heapIndex         int
+}
+
+// vwHeap orders vertices by the area of the triangle they make with their neighbours, smallest first.
+type vwHeap []*vwVertex
+
+func (h vwHeap) Len() int           { return len(h) }
+func (h vwHeap) Less(i, j int) bool { return h[i].area < h[j].area }
+func (h vwHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIndex = i
+	h[j].heapIndex = j
+}
+func (h *vwHeap) Push(x interface{}) {
+	vertex := x.(*vwVertex)
+	vertex.heapIndex = len(*h)
+	*h = append(*h, vertex)
+}
+func (h *vwHeap) Pop() interface{} {
+	old := *h
+	vertex := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return vertex
+}
+
+// visvalingamWhyatt marks the points to keep, repeatedly dropping the point whose triangle with its neighbours
+// has the smallest area until every remaining triangle is at least minArea.
+func visvalingamWhyatt(points []planarPoint, minArea float64) []bool {
+	keep := make([]bool, len(points))
+	vertices := make([]*vwVertex, len(points))
+	for i := range points {
+		keep[i] = true
+		vertices[i] = &vwVertex{index: i, prev: i - 1, next: i + 1}
+	}
+
+	area := func(v *vwVertex) float64 {
+		a, p, b := points[v.prev], points[v.index], points[v.next]
+		return math.Abs((p.x-a.x)*(b.y-a.y)-(b.x-a.x)*(p.y-a.y)) / 2
+	}
+
+	// the endpoints never leave the line, so only the points between them are candidates
+	candidates := make(vwHeap, 0, len(points)-2)
+	for _, v := range vertices[1 : len(points)-1] {
+		v.area = area(v)
+		heap.Push(&candidates, v)
+	}
+
+	for candidates.Len() > 0 {
+		v := heap.Pop(&candidates).(*vwVertex)
+		if v.area >= minArea {
+			break
+		}
+		keep[v.index] = false
+
+		prev, next := vertices[v.prev], vertices[v.next]
+		prev.next, next.prev = next.index, prev.index
+		for _, neighbour := range []*vwVertex{prev, next} {
+			if neighbour.index == 0 || neighbour.index == len(points)-1 {
+				continue
+			}
+			neighbour.area = area(neighbour)
+			heap.Fix(&candidates, neighbour.heapIndex)
+		}
+	}
+	return keep
+}
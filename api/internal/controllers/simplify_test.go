@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+// wavyTrack is a trip heading north that weaves from side to side by up to about 30m, in two segments.
+func wavyTrack(n int) []LocationData {
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	locations := make([]LocationData, n)
+	for i := range locations {
+		lat := 52.5 + float64(i)*0.0005
+		lon := 13.4 + 0.0003*math.Sin(float64(i)*0.7) + 0.0001*math.Sin(float64(i)*2.3)
+		segment := 0
+		if i >= n/2 {
+			segment = 1
+		}
+		locations[i] = LocationData{
+			Timestamp: start.Add(time.Duration(i) * 5 * time.Second).Format(time.RFC3339),
+			Latitude:  &lat,
+			Longitude: &lon,
+			Segment:   segment,
+		}
+	}
+	return locations
+}
+
+func timestamps(locations []LocationData) []string {
+	stamps := make([]string, len(locations))
+	for i, loc := range locations {
+		stamps[i] = loc.Timestamp
+	}
+	return stamps
+}
+
+func TestSimplifyTrackPassesShortInputsThrough(t *testing.T) {
+	for _, algorithm := range []string{"dp", "vw"} {
+		for n := 0; n < 3; n++ {
+			t.Run(fmt.Sprintf("%s/%d points", algorithm, n), func(t *testing.T) {
+				track := wavyTrack(n)
+				for i := range track {
+					track[i].Segment = 0
+				}
+				got := simplifyTrack(track, algorithm, maxSimplifyTolerance)
+				if fmt.Sprint(timestamps(got)) != fmt.Sprint(timestamps(track)) {
+					t.Fatalf("got %v, want %v unchanged", timestamps(got), timestamps(track))
+				}
+			})
+		}
+	}
+}
+
+func TestSimplifyTrackKeepsSegmentEnds(t *testing.T) {
+	track := wavyTrack(60)
+	for _, algorithm := range []string{"dp", "vw"} {
+		for _, tolerance := range []float64{0, 1, 10, 100, maxSimplifyTolerance} {
+			got := simplifyTrack(track, algorithm, tolerance)
+			kept := make(map[string]bool)
+			for _, loc := range got {
+				kept[loc.Timestamp] = true
+			}
+			// the first and last samples of each segment
+			for _, i := range []int{0, 29, 30, 59} {
+				if !kept[track[i].Timestamp] {
+					t.Errorf("%s at %gm dropped sample %d, which ends a segment", algorithm, tolerance, i)
+				}
+			}
+		}
+	}
+}
+
+func TestSimplifyTrackDropsMoreAsToleranceGrows(t *testing.T) {
+	track := wavyTrack(200)
+	for _, algorithm := range []string{"dp", "vw"} {
+		previous := simplifyTrack(track, algorithm, 0)
+		if len(previous) != len(track) {
+			t.Fatalf("%s at 0m kept %d of %d samples, want all", algorithm, len(previous), len(track))
+		}
+		for _, tolerance := range []float64{0.5, 2, 5, 10, 20, 50, 200, maxSimplifyTolerance} {
+			got := simplifyTrack(track, algorithm, tolerance)
+			if len(got) > len(previous) {
+				t.Errorf("%s at %gm kept %d samples, more than the %d kept at a lower tolerance", algorithm, tolerance, len(got), len(previous))
+			}
+			kept := make(map[string]bool)
+			for _, loc := range previous {
+				kept[loc.Timestamp] = true
+			}
+			for _, loc := range got {
+				if !kept[loc.Timestamp] {
+					t.Errorf("%s at %gm kept %s, which a lower tolerance dropped", algorithm, tolerance, loc.Timestamp)
+				}
+			}
+			previous = got
+		}
+		// two segments, each down to its two ends
+		if len(previous) != 4 {
+			t.Errorf("%s at %gm kept %d samples, want 4", algorithm, maxSimplifyTolerance, len(previous))
+		}
+	}
+}
+
+func TestSimplifyTrackKeepsDetourWiderThanTolerance(t *testing.T) {
+	// straight out to a corner about 100m east of the line between the ends, and straight back
+	track := wavyTrack(11)
+	for i := range track {
+		lon := 13.4 + 0.0015*(1-math.Abs(float64(i-5))/5)
+		track[i].Longitude = &lon
+		track[i].Segment = 0
+	}
+
+	for _, tc := range []struct {
+		algorithm string
+		tolerance float64
+		want      int
+	}{
+		{"dp", 50, 3},
+		{"dp", 200, 2},
+		{"vw", 50, 3},
+		{"vw", 200, 2},
+	} {
+		got := simplifyTrack(track, tc.algorithm, tc.tolerance)
+		if len(got) != tc.want {
+			t.Errorf("%s at %gm kept %d samples, want %d", tc.algorithm, tc.tolerance, len(got), tc.want)
+		}
+	}
+}
+
+func TestSimplifyTrackDropsSamplesWithoutPosition(t *testing.T) {
+	track := wavyTrack(5)
+	for i := range track {
+		track[i].Segment = 0
+	}
+	track[2].Latitude = nil
+
+	got := simplifyTrack(track, "dp", 0)
+	if len(got) != 4 {
+		t.Fatalf("kept %d samples, want the 4 with a position", len(got))
+	}
+	for _, loc := range got {
+		if loc.Latitude == nil {
+			t.Fatalf("kept %s, which has no position", loc.Timestamp)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/dimo-network/trips-web-app/api/internal/config"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	unitKmh = "kmh"
+	unitMph = "mph"
+
+	kmPerMile = 1.609344
+
+	// missingSpeedColor is drawn where the speed wasn't sampled.
+	missingSpeedColor = "black"
+
+	maxSpeedBands   = 10
+	maxSpeedBandKmh = 400.0
+)
+
+// namedColors are the colour names speed bands may use besides #rrggbb, with their RGB values. Green is the
+// full-intensity one, as the map has always drawn it.
+var namedColors = map[string]string{
+	"black":   "000000",
+	"white":   "ffffff",
+	"gray":    "808080",
+	"blue":    "0000ff",
+	"cyan":    "00ffff",
+	"green":   "00ff00",
+	"yellow":  "ffff00",
+	"orange":  "ffa500",
+	"red":     "ff0000",
+	"magenta": "ff00ff",
+	"purple":  "800080",
+	"pink":    "ffc0cb",
+	"brown":   "a52a2a",
+}
+
+var hexColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// SpeedBand is one band of the speed gradient: speeds up to Threshold are drawn in Color.
+type SpeedBand struct {
+	Threshold float64 `json:"threshold"`
+	Color     string  `json:"color"`
+}
+
+// SpeedPreferences is how speeds are banded, coloured and reported. Band thresholds are kept in km/h, the unit the
+// Telemetry API reports, whatever Unit the user reads speeds in.
+type SpeedPreferences struct {
+	Unit  string      `json:"unit"`
+	Bands []SpeedBand `json:"bands"`
+	// OverColor is drawn above the fastest band.
+	OverColor string `json:"overColor"`
+}
+
+// DefaultSpeedPreferences reads the speed bands, over-limit colour and unit from the settings. SPEED_BANDS is a
+// comma-separated list of threshold:color pairs in km/h, slowest first, such as 10:blue,30:green.
+func DefaultSpeedPreferences(settings *config.Settings) (SpeedPreferences, error) {
+	prefs := SpeedPreferences{Unit: settings.SpeedUnit, OverColor: settings.SpeedOverLimitColor}
+
+	for _, pair := range strings.Split(settings.SpeedBands, ",") {
+		threshold, color, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok {
+			return prefs, errors.Errorf("invalid speed band %q, expected threshold:color", pair)
+		}
+		value, err := strconv.ParseFloat(threshold, 64)
+		if err != nil {
+			return prefs, errors.Errorf("invalid speed band threshold %q", threshold)
+		}
+		prefs.Bands = append(prefs.Bands, SpeedBand{Threshold: value, Color: color})
+	}
+
+	if err := prefs.validate(); err != nil {
+		return prefs, errors.Wrap(err, "invalid speed settings")
+	}
+	return prefs, nil
+}
+
+func (p SpeedPreferences) validate() error {
+	if p.Unit != unitKmh && p.Unit != unitMph {
+		return errors.New("unit must be kmh or mph")
+	}
+	if len(p.Bands) == 0 || len(p.Bands) > maxSpeedBands {
+		return errors.Errorf("there must be between 1 and %d speed bands", maxSpeedBands)
+	}
+	lower := 0.0
+	for _, band := range p.Bands {
+		if band.Threshold <= lower || band.Threshold > maxSpeedBandKmh {
+			return errors.Errorf("speed band thresholds must go up, and stay under %g km/h", maxSpeedBandKmh)
+		}
+		if !validColor(band.Color) {
+			return errors.Errorf("%q is not a supported color", band.Color)
+		}
+		lower = band.Threshold
+	}
+	if !validColor(p.OverColor) {
+		return errors.Errorf("%q is not a supported color", p.OverColor)
+	}
+	return nil
+}
+
+func validColor(color string) bool {
+	_, named := namedColors[color]
+	return named || hexColor.MatchString(color)
+}
+
+// bandIndex is the index in Bands of the band a speed in km/h falls in, or len(Bands) above them all.
+func (p SpeedPreferences) bandIndex(kmh float64) int {
+	for i, band := range p.Bands {
+		if kmh <= band.Threshold {
+			return i
+		}
+	}
+	return len(p.Bands)
+}
+
+// bandColor is the colour of a band from bandIndex, or of the stretches without a speed for band -1.
+func (p SpeedPreferences) bandColor(band int) string {
+	switch {
+	case band < 0:
+		return missingSpeedColor
+	case band >= len(p.Bands):
+		return p.OverColor
+	default:
+		return p.Bands[band].Color
+	}
+}
+
+// bandLabel describes a band from bandIndex in the user's unit, such as "30-50 km/h".
+func (p SpeedPreferences) bandLabel(band int) string {
+	switch {
+	case band < 0:
+		return "no speed"
+	case band >= len(p.Bands):
+		return fmt.Sprintf("over %s %s", p.formatThreshold(len(p.Bands)-1), p.speedUnitLabel())
+	case band == 0:
+		return fmt.Sprintf("0-%s %s", p.formatThreshold(0), p.speedUnitLabel())
+	default:
+		return fmt.Sprintf("%s-%s %s", p.formatThreshold(band-1), p.formatThreshold(band), p.speedUnitLabel())
+	}
+}
+
+func (p SpeedPreferences) formatThreshold(band int) string {
+	return strconv.FormatFloat(math.Round(p.speed(p.Bands[band].Threshold)*10)/10, 'f', -1, 64)
+}
+
+// speedColor is the colour a sampled speed in km/h is drawn in.
+func (p SpeedPreferences) speedColor(kmh *float64) string {
+	if kmh == nil {
+		return missingSpeedColor
+	}
+	return p.bandColor(p.bandIndex(*kmh))
+}
+
+// speed converts a speed in km/h to the user's unit.
+func (p SpeedPreferences) speed(kmh float64) float64 {
+	if p.Unit == unitMph {
+		return kmh / kmPerMile
+	}
+	return kmh
+}
+
+// distance converts a distance in km to the user's unit.
+func (p SpeedPreferences) distance(km float64) float64 {
+	if p.Unit == unitMph {
+		return km / kmPerMile
+	}
+	return km
+}
+
+func (p SpeedPreferences) speedUnitLabel() string {
+	if p.Unit == unitMph {
+		return "mph"
+	}
+	return "km/h"
+}
+
+func (p SpeedPreferences) distanceUnitLabel() string {
+	if p.Unit == unitMph {
+		return "mi"
+	}
+	return "km"
+}
+
+// units tells API clients which units the speeds and distances in a response are in.
+func (p SpeedPreferences) units() fiber.Map {
+	return fiber.Map{"speed": p.speedUnitLabel(), "distance": p.distanceUnitLabel()}
+}
+
+// inUnit returns the preferences with band thresholds in the user's unit, as they are shown and edited.
+func (p SpeedPreferences) inUnit() SpeedPreferences {
+	shown := p
+	shown.Bands = make([]SpeedBand, len(p.Bands))
+	for i, band := range p.Bands {
+		shown.Bands[i] = SpeedBand{Threshold: math.Round(p.speed(band.Threshold)*10) / 10, Color: band.Color}
+	}
+	return shown
+}
+
+// speedPreferencesKey is where a user's preferences are kept. Like privacy zones, they belong to the account and are
+// only saved to a durable store.
+func speedPreferencesKey(ethAddress string) string {
+	return "speedPreferences_" + strings.ToLower(ethAddress)
+}
+
+// withOwn is the preferences with whatever the user has set in own, whose band thresholds are in km/h, in place of
+// the defaults.
+func (p SpeedPreferences) withOwn(own SpeedPreferences) SpeedPreferences {
+	if own.Unit != "" {
+		p.Unit = own.Unit
+	}
+	if len(own.Bands) > 0 {
+		p.Bands = own.Bands
+	}
+	if own.OverColor != "" {
+		p.OverColor = own.OverColor
+	}
+	return p
+}
+
+// speedPreferences returns the caller's own speed preferences, or the defaults from settings for anything they
+// haven't set. Stored preferences that no longer pass validation, such as ones saved before a limit was tightened,
+// are ignored in favour of the defaults.
+func speedPreferences(c *fiber.Ctx, settings *config.Settings, store SessionStore) (SpeedPreferences, error) {
+	prefs, err := DefaultSpeedPreferences(settings)
+	if err != nil {
+		return prefs, err
+	}
+
+	ethAddress, _ := c.Locals("ethereum_address").(string)
+	raw, found, err := store.Get(c.UserContext(), speedPreferencesKey(ethAddress))
+	if err != nil {
+		return prefs, errors.Wrap(err, "error loading speed preferences")
+	}
+	if !found {
+		return prefs, nil
+	}
+
+	var own SpeedPreferences
+	if err := json.Unmarshal([]byte(raw), &own); err != nil {
+		return prefs, errors.Wrap(err, "error decoding speed preferences")
+	}
+	effective := prefs.withOwn(own)
+	if err := effective.validate(); err != nil {
+		log.Warn().Err(err).Msg("Ignoring invalid stored speed preferences")
+		return prefs, nil
+	}
+	return effective, nil
+}
+
+// HandleSpeedPreferences returns the caller's speed preferences, with band thresholds in their unit.
+func (a *AccountController) HandleSpeedPreferences(c *fiber.Ctx) error {
+	prefs, err := speedPreferences(c, &a.settings, a.store)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load speed preferences")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to load speed preferences"})
+	}
+	return c.JSON(prefs.inUnit())
+}
+
+// HandleSaveSpeedPreferences sets the caller's speed preferences from a JSON SpeedPreferences body, whose band
+// thresholds are in the unit it names. Fields left out keep the defaults.
+func (a *AccountController) HandleSaveSpeedPreferences(c *fiber.Ctx) error {
+	var own SpeedPreferences
+	if err := c.BodyParser(&own); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid speed preferences"})
+	}
+
+	defaults, err := DefaultSpeedPreferences(&a.settings)
+	if err != nil {
+		return err
+	}
+	// stored in km/h, like the defaults
+	if defaults.withOwn(own).Unit == unitMph {
+		for i := range own.Bands {
+			own.Bands[i].Threshold *= kmPerMile
+		}
+	}
+	effective := defaults.withOwn(own)
+	if err := effective.validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	raw, err := json.Marshal(own)
+	if err != nil {
+		return err
+	}
+	ethAddress := c.Locals("ethereum_address").(string)
+	err = updateAccountData(c.UserContext(), a.store, speedPreferencesKey(ethAddress), func() error {
+		// no TTL: preferences are kept until the user resets them
+		return a.store.Set(c.UserContext(), speedPreferencesKey(ethAddress), string(raw), 0)
+	})
+	if err != nil {
+		return accountDataError(c, err, "Failed to save speed preferences")
+	}
+
+	return c.JSON(effective.inUnit())
+}
+
+// HandleResetSpeedPreferences drops the caller's speed preferences, going back to the defaults.
+func (a *AccountController) HandleResetSpeedPreferences(c *fiber.Ctx) error {
+	ethAddress := c.Locals("ethereum_address").(string)
+	err := updateAccountData(c.UserContext(), a.store, speedPreferencesKey(ethAddress), func() error {
+		return a.store.Delete(c.UserContext(), speedPreferencesKey(ethAddress))
+	})
+	if err != nil {
+		return accountDataError(c, err, "Failed to reset speed preferences")
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,249 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dimo-network/trips-web-app/api/internal/config"
+	"github.com/dimo-network/trips-web-app/api/internal/session"
+	"github.com/gofiber/fiber/v2"
+)
+
+func speedSettings(bands, overColor, unit string) *config.Settings {
+	return &config.Settings{SpeedBands: bands, SpeedOverLimitColor: overColor, SpeedUnit: unit}
+}
+
+func TestDefaultSpeedPreferences(t *testing.T) {
+	tests := []struct {
+		name      string
+		bands     string
+		overColor string
+		unit      string
+		want      []SpeedBand
+		wantErr   bool
+	}{
+		{
+			name: "named colors", bands: "10:blue,30:green,50:yellow", overColor: "red", unit: "kmh",
+			want: []SpeedBand{{10, "blue"}, {30, "green"}, {50, "yellow"}},
+		},
+		{
+			name: "hex colors and spaces", bands: " 20.5:#00FF00, 80:#ff0000 ", overColor: "#123abc", unit: "mph",
+			want: []SpeedBand{{20.5, "#00FF00"}, {80, "#ff0000"}},
+		},
+		{name: "empty", bands: "", overColor: "red", unit: "kmh", wantErr: true},
+		{name: "missing color", bands: "10:blue,30", overColor: "red", unit: "kmh", wantErr: true},
+		{name: "bad threshold", bands: "ten:blue", overColor: "red", unit: "kmh", wantErr: true},
+		{name: "zero threshold", bands: "0:blue,30:green", overColor: "red", unit: "kmh", wantErr: true},
+		{name: "thresholds going down", bands: "30:green,10:blue", overColor: "red", unit: "kmh", wantErr: true},
+		{name: "repeated threshold", bands: "30:green,30:blue", overColor: "red", unit: "kmh", wantErr: true},
+		{name: "threshold too fast", bands: "10:blue,401:green", overColor: "red", unit: "kmh", wantErr: true},
+		{name: "too many bands", bands: "1:red,2:red,3:red,4:red,5:red,6:red,7:red,8:red,9:red,10:red,11:red", overColor: "red", unit: "kmh", wantErr: true},
+		{name: "unknown color", bands: "10:teal", overColor: "red", unit: "kmh", wantErr: true},
+		{name: "short hex color", bands: "10:#fff", overColor: "red", unit: "kmh", wantErr: true},
+		{name: "bad over-limit color", bands: "10:blue", overColor: "crimson", unit: "kmh", wantErr: true},
+		{name: "missing over-limit color", bands: "10:blue", overColor: "", unit: "kmh", wantErr: true},
+		{name: "bad unit", bands: "10:blue", overColor: "red", unit: "knots", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			prefs, err := DefaultSpeedPreferences(speedSettings(tc.bands, tc.overColor, tc.unit))
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("got %+v, want an error", prefs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(prefs.Bands) != len(tc.want) {
+				t.Fatalf("bands = %+v, want %+v", prefs.Bands, tc.want)
+			}
+			for i := range tc.want {
+				if prefs.Bands[i] != tc.want[i] {
+					t.Errorf("band %d = %+v, want %+v", i, prefs.Bands[i], tc.want[i])
+				}
+			}
+			if prefs.OverColor != tc.overColor || prefs.Unit != tc.unit {
+				t.Errorf("over color %q and unit %q, want %q and %q", prefs.OverColor, prefs.Unit, tc.overColor, tc.unit)
+			}
+		})
+	}
+}
+
+func TestSpeedPreferencesConvertUnits(t *testing.T) {
+	tests := []struct {
+		unit                        string
+		kmh, km                     float64
+		wantSpeed, wantDistance     float64
+		wantSpeedUnit, wantDistUnit string
+	}{
+		{unitKmh, 100, 42, 100, 42, "km/h", "km"},
+		{unitMph, 160.9344, 1.609344, 100, 1, "mph", "mi"},
+		{unitMph, 0, 0, 0, 0, "mph", "mi"},
+	}
+
+	for _, tc := range tests {
+		prefs := SpeedPreferences{Unit: tc.unit}
+		if got := prefs.speed(tc.kmh); math.Abs(got-tc.wantSpeed) > 1e-9 {
+			t.Errorf("%s: speed(%g) = %g, want %g", tc.unit, tc.kmh, got, tc.wantSpeed)
+		}
+		if got := prefs.distance(tc.km); math.Abs(got-tc.wantDistance) > 1e-9 {
+			t.Errorf("%s: distance(%g) = %g, want %g", tc.unit, tc.km, got, tc.wantDistance)
+		}
+		units := prefs.units()
+		if units["speed"] != tc.wantSpeedUnit || units["distance"] != tc.wantDistUnit {
+			t.Errorf("%s: units = %v, want %s and %s", tc.unit, units, tc.wantSpeedUnit, tc.wantDistUnit)
+		}
+	}
+}
+
+func TestSpeedPreferencesInUnitRoundsThresholds(t *testing.T) {
+	prefs := SpeedPreferences{Unit: unitMph, Bands: []SpeedBand{{Threshold: 50, Color: "green"}, {Threshold: 100, Color: "yellow"}}, OverColor: "red"}
+
+	shown := prefs.inUnit()
+	if shown.Bands[0].Threshold != 31.1 || shown.Bands[1].Threshold != 62.1 {
+		t.Errorf("thresholds = %g and %g mph, want 31.1 and 62.1", shown.Bands[0].Threshold, shown.Bands[1].Threshold)
+	}
+	if prefs.Bands[0].Threshold != 50 {
+		t.Errorf("inUnit changed the km/h thresholds to %g", prefs.Bands[0].Threshold)
+	}
+}
+
+func TestSpeedPreferencesBands(t *testing.T) {
+	prefs := SpeedPreferences{
+		Unit:      unitKmh,
+		Bands:     []SpeedBand{{Threshold: 30, Color: "blue"}, {Threshold: 50, Color: "green"}},
+		OverColor: "red",
+	}
+
+	tests := []struct {
+		kmh       float64
+		wantBand  int
+		wantColor string
+	}{
+		{0, 0, "blue"},
+		{30, 0, "blue"},
+		{30.1, 1, "green"},
+		{50, 1, "green"},
+		{50.1, 2, "red"},
+		{250, 2, "red"},
+	}
+	for _, tc := range tests {
+		if got := prefs.bandIndex(tc.kmh); got != tc.wantBand {
+			t.Errorf("bandIndex(%g) = %d, want %d", tc.kmh, got, tc.wantBand)
+		}
+		kmh := tc.kmh
+		if got := prefs.speedColor(&kmh); got != tc.wantColor {
+			t.Errorf("speedColor(%g) = %q, want %q", tc.kmh, got, tc.wantColor)
+		}
+	}
+	if got := prefs.speedColor(nil); got != missingSpeedColor {
+		t.Errorf("speedColor(nil) = %q, want %q", got, missingSpeedColor)
+	}
+
+	labels := []struct {
+		unit string
+		band int
+		want string
+	}{
+		{unitKmh, -1, "no speed"},
+		{unitKmh, 0, "0-30 km/h"},
+		{unitKmh, 1, "30-50 km/h"},
+		{unitKmh, 2, "over 50 km/h"},
+		{unitMph, 0, "0-18.6 mph"},
+		{unitMph, 1, "18.6-31.1 mph"},
+		{unitMph, 2, "over 31.1 mph"},
+	}
+	for _, tc := range labels {
+		inUnit := prefs
+		inUnit.Unit = tc.unit
+		if got := inUnit.bandLabel(tc.band); got != tc.want {
+			t.Errorf("%s: bandLabel(%d) = %q, want %q", tc.unit, tc.band, got, tc.want)
+		}
+	}
+	if got := prefs.bandColor(-1); got != missingSpeedColor {
+		t.Errorf("bandColor(-1) = %q, want %q", got, missingSpeedColor)
+	}
+}
+
+func TestSpeedPreferencesIgnoreInvalidStoredValues(t *testing.T) {
+	tests := []struct {
+		name      string
+		stored    string
+		wantUnit  string
+		wantBands []SpeedBand
+	}{
+		{
+			name:      "valid",
+			stored:    `{"unit":"mph","bands":[{"threshold":40,"color":"cyan"}]}`,
+			wantUnit:  unitMph,
+			wantBands: []SpeedBand{{Threshold: 24.9, Color: "cyan"}},
+		},
+		{
+			name:      "thresholds going down",
+			stored:    `{"bands":[{"threshold":80,"color":"cyan"},{"threshold":40,"color":"blue"}]}`,
+			wantUnit:  unitKmh,
+			wantBands: []SpeedBand{{Threshold: 30, Color: "blue"}, {Threshold: 50, Color: "green"}},
+		},
+		{
+			name:      "unsupported color",
+			stored:    `{"bands":[{"threshold":40,"color":"javascript:alert(1)"}]}`,
+			wantUnit:  unitKmh,
+			wantBands: []SpeedBand{{Threshold: 30, Color: "blue"}, {Threshold: 50, Color: "green"}},
+		},
+		{
+			name:      "unknown unit",
+			stored:    `{"unit":"knots"}`,
+			wantUnit:  unitKmh,
+			wantBands: []SpeedBand{{Threshold: 30, Color: "blue"}, {Threshold: 50, Color: "green"}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			store := session.NewRedisStore(session.RedisOptions{Addr: miniredis.RunT(t).Addr()})
+			t.Cleanup(func() { _ = store.Close() })
+			if err := store.Set(context.Background(), speedPreferencesKey(testEthAddress), tc.stored, 0); err != nil {
+				t.Fatal(err)
+			}
+
+			a := &AccountController{settings: *speedSettings("30:blue,50:green", "red", unitKmh), store: store}
+			app := fiber.New()
+			app.Use(func(c *fiber.Ctx) error {
+				c.Locals("ethereum_address", testEthAddress)
+				return c.Next()
+			})
+			app.Get("/api/speed-preferences", a.HandleSpeedPreferences)
+
+			resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/api/speed-preferences", nil), -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != fiber.StatusOK {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, fiber.StatusOK)
+			}
+			var got SpeedPreferences
+			if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+
+			if got.Unit != tc.wantUnit || got.OverColor != "red" {
+				t.Errorf("unit %q and over color %q, want %q and red", got.Unit, got.OverColor, tc.wantUnit)
+			}
+			if len(got.Bands) != len(tc.wantBands) {
+				t.Fatalf("bands = %+v, want %+v", got.Bands, tc.wantBands)
+			}
+			for i := range tc.wantBands {
+				if got.Bands[i] != tc.wantBands[i] {
+					t.Errorf("band %d = %+v, want %+v", i, got.Bands[i], tc.wantBands[i])
+				}
+			}
+		})
+	}
+}
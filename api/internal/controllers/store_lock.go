@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// lockTTL bounds how long a lock outlives a holder that crashed or hung before releasing it.
+	lockTTL = 30 * time.Second
+	// lockPollInterval is how often a waiting request checks whether the lock is free.
+	lockPollInterval = 50 * time.Millisecond
+)
+
+// errLockTimeout is returned by withLock when the lock wasn't free within the wait.
+var errLockTimeout = errors.New("timed out waiting for lock")
+
+func lockKey(name string) string {
+	return "lock_" + name
+}
+
+// withLock runs fn while holding the lock called name. The lock lives in the session store, so it serializes fn
+// across replicas as well as requests; callers wait up to wait for it before giving up with errLockTimeout.
+func withLock(ctx context.Context, store SessionStore, name string, wait time.Duration, fn func() error) error {
+	key := lockKey(name)
+	token := uuid.New().String()
+
+	deadline := time.Now().Add(wait)
+	for {
+		acquired, err := store.SetNX(ctx, key, token, lockTTL)
+		if err != nil {
+			return errors.Wrap(err, "error taking lock")
+		}
+		if acquired {
+			break
+		}
+		if time.Now().After(deadline) {
+			return errLockTimeout
+		}
+		if err := sleepContext(ctx, lockPollInterval); err != nil {
+			return err
+		}
+	}
+
+	defer func() {
+		// release even if the request was cancelled, and only if the lock hasn't expired into someone else's hands
+		if _, err := store.DeleteIfEqual(context.WithoutCancel(ctx), key, token); err != nil {
+			log.Warn().Err(err).Str("lock", name).Msg("Failed to release lock")
+		}
+	}()
+
+	return fn()
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
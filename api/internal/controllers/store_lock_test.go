@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dimo-network/trips-web-app/api/internal/session"
+)
+
+func TestWithLockSerializes(t *testing.T) {
+	store := session.NewMemoryStore()
+	ctx := context.Background()
+
+	var (
+		wg      sync.WaitGroup
+		inside  int
+		maxSeen int
+		mu      sync.Mutex
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := withLock(ctx, store, "counter", 5*time.Second, func() error {
+				mu.Lock()
+				inside++
+				if inside > maxSeen {
+					maxSeen = inside
+				}
+				mu.Unlock()
+
+				time.Sleep(5 * time.Millisecond)
+
+				mu.Lock()
+				inside--
+				mu.Unlock()
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if maxSeen != 1 {
+		t.Errorf("%d holders were inside the lock at once, want 1", maxSeen)
+	}
+}
+
+func TestWithLockTimesOut(t *testing.T) {
+	store := session.NewMemoryStore()
+	ctx := context.Background()
+
+	if _, err := store.SetNX(ctx, lockKey("busy"), "someone-else", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	called := false
+	err := withLock(ctx, store, "busy", 100*time.Millisecond, func() error {
+		called = true
+		return nil
+	})
+	if !errors.Is(err, errLockTimeout) {
+		t.Errorf("withLock() error = %v, want errLockTimeout", err)
+	}
+	if called {
+		t.Error("fn ran without the lock")
+	}
+	// someone else's lock is left alone
+	if value, _, _ := store.Get(ctx, lockKey("busy")); value != "someone-else" {
+		t.Errorf("lock holder = %q, want someone-else", value)
+	}
+}
+
+func TestWithLockLeavesALockThatChangedHands(t *testing.T) {
+	store := session.NewMemoryStore()
+	ctx := context.Background()
+
+	err := withLock(ctx, store, "slow", time.Second, func() error {
+		// our lock expired while fn ran and another replica took it
+		return store.Set(ctx, lockKey("slow"), "someone-else", time.Minute)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, _, _ := store.Get(ctx, lockKey("slow")); value != "someone-else" {
+		t.Errorf("lock holder = %q, want someone-else", value)
+	}
+}
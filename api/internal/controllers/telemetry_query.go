@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dimo-network/trips-web-app/api/internal/dimo"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+)
+
+const (
+	// telemetryTargetPoints is about how many samples an adaptive interval aims to give a trip: enough to follow
+	// city streets on a short trip without drawing thousands of points for a long one.
+	telemetryTargetPoints = 360
+
+	// maxTelemetrySamples caps how many samples a resolution override can ask for, however long the trip.
+	maxTelemetrySamples = 5000
+
+	minTelemetryInterval = time.Second
+	maxTelemetryInterval = time.Hour
+)
+
+// telemetryIntervals are the intervals adaptiveInterval chooses between, shortest first.
+var telemetryIntervals = []time.Duration{
+	5 * time.Second,
+	10 * time.Second,
+	15 * time.Second,
+	30 * time.Second,
+	time.Minute,
+	2 * time.Minute,
+	5 * time.Minute,
+	10 * time.Minute,
+}
+
+// telemetryQuery builds the Telemetry API query for a trip from the optional resolution and signals params.
+// resolution is a duration such as 10s or 1m and overrides the adaptive interval, though never so far that the trip
+// would come back in more than maxTelemetrySamples samples; signals is a comma-separated list of dimo.ExtraSignals
+// names.
+func telemetryQuery(c *fiber.Ctx, tokenID int64, start, end time.Time) (dimo.SignalsQuery, error) {
+	query := dimo.SignalsQuery{TokenID: tokenID, From: start, To: end}
+
+	interval := adaptiveInterval(end.Sub(start))
+	if raw := c.Query("resolution"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed < minTelemetryInterval || parsed > maxTelemetryInterval {
+			return query, errors.New("resolution must be a duration between 1s and 1h, such as 10s or 1m")
+		}
+		interval = cappedInterval(end.Sub(start), parsed.Truncate(time.Second))
+	}
+	query.Interval = formatInterval(interval)
+
+	for _, name := range strings.Split(c.Query("signals"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := dimo.ExtraSignals[name]; !ok {
+			return query, errors.Errorf("signals must be a comma-separated list of %s", strings.Join(extraSignalNames(), ", "))
+		}
+		query.Extra = append(query.Extra, name)
+	}
+
+	return query, nil
+}
+
+// adaptiveInterval picks the shortest of telemetryIntervals that keeps a trip of the given length to about
+// telemetryTargetPoints samples.
+func adaptiveInterval(duration time.Duration) time.Duration {
+	for _, interval := range telemetryIntervals {
+		if duration/interval <= telemetryTargetPoints {
+			return interval
+		}
+	}
+	return telemetryIntervals[len(telemetryIntervals)-1]
+}
+
+// cappedInterval raises interval to the shortest whole number of seconds that keeps a trip of the given length to
+// maxTelemetrySamples samples, if it is shorter than that.
+func cappedInterval(duration, interval time.Duration) time.Duration {
+	shortest := time.Duration(math.Ceil(duration.Seconds()/maxTelemetrySamples)) * time.Second
+	if interval < shortest {
+		return shortest
+	}
+	return interval
+}
+
+// formatInterval writes an interval in whole seconds, which is how the Telemetry API expects it.
+func formatInterval(interval time.Duration) string {
+	return strconv.FormatInt(int64(interval/time.Second), 10) + "s"
+}
+
+func extraSignalNames() []string {
+	names := make([]string, 0, len(dimo.ExtraSignals))
+	for name := range dimo.ExtraSignals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dimo-network/trips-web-app/api/internal/dimo"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestAdaptiveInterval(t *testing.T) {
+	tests := []struct {
+		duration time.Duration
+		want     time.Duration
+	}{
+		{0, 5 * time.Second},
+		{10 * time.Minute, 5 * time.Second},
+		{30 * time.Minute, 5 * time.Second},
+		{31 * time.Minute, 10 * time.Second},
+		{time.Hour, 10 * time.Second},
+		{90 * time.Minute, 15 * time.Second},
+		{3 * time.Hour, 30 * time.Second},
+		{6 * time.Hour, time.Minute},
+		{12 * time.Hour, 2 * time.Minute},
+		{30 * time.Hour, 5 * time.Minute},
+		{60 * time.Hour, 10 * time.Minute},
+		// past what the coarsest interval keeps to the target, it is used anyway
+		{200 * time.Hour, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		got := adaptiveInterval(tt.duration)
+		if got != tt.want {
+			t.Errorf("adaptiveInterval(%s) = %s, want %s", tt.duration, got, tt.want)
+		}
+		if tt.duration <= 60*time.Hour && tt.duration/got > telemetryTargetPoints {
+			t.Errorf("adaptiveInterval(%s) gives %d samples, over the target", tt.duration, tt.duration/got)
+		}
+	}
+}
+
+func TestCappedInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		duration time.Duration
+		interval time.Duration
+		want     time.Duration
+	}{
+		{name: "short trip keeps 1s", duration: time.Hour, interval: time.Second, want: time.Second},
+		{name: "exactly at the cap", duration: maxTelemetrySamples * time.Second, interval: time.Second, want: time.Second},
+		{name: "just over the cap", duration: maxTelemetrySamples*time.Second + time.Second, interval: time.Second, want: 2 * time.Second},
+		{name: "long trip raised", duration: 8 * time.Hour, interval: time.Second, want: 6 * time.Second},
+		{name: "coarse enough already", duration: 8 * time.Hour, interval: time.Minute, want: time.Minute},
+		{name: "day-long trip", duration: 24 * time.Hour, interval: 5 * time.Second, want: 18 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := cappedInterval(tt.duration, tt.interval)
+			if got != tt.want {
+				t.Errorf("cappedInterval(%s, %s) = %s, want %s", tt.duration, tt.interval, got, tt.want)
+			}
+			if tt.duration/got > maxTelemetrySamples {
+				t.Errorf("%s at %s is %d samples, over the cap", tt.duration, got, tt.duration/got)
+			}
+		})
+	}
+}
+
+func TestTelemetryQuery(t *testing.T) {
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		query        string
+		length       time.Duration
+		wantInterval string
+		wantExtra    []string
+		wantErr      bool
+	}{
+		{name: "adaptive", query: "", length: time.Hour, wantInterval: "10s"},
+		{name: "override", query: "resolution=1m", length: time.Hour, wantInterval: "60s"},
+		{name: "fractional seconds truncated", query: "resolution=2500ms", length: time.Hour, wantInterval: "2s"},
+		{name: "1s on a long trip is capped", query: "resolution=1s", length: 10 * time.Hour, wantInterval: "8s"},
+		{name: "signals", query: "signals=odometer,%20fuelLevel", length: time.Hour, wantInterval: "10s", wantExtra: []string{"odometer", "fuelLevel"}},
+		{name: "too fine", query: "resolution=500ms", length: time.Hour, wantErr: true},
+		{name: "too coarse", query: "resolution=2h", length: time.Hour, wantErr: true},
+		{name: "not a duration", query: "resolution=fast", length: time.Hour, wantErr: true},
+		{name: "unknown signal", query: "signals=odometer,tirePressure", length: time.Hour, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				query dimo.SignalsQuery
+				err   error
+			)
+			app := fiber.New()
+			app.Get("/", func(c *fiber.Ctx) error {
+				query, err = telemetryQuery(c, 7, start, start.Add(tt.length))
+				return nil
+			})
+			if _, testErr := app.Test(httptest.NewRequest(fiber.MethodGet, "/?"+tt.query, nil)); testErr != nil {
+				t.Fatal(testErr)
+			}
+
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("telemetryQuery(%q) succeeded, want an error", tt.query)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("telemetryQuery(%q) error = %v", tt.query, err)
+			}
+			if query.Interval != tt.wantInterval {
+				t.Errorf("Interval = %s, want %s", query.Interval, tt.wantInterval)
+			}
+			if len(query.Extra) != len(tt.wantExtra) {
+				t.Fatalf("Extra = %v, want %v", query.Extra, tt.wantExtra)
+			}
+			for i := range tt.wantExtra {
+				if query.Extra[i] != tt.wantExtra[i] {
+					t.Errorf("Extra = %v, want %v", query.Extra, tt.wantExtra)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// maxPlausibleSpeedKmh is faster than any car gets between two fixes; a leg that implies more is a bad fix.
+	maxPlausibleSpeedKmh = 250.0
+	// gapIntervals is how many sampling intervals can pass without a fix before the track is split into segments.
+	gapIntervals = 5
+)
+
+// TrackCleaning reports what cleanTrack did to a trip's telemetry.
+type TrackCleaning struct {
+	Samples int `json:"samples"`
+	// MissingPosition is samples without coordinates, such as ones only carrying a speed.
+	MissingPosition int `json:"missingPosition"`
+	// InvalidPosition is fixes at 0,0 or outside the valid range of latitude and longitude.
+	InvalidPosition int `json:"invalidPosition"`
+	// ImpossibleSpeed is fixes that would need the vehicle to jump faster than maxPlausibleSpeedKmh.
+	ImpossibleSpeed int `json:"impossibleSpeed"`
+	// Filtered is every sample dropped as missing or bad.
+	Filtered int `json:"filtered"`
+	// PrivacyZone is good fixes hidden because they fall in one of the user's privacy zones.
+	PrivacyZone int `json:"privacyZone"`
+	Segments    int `json:"segments"`
+}
+
+// cleanTrack drops the samples that can't be drawn or can't be right, and those inside any of zones, then numbers
+// the remaining ones by segment. A new segment starts wherever more than gapIntervals sampling intervals pass
+// between fixes, and wherever fixes were hidden, so no line is drawn through a privacy zone. The result starts and
+// ends on real fixes, so its first and last samples are the trip's visible start and end.
+func cleanTrack(locations []LocationData, zones []PrivacyZone) ([]LocationData, TrackCleaning) {
+	report := TrackCleaning{Samples: len(locations)}
+
+	candidates := make([]LocationData, 0, len(locations))
+	for _, loc := range locations {
+		switch {
+		case loc.Latitude == nil || loc.Longitude == nil:
+			report.MissingPosition++
+		case !validPosition(*loc.Latitude, *loc.Longitude):
+			report.InvalidPosition++
+		default:
+			candidates = append(candidates, loc)
+		}
+	}
+
+	// a lone fix far from both its neighbours is a spike; a fix that only disagrees with the one before it is where
+	// the track really went, so it stays
+	plausible := make([]LocationData, 0, len(candidates))
+	for i, loc := range candidates {
+		hasNext := i+1 < len(candidates)
+		if len(plausible) > 0 {
+			if impossibleLeg(plausible[len(plausible)-1], loc) && (!hasNext || impossibleLeg(loc, candidates[i+1])) {
+				report.ImpossibleSpeed++
+				continue
+			}
+		} else if i+2 < len(candidates) && impossibleLeg(loc, candidates[i+1]) && !impossibleLeg(candidates[i+1], candidates[i+2]) {
+			report.ImpossibleSpeed++
+			continue
+		}
+		plausible = append(plausible, loc)
+	}
+
+	cleaned := make([]LocationData, 0, len(plausible))
+	// afterHidden marks the fixes that come straight after a stretch hidden by a privacy zone
+	afterHidden := make(map[int]bool)
+	hidden := false
+	for _, loc := range plausible {
+		if inPrivacyZone(zones, *loc.Latitude, *loc.Longitude) {
+			report.PrivacyZone++
+			hidden = true
+			continue
+		}
+		if hidden && len(cleaned) > 0 {
+			afterHidden[len(cleaned)] = true
+		}
+		hidden = false
+		cleaned = append(cleaned, loc)
+	}
+
+	interval := samplingInterval(cleaned)
+	for i := 1; i < len(cleaned); i++ {
+		cleaned[i].Segment = cleaned[i-1].Segment
+		elapsed, ok := legDuration(cleaned[i-1], cleaned[i])
+		if afterHidden[i] || (interval > 0 && ok && elapsed > gapIntervals*interval) {
+			cleaned[i].Segment++
+		}
+	}
+	if len(cleaned) > 0 {
+		report.Segments = cleaned[len(cleaned)-1].Segment + 1
+	}
+	report.Filtered = report.MissingPosition + report.InvalidPosition + report.ImpossibleSpeed
+
+	return cleaned, report
+}
+
+func validPosition(latitude, longitude float64) bool {
+	if latitude == 0 && longitude == 0 {
+		return false
+	}
+	return math.Abs(latitude) <= 90 && math.Abs(longitude) <= 180
+}
+
+// impossibleLeg reports whether getting from one fix to the next would take more than maxPlausibleSpeedKmh.
+// Legs without usable timestamps are given the benefit of the doubt.
+func impossibleLeg(from, to LocationData) bool {
+	elapsed, ok := legDuration(from, to)
+	if !ok || elapsed <= 0 {
+		return false
+	}
+	return haversineKm(*from.Latitude, *from.Longitude, *to.Latitude, *to.Longitude)/elapsed.Hours() > maxPlausibleSpeedKmh
+}
+
+func legDuration(from, to LocationData) (time.Duration, bool) {
+	fromTime, err := time.Parse(time.RFC3339, from.Timestamp)
+	if err != nil {
+		return 0, false
+	}
+	toTime, err := time.Parse(time.RFC3339, to.Timestamp)
+	if err != nil {
+		return 0, false
+	}
+	return toTime.Sub(fromTime), true
+}
+
+// samplingInterval is the median time between consecutive fixes, which is the interval the telemetry was
+// aggregated at wherever the vehicle was reporting.
+func samplingInterval(locations []LocationData) time.Duration {
+	var intervals []time.Duration
+	for i := 1; i < len(locations); i++ {
+		if elapsed, ok := legDuration(locations[i-1], locations[i]); ok && elapsed > 0 {
+			intervals = append(intervals, elapsed)
+		}
+	}
+	if len(intervals) == 0 {
+		return 0
+	}
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i] < intervals[j] })
+	return intervals[len(intervals)/2]
+}
@@ -0,0 +1,207 @@
+package controllers
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/dimo-network/trips-web-app/api/internal/dimo"
+	"github.com/gofiber/fiber/v2"
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// maxSimplifyTolerance caps the tolerance param; anything coarser would turn a city trip into a few straight lines.
+const maxSimplifyTolerance = 1000.0
+
+// trackShape is how a trip's track is sent back: which geometry, and how much it is simplified.
+type trackShape struct {
+	// geometry is points, one Point feature per sample; linestring, a LineString per segment; or multilinestring,
+	// a MultiLineString feature per speed band. The line geometries still carry start and end Point features.
+	geometry string
+	// algorithm is dp for Douglas-Peucker or vw for Visvalingam-Whyatt.
+	algorithm string
+	// tolerance is in metres; zero leaves the track as it was sampled.
+	tolerance float64
+}
+
+// trackShapeQuery reads the optional geometry, simplify and tolerance params. The defaults, one point per sample
+// and no simplification, are what /api/trip/:tripID has always returned.
+func trackShapeQuery(c *fiber.Ctx) (trackShape, error) {
+	shape := trackShape{geometry: c.Query("geometry", "points"), algorithm: c.Query("simplify", "dp")}
+
+	switch shape.geometry {
+	case "points", "linestring", "multilinestring":
+	default:
+		return shape, fiber.NewError(fiber.StatusBadRequest, "geometry must be one of points, linestring or multilinestring")
+	}
+	if shape.algorithm != "dp" && shape.algorithm != "vw" {
+		return shape, fiber.NewError(fiber.StatusBadRequest, "simplify must be dp or vw")
+	}
+	if raw := c.Query("tolerance"); raw != "" {
+		tolerance, err := strconv.ParseFloat(raw, 64)
+		if err != nil || tolerance < 0 || tolerance > maxSimplifyTolerance {
+			return shape, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("tolerance must be a distance in metres between 0 and %g", maxSimplifyTolerance))
+		}
+		shape.tolerance = tolerance
+	}
+
+	return shape, nil
+}
+
+// trackGeoJSON builds the feature collection for a track in the requested geometry.
+func trackGeoJSON(locations []LocationData, estimatedStart *dimo.LatLon, geometry string, prefs SpeedPreferences) *geojson.FeatureCollection {
+	switch geometry {
+	case "linestring":
+		return trackLineString(locations, estimatedStart, prefs)
+	case "multilinestring":
+		return trackSpeedBands(locations, estimatedStart, prefs)
+	default:
+		return convertToGeoJSON(locations, estimatedStart, prefs)
+	}
+}
+
+// trackLineString returns the track as a LineString per segment, with the timestamp and speed of each vertex in
+// parallel arrays so a client can still colour it along its length, followed by its start and end points. Speeds
+// are in the user's unit.
+func trackLineString(locations []LocationData, estimatedStart *dimo.LatLon, prefs SpeedPreferences) *geojson.FeatureCollection {
+	collection := geojson.NewFeatureCollection()
+	if estimatedStart != nil {
+		collection.AddFeature(estimatedStartFeature(estimatedStart))
+	}
+
+	for _, segment := range trackSegments(positionedLocations(locations)) {
+		// a LineString needs two positions
+		if len(segment) < 2 {
+			continue
+		}
+		coordinates := make([][]float64, len(segment))
+		timestamps := make([]string, len(segment))
+		speeds := make([]*float64, len(segment))
+		for i, loc := range segment {
+			coordinates[i] = []float64{*loc.Longitude, *loc.Latitude}
+			timestamps[i] = loc.Timestamp
+			if loc.Speed != nil {
+				speed := prefs.speed(*loc.Speed)
+				speeds[i] = &speed
+			}
+		}
+
+		line := geojson.NewLineStringFeature(coordinates)
+		line.Properties["point_type"] = "route"
+		line.Properties["segment"] = segment[0].Segment
+		line.Properties["timestamps"] = timestamps
+		line.Properties["speeds"] = speeds
+		collection.AddFeature(line)
+	}
+
+	addTrackEnds(collection, locations)
+	return collection
+}
+
+// trackSpeedBands returns the track as one MultiLineString per speed band, each with the band's colour, so it can
+// be drawn as a gradient without per-point features other than its start and end.
+func trackSpeedBands(locations []LocationData, estimatedStart *dimo.LatLon, prefs SpeedPreferences) *geojson.FeatureCollection {
+	collection := geojson.NewFeatureCollection()
+	if estimatedStart != nil {
+		collection.AddFeature(estimatedStartFeature(estimatedStart))
+	}
+
+	lines := make(map[int][][][]float64)
+	for _, run := range speedBandRuns(positionedLocations(locations), prefs) {
+		line := make([][]float64, len(run.points))
+		for i, loc := range run.points {
+			line[i] = []float64{*loc.Longitude, *loc.Latitude}
+		}
+		lines[run.band] = append(lines[run.band], line)
+	}
+
+	// slowest band first, after the stretches without a speed
+	for band := -1; band <= len(prefs.Bands); band++ {
+		if len(lines[band]) == 0 {
+			continue
+		}
+		feature := geojson.NewMultiLineStringFeature(lines[band]...)
+		feature.Properties["color"] = prefs.bandColor(band)
+		feature.Properties["label"] = prefs.bandLabel(band)
+		collection.AddFeature(feature)
+	}
+
+	addTrackEnds(collection, locations)
+	return collection
+}
+
+// addTrackEnds adds Point features for the first and last positioned samples, marked the way convertToGeoJSON
+// marks them, so the start and end markers don't depend on which geometry was asked for.
+func addTrackEnds(collection *geojson.FeatureCollection, locations []LocationData) {
+	points := positionedLocations(locations)
+	if len(points) == 0 {
+		return
+	}
+	if len(points) > 1 {
+		collection.AddFeature(trackEndFeature(points[0], "start", "black"))
+	}
+	collection.AddFeature(trackEndFeature(points[len(points)-1], "end", "red"))
+}
+
+func trackEndFeature(loc LocationData, pointType, color string) *geojson.Feature {
+	feature := geojson.NewPointFeature([]float64{*loc.Longitude, *loc.Latitude})
+	feature.Properties["point_type"] = pointType
+	feature.Properties["color"] = color
+	feature.Properties["timestamp"] = loc.Timestamp
+	feature.Properties["segment"] = loc.Segment
+	return feature
+}
+
+// speedBandRun is a stretch of track whose legs all fall in one band of SpeedPreferences.bandIndex, or -1 where
+// the speed wasn't sampled.
+type speedBandRun struct {
+	band   int
+	points []LocationData
+}
+
+// speedBandRuns splits positioned samples wherever the speed band changes or a new segment starts. Each leg is put
+// in the band of the speed it started at, and consecutive runs in a segment share their boundary sample so the
+// track stays joined up; legs across a gap are left out.
+func speedBandRuns(points []LocationData, prefs SpeedPreferences) []speedBandRun {
+	var (
+		runs   []speedBandRun
+		joined bool
+	)
+	for i := 1; i < len(points); i++ {
+		if points[i].Segment != points[i-1].Segment {
+			joined = false
+			continue
+		}
+		band := -1
+		if points[i-1].Speed != nil {
+			band = prefs.bandIndex(*points[i-1].Speed)
+		}
+		if !joined || runs[len(runs)-1].band != band {
+			runs = append(runs, speedBandRun{band: band, points: []LocationData{points[i-1]}})
+		}
+		run := &runs[len(runs)-1]
+		run.points = append(run.points, points[i])
+		joined = true
+	}
+	return runs
+}
+
+// trackSegments splits samples into their segments, in order.
+func trackSegments(points []LocationData) [][]LocationData {
+	var segments [][]LocationData
+	for start := 0; start < len(points); {
+		end := start + 1
+		for end < len(points) && points[end].Segment == points[start].Segment {
+			end++
+		}
+		segments = append(segments, points[start:end])
+		start = end
+	}
+	return segments
+}
+
+func estimatedStartFeature(estimatedStart *dimo.LatLon) *geojson.Feature {
+	feature := geojson.NewPointFeature([]float64{estimatedStart.Longitude, estimatedStart.Latitude})
+	feature.Properties["point_type"] = "estimated_start"
+	feature.Properties["color"] = "black"
+	return feature
+}
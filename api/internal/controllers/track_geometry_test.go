@@ -0,0 +1,33 @@
+package controllers
+
+import (
+	"testing"
+)
+
+func TestTrackGeoJSONMarksStartAndEndInEveryGeometry(t *testing.T) {
+	track := wavyTrack(10)
+	prefs := SpeedPreferences{Unit: unitKmh, Bands: []SpeedBand{{Threshold: 50, Color: "green"}}, OverColor: "red"}
+
+	for _, geometry := range []string{"points", "linestring", "multilinestring"} {
+		t.Run(geometry, func(t *testing.T) {
+			ends := make(map[string]string)
+			for _, feature := range trackGeoJSON(track, nil, geometry, prefs).Features {
+				pointType, _ := feature.Properties["point_type"].(string)
+				if pointType != "start" && pointType != "end" {
+					continue
+				}
+				if !feature.Geometry.IsPoint() {
+					t.Fatalf("%s is a %s, want a Point", pointType, feature.Geometry.Type)
+				}
+				ends[pointType] = feature.Properties["timestamp"].(string)
+			}
+
+			if ends["start"] != track[0].Timestamp {
+				t.Errorf("start is at %q, want %q", ends["start"], track[0].Timestamp)
+			}
+			if ends["end"] != track[len(track)-1].Timestamp {
+				t.Errorf("end is at %q, want %q", ends["end"], track[len(track)-1].Timestamp)
+			}
+		})
+	}
+}
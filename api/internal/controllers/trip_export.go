@@ -0,0 +1,300 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dimo-network/trips-web-app/api/internal/config"
+	"github.com/dimo-network/trips-web-app/api/internal/dimo"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+)
+
+// exportFormat is a file format a trip can be downloaded in. Formats that band or report speeds do so by the user's
+// speed preferences.
+type exportFormat struct {
+	extension   string
+	contentType string
+	write       func(w io.Writer, tripID string, locations []LocationData, estimatedStart *dimo.LatLon, prefs SpeedPreferences) error
+}
+
+var exportFormats = map[string]exportFormat{
+	"gpx":     {extension: "gpx", contentType: "application/gpx+xml", write: writeGPX},
+	"kml":     {extension: "kml", contentType: "application/vnd.google-earth.kml+xml", write: writeKML},
+	"csv":     {extension: "csv", contentType: "text/csv; charset=utf-8", write: writeCSV},
+	"geojson": {extension: "geojson", contentType: "application/geo+json", write: writeGeoJSON},
+}
+
+// HandleTripExport downloads a trip's telemetry as GPX, KML, CSV or GeoJSON, picked by the format query param.
+// It takes the same tokenId, start, end, estimatedStart, resolution and signals params as /api/trip/:tripID, and
+// exports the same cleaned track, with the user's privacy zones hidden.
+func HandleTripExport(settings *config.Settings, client *dimo.Client, store SessionStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tripID := c.Params("tripID")
+
+		format, ok := exportFormats[c.Query("format", "gpx")]
+		if !ok {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "format must be one of gpx, kml, csv or geojson"})
+		}
+		estimatedStart, err := ParseEstimatedStart(c)
+		if err != nil {
+			return tripError(c, tripID, err)
+		}
+
+		zones, err := userPrivacyZones(c, store)
+		if err != nil {
+			return tripError(c, tripID, err)
+		}
+		prefs, err := speedPreferences(c, settings, store)
+		if err != nil {
+			return tripError(c, tripID, err)
+		}
+
+		locations, _, err := tripLocations(c, client, store, tripID, c.Query("start"), c.Query("end"))
+		if err != nil {
+			return tripError(c, tripID, err)
+		}
+		locations, _ = cleanTrack(locations, zones)
+		estimatedStart = maskLocation(estimatedStart, zones)
+
+		var body bytes.Buffer
+		if err := format.write(&body, tripID, locations, estimatedStart, prefs); err != nil {
+			return errors.Wrapf(err, "error writing trip %s as %s", tripID, format.extension)
+		}
+
+		c.Attachment(fmt.Sprintf("trip_%s.%s", tripID, format.extension))
+		c.Set(fiber.HeaderContentType, format.contentType)
+		return c.Send(body.Bytes())
+	}
+}
+
+// kmhToMetersPerSecond converts the Telemetry API's speeds to the unit the GPX track point extension uses.
+func kmhToMetersPerSecond(kmh float64) float64 {
+	return kmh / 3.6
+}
+
+type gpxDocument struct {
+	XMLName   xml.Name `xml:"gpx"`
+	Version   string   `xml:"version,attr"`
+	Creator   string   `xml:"creator,attr"`
+	Namespace string   `xml:"xmlns,attr"`
+	TPXNS     string   `xml:"xmlns:gpxtpx,attr"`
+	Track     gpxTrack `xml:"trk"`
+}
+
+type gpxTrack struct {
+	Name     string            `xml:"name"`
+	Segments []gpxTrackSegment `xml:"trkseg"`
+}
+
+type gpxTrackSegment struct {
+	Points []gpxTrackPoint `xml:"trkpt"`
+}
+
+type gpxTrackPoint struct {
+	Latitude   float64        `xml:"lat,attr"`
+	Longitude  float64        `xml:"lon,attr"`
+	Time       string         `xml:"time,omitempty"`
+	Extensions *gpxExtensions `xml:"extensions,omitempty"`
+}
+
+type gpxExtensions struct {
+	Speed float64 `xml:"gpxtpx:TrackPointExtension>gpxtpx:speed"`
+}
+
+// writeGPX writes the trip as a GPX 1.1 track with a trkseg per segment. Speeds go in Garmin's
+// TrackPointExtension, in m/s whatever the user's unit.
+func writeGPX(w io.Writer, tripID string, locations []LocationData, estimatedStart *dimo.LatLon, _ SpeedPreferences) error {
+	doc := gpxDocument{
+		Version:   "1.1",
+		Creator:   "DIMO Trips",
+		Namespace: "http://www.topografix.com/GPX/1/1",
+		TPXNS:     "http://www.garmin.com/xmlschemas/TrackPointExtension/v2",
+		Track:     gpxTrack{Name: "Trip " + tripID},
+	}
+	for _, segment := range trackSegments(positionedLocations(locations)) {
+		var trkseg gpxTrackSegment
+		for _, loc := range segment {
+			point := gpxTrackPoint{Latitude: *loc.Latitude, Longitude: *loc.Longitude, Time: loc.Timestamp}
+			if loc.Speed != nil {
+				point.Extensions = &gpxExtensions{Speed: kmhToMetersPerSecond(*loc.Speed)}
+			}
+			trkseg.Points = append(trkseg.Points, point)
+		}
+		doc.Track.Segments = append(doc.Track.Segments, trkseg)
+	}
+	if estimatedStart != nil {
+		if len(doc.Track.Segments) == 0 {
+			doc.Track.Segments = []gpxTrackSegment{{}}
+		}
+		first := &doc.Track.Segments[0]
+		first.Points = append([]gpxTrackPoint{{Latitude: estimatedStart.Latitude, Longitude: estimatedStart.Longitude}}, first.Points...)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	return encoder.Encode(doc)
+}
+
+// kmlColor converts a speed band colour, named or #rrggbb, to KML's opaque aabbggrr notation.
+func kmlColor(color string) string {
+	rgb, ok := namedColors[color]
+	if !ok {
+		rgb = strings.ToLower(strings.TrimPrefix(color, "#"))
+	}
+	return "ff" + rgb[4:6] + rgb[2:4] + rgb[0:2]
+}
+
+// kmlStyleID names the style of a band from SpeedPreferences.bandIndex, or -1 for stretches without a speed.
+func kmlStyleID(band int) string {
+	if band < 0 {
+		return "speed-none"
+	}
+	return "speed-" + strconv.Itoa(band)
+}
+
+type kmlDocument struct {
+	XMLName   xml.Name     `xml:"kml"`
+	Namespace string       `xml:"xmlns,attr"`
+	Document  kmlContainer `xml:"Document"`
+}
+
+type kmlContainer struct {
+	Name       string         `xml:"name"`
+	Styles     []kmlStyle     `xml:"Style"`
+	Placemarks []kmlPlacemark `xml:"Placemark"`
+}
+
+type kmlStyle struct {
+	ID    string `xml:"id,attr"`
+	Color string `xml:"LineStyle>color"`
+	Width int    `xml:"LineStyle>width"`
+}
+
+type kmlPlacemark struct {
+	Name        string         `xml:"name"`
+	Description string         `xml:"description,omitempty"`
+	StyleURL    string         `xml:"styleUrl,omitempty"`
+	LineString  *kmlCoordinate `xml:"LineString,omitempty"`
+	Point       *kmlCoordinate `xml:"Point,omitempty"`
+}
+
+type kmlCoordinate struct {
+	Coordinates string `xml:"coordinates"`
+}
+
+// writeKML writes the trip as a KML line split wherever the speed band changes, each piece styled in its band's
+// colour and named by its range, plus start and end placemarks.
+func writeKML(w io.Writer, tripID string, locations []LocationData, estimatedStart *dimo.LatLon, prefs SpeedPreferences) error {
+	doc := kmlDocument{
+		Namespace: "http://www.opengis.net/kml/2.2",
+		Document:  kmlContainer{Name: "Trip " + tripID},
+	}
+	for band := -1; band <= len(prefs.Bands); band++ {
+		doc.Document.Styles = append(doc.Document.Styles, kmlStyle{ID: kmlStyleID(band), Color: kmlColor(prefs.bandColor(band)), Width: 4})
+	}
+
+	if estimatedStart != nil {
+		doc.Document.Placemarks = append(doc.Document.Placemarks, kmlPlacemark{
+			Name:  "Estimated start",
+			Point: &kmlCoordinate{Coordinates: kmlPosition(estimatedStart.Longitude, estimatedStart.Latitude)},
+		})
+	}
+
+	points := positionedLocations(locations)
+
+	// each leg is coloured by the speed it started at, and consecutive legs in the same band share a placemark
+	for _, run := range speedBandRuns(points, prefs) {
+		coordinates := make([]string, len(run.points))
+		for i, loc := range run.points {
+			coordinates[i] = kmlPosition(*loc.Longitude, *loc.Latitude)
+		}
+		doc.Document.Placemarks = append(doc.Document.Placemarks, kmlPlacemark{
+			Name:       prefs.bandLabel(run.band),
+			StyleURL:   "#" + kmlStyleID(run.band),
+			LineString: &kmlCoordinate{Coordinates: strings.Join(coordinates, " ")},
+		})
+	}
+
+	if len(points) > 0 {
+		first, end := points[0], points[len(points)-1]
+		doc.Document.Placemarks = append(doc.Document.Placemarks,
+			kmlPlacemark{Name: "Start", Description: first.Timestamp, Point: &kmlCoordinate{Coordinates: kmlPosition(*first.Longitude, *first.Latitude)}},
+			kmlPlacemark{Name: "End", Description: end.Timestamp, Point: &kmlCoordinate{Coordinates: kmlPosition(*end.Longitude, *end.Latitude)}},
+		)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	return encoder.Encode(doc)
+}
+
+func kmlPosition(longitude, latitude float64) string {
+	return strconv.FormatFloat(longitude, 'f', -1, 64) + "," + strconv.FormatFloat(latitude, 'f', -1, 64)
+}
+
+// writeCSV writes one row per telemetry sample, with a column for each extra signal that was fetched. Speeds are in
+// the user's unit, which the column is named after. Missing values are left empty.
+func writeCSV(w io.Writer, _ string, locations []LocationData, _ *dimo.LatLon, prefs SpeedPreferences) error {
+	var signals []string
+	seen := map[string]bool{}
+	for _, loc := range locations {
+		for name := range loc.Signals {
+			if !seen[name] {
+				seen[name] = true
+				signals = append(signals, name)
+			}
+		}
+	}
+	sort.Strings(signals)
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(append([]string{"timestamp", "latitude", "longitude", "speed_" + prefs.Unit}, signals...)); err != nil {
+		return err
+	}
+	for _, loc := range locations {
+		speed := ""
+		if loc.Speed != nil {
+			speed = strconv.FormatFloat(prefs.speed(*loc.Speed), 'f', -1, 64)
+		}
+		row := []string{loc.Timestamp, formatOptional(loc.Latitude), formatOptional(loc.Longitude), speed}
+		for _, name := range signals {
+			value, ok := loc.Signals[name]
+			if ok {
+				row = append(row, strconv.FormatFloat(value, 'f', -1, 64))
+			} else {
+				row = append(row, "")
+			}
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func formatOptional(value *float64) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*value, 'f', -1, 64)
+}
+
+// writeGeoJSON writes the same feature collection /api/trip/:tripID returns to the map.
+func writeGeoJSON(w io.Writer, _ string, locations []LocationData, estimatedStart *dimo.LatLon, prefs SpeedPreferences) error {
+	return json.NewEncoder(w).Encode(convertToGeoJSON(locations, estimatedStart, prefs))
+}
@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dimo-network/trips-web-app/api/internal/dimo"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// exportTripID has every character XML, CSV and JSON need to escape.
+const exportTripID = `a&b<"c">'d`
+
+// exportFixture is a short trip that passes through a privacy zone, cleaned the way HandleTripExport cleans it.
+// The fourth fix is inside the zone, so it is hidden and the track is split into two segments around it.
+func exportFixture() ([]LocationData, *dimo.LatLon) {
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	speeds := []float64{12, 36, 54, 80, 95, 20}
+
+	var locations []LocationData
+	for i, speed := range speeds {
+		lat, lon, speed := 52.5, 13.4+float64(i)*0.001, speed
+		loc := LocationData{
+			Timestamp: start.Add(time.Duration(i) * 10 * time.Second).Format(time.RFC3339),
+			Latitude:  &lat,
+			Longitude: &lon,
+			Speed:     &speed,
+			Signals:   map[string]float64{"powertrainFuelSystemRelativeLevel": float64(80 - i)},
+		}
+		if i == 1 {
+			// a fix without a speed, and a signal whose name needs quoting in CSV
+			loc.Speed = nil
+			loc.Signals[`note, "quoted"`] = 1
+		}
+		locations = append(locations, loc)
+	}
+
+	zones := []PrivacyZone{{ID: "home", Name: "Home", Center: &dimo.LatLon{Latitude: 52.5, Longitude: 13.403}, RadiusMeters: 30}}
+	cleaned, _ := cleanTrack(locations, zones)
+	return cleaned, maskLocation(&dimo.LatLon{Latitude: 52.4995, Longitude: 13.3995}, zones)
+}
+
+func exportTestPreferences() SpeedPreferences {
+	return SpeedPreferences{
+		Unit:      unitKmh,
+		Bands:     []SpeedBand{{Threshold: 30, Color: "blue"}, {Threshold: 60, Color: "#00AA00"}},
+		OverColor: "red",
+	}
+}
+
+func TestExportFormatsMatchGoldenFiles(t *testing.T) {
+	locations, estimatedStart := exportFixture()
+	prefs := exportTestPreferences()
+
+	for name, format := range exportFormats {
+		t.Run(name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := format.write(&out, exportTripID, locations, estimatedStart, prefs); err != nil {
+				t.Fatalf("write() error = %v", err)
+			}
+
+			golden := filepath.Join("testdata", "trip_export."+format.extension)
+			if *updateGolden {
+				if err := os.WriteFile(golden, out.Bytes(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("reading golden file (run with -update to create it): %v", err)
+			}
+			if !bytes.Equal(out.Bytes(), want) {
+				t.Errorf("%s export differs from %s:\n%s", name, golden, out.String())
+			}
+
+			// nothing from inside the privacy zone reaches the file
+			if strings.Contains(out.String(), "13.403") {
+				t.Errorf("%s export contains the fix hidden by the privacy zone", name)
+			}
+		})
+	}
+}
+
+func TestExportCSVInMiles(t *testing.T) {
+	locations, _ := exportFixture()
+	prefs := exportTestPreferences()
+	prefs.Unit = unitMph
+
+	var out bytes.Buffer
+	if err := writeCSV(&out, exportTripID, locations[:1], nil, prefs); err != nil {
+		t.Fatal(err)
+	}
+	want := "timestamp,latitude,longitude,speed_mph,powertrainFuelSystemRelativeLevel\n" +
+		"2024-05-01T08:00:00Z,52.5,13.4,7.456454306848007,80\n"
+	if out.String() != want {
+		t.Errorf("CSV in mph =\n%s\nwant\n%s", out.String(), want)
+	}
+}
@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"strconv"
+
+	"github.com/dimo-network/trips-web-app/api/internal/dimo"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// privilegeAllTimeLocation is the DIMO privilege needed to see where a vehicle has been.
+const privilegeAllTimeLocation int64 = 4
+
+var (
+	errTripNotFound = errors.New("trip not found")
+	errNoPrivilege  = errors.New("no location privilege on vehicle")
+)
+
+// rememberTrips records which vehicle each trip belongs to for the current session, so map requests for
+// trips the user has just been shown resolve without another Trips API round trip.
+func rememberTrips(c *fiber.Ctx, store SessionStore, tokenID int64, trips []dimo.Trip) {
+	sessionID, _, err := currentSession(c)
+	if err != nil {
+		return
+	}
+	value := strconv.FormatInt(tokenID, 10)
+	for _, trip := range trips {
+		if err := store.Set(c.UserContext(), tripVehiclePrefix(sessionID)+trip.ID, value, sessionTTL); err != nil {
+			log.Warn().Err(err).Str("tripId", trip.ID).Msg("Failed to remember trip vehicle")
+			return
+		}
+	}
+}
+
+// resolveTripVehicle returns the vehicle tripID belongs to. Trips this session has listed are found in the store;
+// otherwise, when the caller says which vehicle to look at, that vehicle's trips are fetched from the Trips API.
+// Either way the caller must own the vehicle or hold the location privilege on it.
+func resolveTripVehicle(c *fiber.Ctx, client *dimo.Client, store SessionStore, tripID string, hint int64) (int64, error) {
+	sessionID, session, err := currentSession(c)
+	if err != nil {
+		return 0, err
+	}
+
+	key := tripVehiclePrefix(sessionID) + tripID
+	raw, found, err := store.Get(c.UserContext(), key)
+	if err != nil {
+		return 0, errors.Wrap(err, "error reading trip lookup")
+	}
+	if found {
+		if tokenID, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			return tokenID, nil
+		}
+	}
+
+	if hint == 0 {
+		return 0, errTripNotFound
+	}
+
+	access, err := client.VehicleAccess(c.UserContext(), hint, session.EthereumAddress)
+	if err != nil {
+		return 0, err
+	}
+	if !access.Allows(session.EthereumAddress, privilegeAllTimeLocation) {
+		return 0, errNoPrivilege
+	}
+
+	trips, _, err := QueryTripsAPI(hint, client, store, c)
+	if err != nil {
+		return 0, err
+	}
+	for _, trip := range trips {
+		if trip.ID == tripID {
+			rememberTrips(c, store, hint, []dimo.Trip{trip})
+			return hint, nil
+		}
+	}
+
+	return 0, errTripNotFound
+}
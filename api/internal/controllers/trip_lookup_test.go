@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dimo-network/trips-web-app/api/internal/dimo"
+	"github.com/dimo-network/trips-web-app/api/internal/session"
+	"github.com/gofiber/fiber/v2"
+)
+
+const otherEthAddress = "0x0000000000000000000000000000000000000002"
+
+// resolveAs runs resolveTripVehicle for tripID on a request made in session sessionID.
+func resolveAs(t *testing.T, client *dimo.Client, store SessionStore, sessionID string, s *Session, tripID string, hint int64) (int64, error) {
+	t.Helper()
+	var (
+		tokenID int64
+		err     error
+	)
+	app := fiber.New()
+	app.Use(withTestSession(sessionID, s))
+	app.Get("/", func(c *fiber.Ctx) error {
+		tokenID, err = resolveTripVehicle(c, client, store, tripID, hint)
+		return nil
+	})
+	if _, testErr := app.Test(httptest.NewRequest(fiber.MethodGet, "/", nil), -1); testErr != nil {
+		t.Fatal(testErr)
+	}
+	return tokenID, err
+}
+
+func newTripLookupFixture(t *testing.T) (*fakeDIMO, *dimo.Client) {
+	upstream := newFakeDIMO(t)
+	newest := time.Date(2024, 5, 1, 18, 0, 0, 0, time.UTC)
+	upstream.tripPages[7] = [][]dimo.Trip{testTrips("mine", newest, 2)}
+	upstream.tripPages[8] = [][]dimo.Trip{testTrips("theirs", newest, 2)}
+	upstream.access[7] = dimo.VehicleAccess{Owner: testEthAddress}
+	upstream.access[8] = dimo.VehicleAccess{Owner: otherEthAddress}
+
+	settings := upstream.settings()
+	return upstream, dimo.NewClient(&settings)
+}
+
+func TestResolveTripVehicle(t *testing.T) {
+	me := &Session{EthereumAddress: testEthAddress, IDToken: "id-token"}
+
+	t.Run("trip on the hinted vehicle", func(t *testing.T) {
+		_, client := newTripLookupFixture(t)
+		tokenID, err := resolveAs(t, client, session.NewMemoryStore(), "session-1", me, "mine-1", 7)
+		if err != nil || tokenID != 7 {
+			t.Errorf("resolveTripVehicle() = %d, %v; want 7", tokenID, err)
+		}
+	})
+
+	t.Run("trip belonging to another vehicle", func(t *testing.T) {
+		_, client := newTripLookupFixture(t)
+		// the caller may see vehicle 7, but asks through it for a trip that is vehicle 8's
+		_, err := resolveAs(t, client, session.NewMemoryStore(), "session-1", me, "theirs-0", 7)
+		if !errors.Is(err, errTripNotFound) {
+			t.Errorf("resolveTripVehicle() error = %v, want errTripNotFound", err)
+		}
+	})
+
+	t.Run("hint without privilege", func(t *testing.T) {
+		upstream, client := newTripLookupFixture(t)
+		_, err := resolveAs(t, client, session.NewMemoryStore(), "session-1", me, "theirs-0", 8)
+		if !errors.Is(err, errNoPrivilege) {
+			t.Errorf("resolveTripVehicle() error = %v, want errNoPrivilege", err)
+		}
+		if got := upstream.callCount("trips"); got != 0 {
+			t.Errorf("Trips API called %d times for a vehicle the caller can't see", got)
+		}
+	})
+
+	t.Run("hint with an expired grant", func(t *testing.T) {
+		upstream, client := newTripLookupFixture(t)
+		upstream.access[8] = dimo.VehicleAccess{
+			Owner:      otherEthAddress,
+			Privileges: []dimo.Privilege{{ID: privilegeAllTimeLocation, ExpiresAt: time.Now().Add(-time.Hour)}},
+		}
+		_, err := resolveAs(t, client, session.NewMemoryStore(), "session-1", me, "theirs-0", 8)
+		if !errors.Is(err, errNoPrivilege) {
+			t.Errorf("resolveTripVehicle() error = %v, want errNoPrivilege", err)
+		}
+	})
+
+	t.Run("hint with a location grant", func(t *testing.T) {
+		upstream, client := newTripLookupFixture(t)
+		upstream.access[8] = dimo.VehicleAccess{
+			Owner:      otherEthAddress,
+			Privileges: []dimo.Privilege{{ID: privilegeAllTimeLocation, ExpiresAt: time.Now().Add(time.Hour)}},
+		}
+		tokenID, err := resolveAs(t, client, session.NewMemoryStore(), "session-1", me, "theirs-0", 8)
+		if err != nil || tokenID != 8 {
+			t.Errorf("resolveTripVehicle() = %d, %v; want 8", tokenID, err)
+		}
+	})
+
+	t.Run("no hint and nothing remembered", func(t *testing.T) {
+		_, client := newTripLookupFixture(t)
+		_, err := resolveAs(t, client, session.NewMemoryStore(), "session-1", me, "mine-0", 0)
+		if !errors.Is(err, errTripNotFound) {
+			t.Errorf("resolveTripVehicle() error = %v, want errTripNotFound", err)
+		}
+	})
+
+	t.Run("cache hit only within the session", func(t *testing.T) {
+		upstream, client := newTripLookupFixture(t)
+		store := session.NewMemoryStore()
+
+		if _, err := resolveAs(t, client, store, "session-1", me, "mine-0", 7); err != nil {
+			t.Fatal(err)
+		}
+		identityCalls := upstream.callCount("identity")
+
+		// the same session finds it again without asking the Identity API
+		tokenID, err := resolveAs(t, client, store, "session-1", me, "mine-0", 0)
+		if err != nil || tokenID != 7 {
+			t.Errorf("remembered trip = %d, %v; want 7", tokenID, err)
+		}
+		if got := upstream.callCount("identity"); got != identityCalls {
+			t.Errorf("Identity API called again for a remembered trip")
+		}
+
+		// a new session, such as after logging in again as someone else, starts from nothing
+		someoneElse := &Session{EthereumAddress: otherEthAddress, IDToken: "other-id-token"}
+		if _, err := resolveAs(t, client, store, "session-2", someoneElse, "mine-0", 0); !errors.Is(err, errTripNotFound) {
+			t.Errorf("other session resolved a trip it never listed: error = %v, want errTripNotFound", err)
+		}
+		if _, err := resolveAs(t, client, store, "session-2", someoneElse, "mine-0", 7); !errors.Is(err, errNoPrivilege) {
+			t.Errorf("other session hinting at the vehicle: error = %v, want errNoPrivilege", err)
+		}
+	})
+
+	t.Run("remembered value that isn't a vehicle", func(t *testing.T) {
+		_, client := newTripLookupFixture(t)
+		store := session.NewMemoryStore()
+		if err := store.Set(context.Background(), tripVehiclePrefix("session-1")+"mine-0", "not-a-number", time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := resolveAs(t, client, store, "session-1", me, "mine-0", 0); !errors.Is(err, errTripNotFound) {
+			t.Errorf("resolveTripVehicle() error = %v, want errTripNotFound", err)
+		}
+		// with a hint it is checked and looked up afresh
+		if tokenID, err := resolveAs(t, client, store, "session-1", me, "mine-0", 7); err != nil || tokenID != 7 {
+			t.Errorf("resolveTripVehicle() = %d, %v; want 7", tokenID, err)
+		}
+		if raw, _, _ := store.Get(context.Background(), tripVehiclePrefix("session-1")+"mine-0"); raw != strconv.Itoa(7) {
+			t.Errorf("remembered vehicle = %q, want 7", raw)
+		}
+	})
+}
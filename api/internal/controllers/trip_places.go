@@ -0,0 +1,259 @@
+package controllers
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/dimo-network/trips-web-app/api/internal/dimo"
+	"github.com/dimo-network/trips-web-app/api/internal/geocode"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// placePrecision rounds positions to three decimals, about 100 m, before they are named. That is close enough
+	// for a street, lets nearby trip ends share a cache entry, and keeps exact fixes from the geocoding service.
+	placePrecision = 1000.0
+	// placeLookupBudget caps how long a request waits on names that aren't cached yet. Whatever isn't named in
+	// time is left blank, and looked up again on a later load.
+	placeLookupBudget = 3 * time.Second
+	placeWorkers      = 4
+	// maxRecentPlaces bounds the in-process cache in front of the store; the least recently used names are dropped
+	// past it.
+	maxRecentPlaces = 10000
+)
+
+// TripPlaces are the short names of where a trip started and ended. They are empty where the place is unknown,
+// hidden by a privacy zone, or geocoding isn't configured.
+type TripPlaces struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+	// Pending is set when a name was left empty because it hasn't been looked up yet.
+	Pending bool `json:"-"`
+}
+
+// placePosition is a rounded position to be named.
+type placePosition struct {
+	latitude  float64
+	longitude float64
+}
+
+// placeKey is where a position's name is kept in the store. Place names aren't tied to anyone, so they are shared
+// by every user and never expire; places rarely change name.
+func placeKey(position placePosition) string {
+	return fmt.Sprintf("place_%.3f_%.3f", position.latitude, position.longitude)
+}
+
+// placeCache keeps place names by rounded position. A durable store is the source of truth, so names survive
+// restarts and are shared by every replica, which keeps lookups off the rate-limited geocoder. A bounded LRU of
+// recent names sits in front of it to save the round trip. Without a durable store only the LRU is kept, so an
+// in-memory store doesn't grow by a name for every place ever visited.
+type placeCache struct {
+	// store is nil when the session store isn't durable.
+	store  SessionStore
+	recent *placeLRU
+}
+
+func newPlaceCache(store SessionStore, capacity int) *placeCache {
+	cache := &placeCache{recent: newPlaceLRU(capacity)}
+	if store != nil && store.Durable() {
+		cache.store = store
+	}
+	return cache
+}
+
+func (p *placeCache) get(ctx context.Context, position placePosition) (string, bool) {
+	if name, found := p.recent.get(position); found {
+		return name, true
+	}
+	if p.store == nil {
+		return "", false
+	}
+
+	name, found, err := p.store.Get(ctx, placeKey(position))
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to read cached place name")
+		return "", false
+	}
+	if found {
+		p.recent.set(position, name)
+	}
+	return name, found
+}
+
+func (p *placeCache) set(ctx context.Context, position placePosition, name string) {
+	p.recent.set(position, name)
+	if p.store == nil {
+		return
+	}
+	if err := p.store.Set(ctx, placeKey(position), name, 0); err != nil {
+		log.Warn().Err(err).Msg("Failed to cache place name")
+	}
+}
+
+// placeLRU is a least recently used cache of place names, kept in process memory.
+type placeLRU struct {
+	capacity int
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[placePosition]*list.Element
+}
+
+type placeLRUEntry struct {
+	position placePosition
+	name     string
+}
+
+func newPlaceLRU(capacity int) *placeLRU {
+	return &placeLRU{capacity: capacity, order: list.New(), entries: make(map[placePosition]*list.Element)}
+}
+
+func (p *placeLRU) get(position placePosition) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	element, found := p.entries[position]
+	if !found {
+		return "", false
+	}
+	p.order.MoveToFront(element)
+	return element.Value.(*placeLRUEntry).name, true
+}
+
+func (p *placeLRU) set(position placePosition, name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if element, found := p.entries[position]; found {
+		element.Value.(*placeLRUEntry).name = name
+		p.order.MoveToFront(element)
+		return
+	}
+	p.entries[position] = p.order.PushFront(&placeLRUEntry{position: position, name: name})
+	if p.order.Len() > p.capacity {
+		oldest := p.order.Back()
+		p.order.Remove(oldest)
+		delete(p.entries, oldest.Value.(*placeLRUEntry).position)
+	}
+}
+
+// tripPointPosition is the rounded position to name a trip point by: its location, or the estimated one when the
+// location is missing or was blanked by hideTripEnds.
+func tripPointPosition(point dimo.TripPoint) (placePosition, bool) {
+	location := &point.Location
+	if !validPosition(location.Latitude, location.Longitude) {
+		location = point.EstimatedLocation
+	}
+	if location == nil || !validPosition(location.Latitude, location.Longitude) {
+		return placePosition{}, false
+	}
+	return placePosition{
+		latitude:  math.Round(location.Latitude*placePrecision) / placePrecision,
+		longitude: math.Round(location.Longitude*placePrecision) / placePrecision,
+	}, true
+}
+
+// resolveTripPlaces names the start and end of each trip from the cache. With lookup set, names that aren't cached
+// are looked up with geocoder within placeLookupBudget; otherwise they are left empty and the trip marked Pending,
+// so a page can be shown without waiting on the geocoder. A nil geocoder leaves every name empty.
+func resolveTripPlaces(ctx context.Context, geocoder geocode.Provider, cache *placeCache, trips []dimo.Trip, lookup bool) []TripPlaces {
+	places := make([]TripPlaces, len(trips))
+	if geocoder == nil {
+		return places
+	}
+
+	names := make(map[placePosition]string)
+	var missing []placePosition
+	for _, trip := range trips {
+		for _, point := range []dimo.TripPoint{trip.Start, trip.End} {
+			position, ok := tripPointPosition(point)
+			if !ok {
+				continue
+			}
+			if _, seen := names[position]; seen {
+				continue
+			}
+			names[position] = ""
+
+			if name, found := cache.get(ctx, position); found {
+				names[position] = name
+			} else {
+				missing = append(missing, position)
+			}
+		}
+	}
+
+	pending := make(map[placePosition]bool, len(missing))
+	for _, position := range missing {
+		pending[position] = true
+	}
+	if lookup {
+		for position, name := range lookupPlaces(ctx, geocoder, cache, missing) {
+			names[position] = name
+			delete(pending, position)
+		}
+	}
+
+	for i, trip := range trips {
+		if position, ok := tripPointPosition(trip.Start); ok {
+			places[i].Start = names[position]
+			places[i].Pending = pending[position]
+		}
+		if position, ok := tripPointPosition(trip.End); ok {
+			places[i].End = names[position]
+			places[i].Pending = places[i].Pending || pending[position]
+		}
+	}
+	return places
+}
+
+// lookupPlaces names positions on placeWorkers goroutines and caches every answer, including "nothing here".
+// Failed lookups are left out, so they are retried next time.
+func lookupPlaces(ctx context.Context, geocoder geocode.Provider, cache *placeCache, positions []placePosition) map[placePosition]string {
+	lookupCtx, cancel := context.WithTimeout(ctx, placeLookupBudget)
+	defer cancel()
+
+	var (
+		mu    sync.Mutex
+		names = make(map[placePosition]string, len(positions))
+		wg    sync.WaitGroup
+	)
+	jobs := make(chan placePosition)
+	for i := 0; i < placeWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for position := range jobs {
+				name, err := geocoder.Reverse(lookupCtx, position.latitude, position.longitude)
+				if err != nil {
+					// running out of budget isn't worth a warning; the name is looked up next time
+					if lookupCtx.Err() == nil {
+						log.Warn().Err(err).Msg("Failed to look up place name")
+					}
+					continue
+				}
+				cache.set(ctx, position, name)
+				mu.Lock()
+				names[position] = name
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, position := range positions {
+		select {
+		case jobs <- position:
+		case <-lookupCtx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return names
+}
@@ -0,0 +1,166 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dimo-network/trips-web-app/api/internal/dimo"
+	"github.com/dimo-network/trips-web-app/api/internal/session"
+	"github.com/gofiber/fiber/v2"
+)
+
+// countingGeocoder names a position after its coordinates and counts how often it was asked.
+type countingGeocoder struct {
+	calls int32
+}
+
+func (g *countingGeocoder) Reverse(_ context.Context, latitude, longitude float64) (string, error) {
+	atomic.AddInt32(&g.calls, 1)
+	return fmt.Sprintf("%.3f,%.3f", latitude, longitude), nil
+}
+
+func (g *countingGeocoder) callCount() int {
+	return int(atomic.LoadInt32(&g.calls))
+}
+
+// placedTrips are testTrips starting and ending at distinct positions.
+func placedTrips(n int) []dimo.Trip {
+	trips := testTrips("trip", time.Date(2024, 5, 1, 18, 0, 0, 0, time.UTC), n)
+	for i := range trips {
+		trips[i].Start.Location = dimo.LatLon{Latitude: 52.5 + float64(i)*0.01, Longitude: 13.4}
+		trips[i].End.Location = dimo.LatLon{Latitude: 52.5 + float64(i)*0.01, Longitude: 13.5}
+	}
+	return trips
+}
+
+func TestPlaceLRUDropsLeastRecentlyUsed(t *testing.T) {
+	cache := newPlaceLRU(2)
+	home, work, shop := placePosition{52.5, 13.4}, placePosition{52.52, 13.41}, placePosition{52.53, 13.42}
+
+	cache.set(home, "Home Street")
+	cache.set(work, "Work Avenue")
+	// using home makes work the least recently used
+	if name, found := cache.get(home); !found || name != "Home Street" {
+		t.Fatalf("get(home) = %q, %v, want Home Street, true", name, found)
+	}
+	cache.set(shop, "")
+
+	if _, found := cache.get(work); found {
+		t.Error("work is still cached past the capacity")
+	}
+	if _, found := cache.get(home); !found {
+		t.Error("home was dropped although it was used more recently")
+	}
+	// "nothing here" is an answer worth keeping too
+	if name, found := cache.get(shop); !found || name != "" {
+		t.Errorf("get(shop) = %q, %v, want an empty name, true", name, found)
+	}
+	if got := len(cache.entries); got != 2 {
+		t.Errorf("cache holds %d entries, want 2", got)
+	}
+}
+
+func TestPlaceNamesOutliveTheProcessInADurableStore(t *testing.T) {
+	store := session.NewRedisStore(session.RedisOptions{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { _ = store.Close() })
+	trips := placedTrips(3)
+
+	geocoder := &countingGeocoder{}
+	first := resolveTripPlaces(context.Background(), geocoder, newPlaceCache(store, maxRecentPlaces), trips, true)
+	if got := geocoder.callCount(); got != 6 {
+		t.Fatalf("geocoder called %d times, want 6", got)
+	}
+	if first[1].Start != "52.510,13.400" || first[1].End != "52.510,13.500" {
+		t.Errorf("trip 1 places = %+v", first[1])
+	}
+
+	// a restarted process, or another replica, starts with an empty LRU but the same store
+	again := &countingGeocoder{}
+	second := resolveTripPlaces(context.Background(), again, newPlaceCache(store, 1), trips, true)
+	if got := again.callCount(); got != 0 {
+		t.Errorf("geocoder called %d times after a restart, want 0", got)
+	}
+	for i := range trips {
+		if second[i] != first[i] {
+			t.Errorf("trip %d places = %+v after a restart, want %+v", i, second[i], first[i])
+		}
+	}
+	if _, found, _ := store.Get(context.Background(), placeKey(placePosition{52.5, 13.4})); !found {
+		t.Error("place name wasn't kept in the store")
+	}
+}
+
+func TestPlaceNamesStayInMemoryWithoutADurableStore(t *testing.T) {
+	store := session.NewMemoryStore()
+	cache := newPlaceCache(store, maxRecentPlaces)
+	geocoder := &countingGeocoder{}
+
+	resolveTripPlaces(context.Background(), geocoder, cache, placedTrips(2), true)
+	resolveTripPlaces(context.Background(), geocoder, cache, placedTrips(2), true)
+
+	if got := geocoder.callCount(); got != 4 {
+		t.Errorf("geocoder called %d times, want 4", got)
+	}
+	if keys, _ := store.Keys(context.Background(), "place_"); len(keys) != 0 {
+		t.Errorf("place names were written to the in-memory store: %v", keys)
+	}
+}
+
+func TestTripPlacesAreFilledInAfterThePage(t *testing.T) {
+	upstream := newFakeDIMO(t)
+	trips := placedTrips(2)
+	upstream.tripPages[7] = [][]dimo.Trip{trips}
+
+	settings := upstream.settings()
+	store := session.NewRedisStore(session.RedisOptions{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { _ = store.Close() })
+	geocoder := &countingGeocoder{}
+	tc := NewTripsController(settings, dimo.NewClient(&settings), store, geocoder)
+
+	// the page itself only shows what is cached, and leaves the rest pending
+	pending := resolveTripPlaces(context.Background(), geocoder, tc.places, trips, false)
+	if got := geocoder.callCount(); got != 0 {
+		t.Fatalf("geocoder called %d times for the page, want 0", got)
+	}
+	for i, places := range pending {
+		if !places.Pending || places.Start != "" || places.End != "" {
+			t.Errorf("trip %d places = %+v, want empty and pending", i, places)
+		}
+	}
+
+	app := fiber.New()
+	app.Use(withTestSession("session-1", &Session{EthereumAddress: testEthAddress, IDToken: "id-token"}))
+	app.Get("/vehicles/:tokenid/trips/places", tc.HandleTripPlaces)
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/vehicles/7/trips/places?page=1&limit=2", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, fiber.StatusOK)
+	}
+	var body struct {
+		Places map[string]TripPlaces `json:"places"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if got := body.Places["trip-1"]; got.Start != "52.510,13.400" || got.End != "52.510,13.500" {
+		t.Errorf("trip-1 places = %+v", got)
+	}
+
+	// and the next page load has them all
+	for i, places := range resolveTripPlaces(context.Background(), geocoder, tc.places, trips, false) {
+		if places.Pending || places.Start == "" || places.End == "" {
+			t.Errorf("trip %d places = %+v on the next load, want both named", i, places)
+		}
+	}
+	if got := geocoder.callCount(); got != 4 {
+		t.Errorf("geocoder called %d times, want 4", got)
+	}
+}
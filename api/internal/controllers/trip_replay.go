@@ -0,0 +1,192 @@
+package controllers
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/dimo-network/trips-web-app/api/internal/config"
+	"github.com/dimo-network/trips-web-app/api/internal/dimo"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// maxReplayFrames bounds a replay's size; the default step grows with the trip to stay under it.
+	maxReplayFrames = 3600
+	minReplayStep   = time.Second
+	maxReplayStep   = 10 * time.Minute
+	// stationaryMeters is how far apart two fixes must be to give a heading; closer than this the vehicle is
+	// taken to be standing still and keeps the heading it had.
+	stationaryMeters = 2.0
+)
+
+// ReplayFrame is where the vehicle was at one step of a replay. Latitude and Longitude are left out while the
+// track has a gap, such as lost signal or a privacy zone, and Speed and Heading when they aren't known. Speed is
+// in the user's unit and Heading in degrees clockwise from north.
+type ReplayFrame struct {
+	Time      string   `json:"time"`
+	Offset    float64  `json:"offsetSeconds"`
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+	Speed     *float64 `json:"speed,omitempty"`
+	Heading   *float64 `json:"heading,omitempty"`
+}
+
+// HandleTripReplay answers /api/trip/:tripID/replay with the trip's cleaned track resampled to a fixed step, for
+// playing it back on the map. It takes the same tokenId, start, end and resolution params as /api/trip/:tripID,
+// and an optional step duration such as 5s; by default the step is a second, or longer to keep the replay to
+// maxReplayFrames.
+func HandleTripReplay(settings *config.Settings, client *dimo.Client, store SessionStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tripID := c.Params("tripID")
+
+		var step time.Duration
+		if raw := c.Query("step"); raw != "" {
+			parsed, err := time.ParseDuration(raw)
+			if err != nil || parsed < minReplayStep || parsed > maxReplayStep {
+				return tripError(c, tripID, fiber.NewError(fiber.StatusBadRequest, "step must be a duration between 1s and 10m, such as 5s"))
+			}
+			step = parsed.Truncate(time.Second)
+		}
+
+		zones, err := userPrivacyZones(c, store)
+		if err != nil {
+			return tripError(c, tripID, err)
+		}
+		prefs, err := speedPreferences(c, settings, store)
+		if err != nil {
+			return tripError(c, tripID, err)
+		}
+
+		locations, _, err := tripLocations(c, client, store, tripID, c.Query("start"), c.Query("end"))
+		if err != nil {
+			return tripError(c, tripID, err)
+		}
+		locations, _ = cleanTrack(locations, zones)
+
+		fixes := timedFixes(locations)
+		if len(fixes) > 0 {
+			duration := fixes[len(fixes)-1].at.Sub(fixes[0].at)
+			if step == 0 {
+				step = replayStep(duration)
+			} else if duration/step >= maxReplayFrames {
+				return tripError(c, tripID, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("step is too short for this trip; it would take more than %d frames", maxReplayFrames)))
+			}
+		}
+		if step == 0 {
+			step = minReplayStep
+		}
+
+		return c.JSON(fiber.Map{
+			"stepSeconds": step.Seconds(),
+			"frames":      replayFrames(fixes, step, prefs),
+			"units":       prefs.units(),
+		})
+	}
+}
+
+// replayStep is the default step for a trip of the given length: whole seconds, at least one, and long enough to
+// keep the replay to maxReplayFrames.
+func replayStep(duration time.Duration) time.Duration {
+	step := minReplayStep
+	if needed := duration / (maxReplayFrames - 1); needed > step {
+		step = needed.Truncate(time.Second) + time.Second
+	}
+	return step
+}
+
+// timedFix is a cleaned fix with its timestamp parsed.
+type timedFix struct {
+	LocationData
+	at time.Time
+}
+
+// timedFixes keeps the fixes with a usable timestamp, in time order, dropping any that go back in time.
+func timedFixes(locations []LocationData) []timedFix {
+	fixes := make([]timedFix, 0, len(locations))
+	for _, loc := range locations {
+		at, err := time.Parse(time.RFC3339, loc.Timestamp)
+		if err != nil || (len(fixes) > 0 && at.Before(fixes[len(fixes)-1].at)) {
+			continue
+		}
+		fixes = append(fixes, timedFix{LocationData: loc, at: at})
+	}
+	return fixes
+}
+
+// replayFrames samples the track every step from its first fix to its last. Positions and speeds are interpolated
+// linearly between the fixes either side of a frame, but never across segments, so nothing is drawn through a gap.
+// The heading is the direction of travel between those fixes.
+func replayFrames(fixes []timedFix, step time.Duration, prefs SpeedPreferences) []ReplayFrame {
+	frames := []ReplayFrame{}
+	if len(fixes) == 0 {
+		return frames
+	}
+
+	var (
+		start   = fixes[0].at
+		end     = fixes[len(fixes)-1].at
+		next    = 1
+		heading *float64
+	)
+	for offset := time.Duration(0); offset <= end.Sub(start); offset += step {
+		at := start.Add(offset)
+		frame := ReplayFrame{Time: at.Format(time.RFC3339), Offset: offset.Seconds()}
+
+		for next < len(fixes)-1 && fixes[next].at.Before(at) {
+			next++
+		}
+		if len(fixes) == 1 {
+			frame.Latitude, frame.Longitude, frame.Speed = fixes[0].Latitude, fixes[0].Longitude, replaySpeed(fixes[0].Speed, prefs)
+			frames = append(frames, frame)
+			continue
+		}
+		from, to := fixes[next-1], fixes[next]
+		if from.Segment != to.Segment {
+			// a gap; the heading starts over on the far side
+			heading = nil
+			if !at.Equal(to.at) {
+				frames = append(frames, frame)
+				continue
+			}
+			// the frame falls right on the first fix past the gap
+			from = to
+		}
+
+		fraction := 0.0
+		if span := to.at.Sub(from.at); span > 0 {
+			fraction = math.Min(1, float64(at.Sub(from.at))/float64(span))
+		}
+		latitude := *from.Latitude + (*to.Latitude-*from.Latitude)*fraction
+		longitude := *from.Longitude + (*to.Longitude-*from.Longitude)*fraction
+		frame.Latitude, frame.Longitude = &latitude, &longitude
+
+		switch {
+		case from.Speed != nil && to.Speed != nil:
+			speed := *from.Speed + (*to.Speed-*from.Speed)*fraction
+			frame.Speed = replaySpeed(&speed, prefs)
+		case from.Speed != nil:
+			frame.Speed = replaySpeed(from.Speed, prefs)
+		default:
+			frame.Speed = replaySpeed(to.Speed, prefs)
+		}
+
+		if haversineKm(*from.Latitude, *from.Longitude, *to.Latitude, *to.Longitude)*1000 >= stationaryMeters {
+			bearing := initialBearing(*from.Latitude, *from.Longitude, *to.Latitude, *to.Longitude)
+			heading = &bearing
+		}
+		frame.Heading = heading
+
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+// replaySpeed converts a speed in km/h to the user's unit, keeping nil for an unknown speed.
+func replaySpeed(kmh *float64, prefs SpeedPreferences) *float64 {
+	if kmh == nil {
+		return nil
+	}
+	speed := prefs.speed(*kmh)
+	return &speed
+}
@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+var replayStart = time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+
+// replayFix is a fix seconds into the trip, in segment 0.
+func replayFix(seconds int, lat, lon, speed float64) LocationData {
+	return LocationData{
+		Timestamp: replayStart.Add(time.Duration(seconds) * time.Second).Format(time.RFC3339),
+		Latitude:  &lat,
+		Longitude: &lon,
+		Speed:     &speed,
+	}
+}
+
+func replayPrefs(unit string) SpeedPreferences {
+	return SpeedPreferences{Unit: unit, Bands: []SpeedBand{{Threshold: 50, Color: "green"}}, OverColor: "red"}
+}
+
+func approx(got *float64, want float64) bool {
+	return got != nil && math.Abs(*got-want) < 1e-6
+}
+
+func TestReplayFramesWithoutFixes(t *testing.T) {
+	frames := replayFrames(timedFixes(nil), time.Second, replayPrefs(unitKmh))
+
+	// an empty list rather than null, so clients can loop over it
+	raw, err := json.Marshal(frames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != "[]" {
+		t.Errorf("frames = %s, want []", raw)
+	}
+}
+
+func TestReplayFramesWithOneFix(t *testing.T) {
+	fixes := timedFixes([]LocationData{replayFix(0, 52.5, 13.4, 80.4672)})
+	frames := replayFrames(fixes, time.Second, replayPrefs(unitMph))
+
+	if len(frames) != 1 {
+		t.Fatalf("got %d frames, want 1", len(frames))
+	}
+	frame := frames[0]
+	if frame.Offset != 0 || frame.Time != fixes[0].Timestamp {
+		t.Errorf("frame at %gs, %s; want 0s, %s", frame.Offset, frame.Time, fixes[0].Timestamp)
+	}
+	if !approx(frame.Latitude, 52.5) || !approx(frame.Longitude, 13.4) {
+		t.Errorf("frame at %v, %v; want 52.5, 13.4", frame.Latitude, frame.Longitude)
+	}
+	if !approx(frame.Speed, 50) {
+		t.Errorf("speed = %v, want 50 mph", frame.Speed)
+	}
+	if frame.Heading != nil {
+		t.Errorf("heading = %g, want none from a single fix", *frame.Heading)
+	}
+}
+
+func TestReplayFramesInterpolateUnevenlySpacedFixes(t *testing.T) {
+	fixes := timedFixes([]LocationData{
+		replayFix(0, 52.500, 13.4, 0),
+		replayFix(2, 52.502, 13.4, 20),
+		replayFix(10, 52.510, 13.4, 60),
+	})
+	frames := replayFrames(fixes, time.Second, replayPrefs(unitKmh))
+
+	if len(frames) != 11 {
+		t.Fatalf("got %d frames, want one a second for 10s", len(frames))
+	}
+	for _, tc := range []struct {
+		frame         int
+		latitude      float64
+		speed         float64
+		wantTimestamp string
+	}{
+		{0, 52.500, 0, fixes[0].Timestamp},
+		{1, 52.501, 10, ""},
+		{2, 52.502, 20, fixes[1].Timestamp},
+		// halfway through the longer leg
+		{6, 52.506, 40, ""},
+		{10, 52.510, 60, fixes[2].Timestamp},
+	} {
+		frame := frames[tc.frame]
+		if frame.Offset != float64(tc.frame) {
+			t.Errorf("frame %d is at %gs", tc.frame, frame.Offset)
+		}
+		if tc.wantTimestamp != "" && frame.Time != tc.wantTimestamp {
+			t.Errorf("frame %d is at %s, want %s", tc.frame, frame.Time, tc.wantTimestamp)
+		}
+		if !approx(frame.Latitude, tc.latitude) || !approx(frame.Longitude, 13.4) {
+			t.Errorf("frame %d at %v, %v; want %g, 13.4", tc.frame, *frame.Latitude, *frame.Longitude, tc.latitude)
+		}
+		if !approx(frame.Speed, tc.speed) {
+			t.Errorf("frame %d speed = %v, want %g", tc.frame, *frame.Speed, tc.speed)
+		}
+		if !approx(frame.Heading, 0) {
+			t.Errorf("frame %d heading = %v, want due north", tc.frame, frame.Heading)
+		}
+	}
+
+	// a step that doesn't divide the trip stops at the last frame before the end
+	if frames := replayFrames(fixes, 3*time.Second, replayPrefs(unitKmh)); len(frames) != 4 || frames[3].Offset != 9 {
+		t.Errorf("3s steps gave %d frames, want 4 ending at 9s", len(frames))
+	}
+}
+
+func TestReplayFramesLeaveGapsEmpty(t *testing.T) {
+	// fixes every 5s, then nothing for a minute, far longer than gapIntervals of them
+	var locations []LocationData
+	for _, seconds := range []int{0, 5, 10, 15, 20, 80, 85, 90} {
+		locations = append(locations, replayFix(seconds, 52.5+float64(seconds)*0.0001, 13.4, 30))
+	}
+	locations, report := cleanTrack(locations, nil)
+	if report.Segments != 2 {
+		t.Fatalf("cleaning found %d segments, want 2", report.Segments)
+	}
+
+	frames := replayFrames(timedFixes(locations), 5*time.Second, replayPrefs(unitKmh))
+	if len(frames) != 19 {
+		t.Fatalf("got %d frames, want 19", len(frames))
+	}
+	for _, frame := range frames {
+		inGap := frame.Offset > 20 && frame.Offset < 80
+		if inGap != (frame.Latitude == nil) || inGap != (frame.Longitude == nil) {
+			t.Errorf("frame at %gs has position %v, %v", frame.Offset, frame.Latitude, frame.Longitude)
+		}
+		if inGap && (frame.Speed != nil || frame.Heading != nil) {
+			t.Errorf("frame at %gs in the gap has a speed or heading", frame.Offset)
+		}
+	}
+
+	// the first fix past the gap is shown where it is, with no heading carried over from before the gap
+	past := frames[16]
+	if past.Offset != 80 || !approx(past.Latitude, 52.508) {
+		t.Errorf("frame at %gs is at %v, want 80s at 52.508", past.Offset, past.Latitude)
+	}
+	if past.Heading != nil {
+		t.Errorf("frame at 80s has heading %g, want none", *past.Heading)
+	}
+	if !approx(frames[17].Heading, 0) {
+		t.Errorf("frame at 85s heading = %v, want due north", frames[17].Heading)
+	}
+}
+
+func TestReplayFramesHeadingAcrossNorth(t *testing.T) {
+	fixes := timedFixes([]LocationData{
+		replayFix(0, 52.50, 13.400, 30),
+		// a little west of north, then a little east of it
+		replayFix(10, 52.51, 13.399, 30),
+		replayFix(20, 52.52, 13.400, 30),
+		// standing still keeps the last heading
+		replayFix(30, 52.52, 13.400, 0),
+	})
+	frames := replayFrames(fixes, 5*time.Second, replayPrefs(unitKmh))
+
+	for _, tc := range []struct {
+		offset   int
+		min, max float64
+	}{
+		{5, 356, 357},
+		{10, 356, 357},
+		{15, 3, 4},
+		{25, 3, 4},
+		{30, 3, 4},
+	} {
+		heading := frames[tc.offset/5].Heading
+		if heading == nil || *heading < tc.min || *heading > tc.max {
+			t.Errorf("heading at %ds = %v, want between %g and %g", tc.offset, heading, tc.min, tc.max)
+		}
+	}
+	for _, frame := range frames {
+		if frame.Heading != nil && (*frame.Heading < 0 || *frame.Heading >= 360) {
+			t.Errorf("heading at %gs = %g, outside [0, 360)", frame.Offset, *frame.Heading)
+		}
+	}
+}
+
+func TestReplayStep(t *testing.T) {
+	for _, tc := range []struct {
+		duration time.Duration
+		want     time.Duration
+	}{
+		{0, time.Second},
+		{30 * time.Minute, time.Second},
+		{time.Hour, 2 * time.Second},
+		{10 * time.Hour, 11 * time.Second},
+	} {
+		step := replayStep(tc.duration)
+		if step != tc.want {
+			t.Errorf("replayStep(%s) = %s, want %s", tc.duration, step, tc.want)
+		}
+		if frames := tc.duration/step + 1; frames > maxReplayFrames {
+			t.Errorf("replayStep(%s) gives %d frames, more than %d", tc.duration, frames, maxReplayFrames)
+		}
+	}
+}
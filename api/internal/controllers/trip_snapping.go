@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dimo-network/trips-web-app/api/internal/dimo"
+	"github.com/dimo-network/trips-web-app/api/internal/mapmatch"
+	"github.com/gofiber/fiber/v2"
+	geojson "github.com/paulmach/go.geojson"
+	"github.com/rs/zerolog/log"
+)
+
+// snappedTripTTL is how long a trip's snapped geometry is kept. Finished trips don't change, so it can be long.
+const snappedTripTTL = 24 * time.Hour
+
+// HandleSnappedTrip returns a trip's track snapped to the road network, as a GeoJSON feature collection with a
+// LineString per matched stretch. It takes the same tokenId, start, end and resolution params as /api/trip/:tripID,
+// and snaps the same cleaned track, with the user's privacy zones hidden.
+// Results are cached per trip and shared between sessions, but only handed out after the caller's access to the
+// trip has been checked.
+func HandleSnappedTrip(client *dimo.Client, matcher *mapmatch.Client, store SessionStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if matcher == nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "Road snapping is not configured"})
+		}
+		tripID := c.Params("tripID")
+
+		query, err := tripTelemetryQuery(c, client, store, tripID, c.Query("start"), c.Query("end"))
+		if err != nil {
+			return tripError(c, tripID, err)
+		}
+		// snapping only needs positions
+		query.Extra = nil
+
+		zones, err := userPrivacyZones(c, store)
+		if err != nil {
+			return tripError(c, tripID, err)
+		}
+
+		cacheKey := snappedTripKey(tripID, query, zones)
+		if cached, found, err := store.Get(c.UserContext(), cacheKey); err != nil {
+			log.Warn().Err(err).Msg("Failed to read cached snapped trip")
+		} else if found {
+			c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			return c.SendString(cached)
+		}
+
+		locations, err := queryTelemetryData(query, client, store, c)
+		if err != nil {
+			return tripError(c, tripID, err)
+		}
+
+		locations, _ = cleanTrack(locations, zones)
+
+		// each segment is matched on its own; nothing is known about the roads taken across a gap
+		var matchings []mapmatch.Matching
+		for _, segment := range trackSegments(locations) {
+			points := make([]mapmatch.Point, len(segment))
+			for i, loc := range segment {
+				points[i] = mapmatch.Point{Longitude: *loc.Longitude, Latitude: *loc.Latitude}
+				points[i].Time, _ = time.Parse(time.RFC3339, loc.Timestamp)
+			}
+
+			segmentMatchings, err := matcher.Match(c.UserContext(), points)
+			if err != nil {
+				log.Error().Err(err).Str("tripId", tripID).Msg("Failed to snap trip to roads")
+				return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "Failed to snap trip to roads"})
+			}
+			matchings = append(matchings, segmentMatchings...)
+		}
+
+		collection := geojson.NewFeatureCollection()
+		for _, matching := range matchings {
+			feature := geojson.NewLineStringFeature(matching.Coordinates)
+			feature.Properties["confidence"] = matching.Confidence
+			collection.AddFeature(feature)
+		}
+
+		raw, err := json.Marshal(fiber.Map{"geojson": collection})
+		if err != nil {
+			return err
+		}
+		if err := store.Set(c.UserContext(), cacheKey, string(raw), snappedTripTTL); err != nil {
+			log.Warn().Err(err).Msg("Failed to cache snapped trip")
+		}
+
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		return c.Send(raw)
+	}
+}
+
+// snappedTripKey identifies a snapped track by its trip, the telemetry it was built from and the privacy zones
+// hidden from it, so users sharing a vehicle only share results when they hide the same places.
+func snappedTripKey(tripID string, query dimo.SignalsQuery, zones []PrivacyZone) string {
+	key := fmt.Sprintf("snapped_%s_%d_%d_%s", tripID, query.From.Unix(), query.To.Unix(), query.Interval)
+	if len(zones) > 0 {
+		raw, _ := json.Marshal(zones)
+		sum := sha256.Sum256(raw)
+		key += "_" + hex.EncodeToString(sum[:8])
+	}
+	return key
+}
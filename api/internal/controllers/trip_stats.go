@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"math"
+	"time"
+
+	"github.com/dimo-network/trips-web-app/api/internal/config"
+	"github.com/dimo-network/trips-web-app/api/internal/dimo"
+	"github.com/gofiber/fiber/v2"
+)
+
+// idleSpeedKmh is the speed at or below which the vehicle counts as standing still.
+const idleSpeedKmh = 2.0
+
+// TripStats summarises a trip's telemetry. Distance and speeds are in the user's units. DurationSeconds runs from
+// the first sample to the last; it is split into moving and idle time, and GapSeconds spent between segments, where
+// the signal was lost or fixes were hidden in a privacy zone.
+type TripStats struct {
+	Distance        float64         `json:"distance"`
+	DurationSeconds int64           `json:"durationSeconds"`
+	MovingSeconds   int64           `json:"movingSeconds"`
+	IdleSeconds     int64           `json:"idleSeconds"`
+	GapSeconds      int64           `json:"gapSeconds"`
+	AverageSpeed    float64         `json:"averageSpeed"`
+	MaxSpeed        float64         `json:"maxSpeed"`
+	SpeedBands      []SpeedBandTime `json:"speedBands"`
+}
+
+// SpeedBandTime is how long a trip spent in one of the user's speed bands.
+type SpeedBandTime struct {
+	Color   string `json:"color"`
+	Label   string `json:"label"`
+	Seconds int64  `json:"seconds"`
+}
+
+// calculateTripStats works out a trip's metrics from its samples. The time between two samples is put down to the
+// speed of the first: moving or idle, and the band it falls in. When a sample has no speed, the speed implied by
+// the distance to the next one is used instead. The average speed is the distance moved over the time spent
+// moving. Gaps between segments count as neither moving nor idle, and add nothing to the distance.
+func calculateTripStats(locations []LocationData, prefs SpeedPreferences) TripStats {
+	stats := TripStats{
+		Distance: prefs.distance(pathDistanceKm(locations)),
+		MaxSpeed: prefs.speed(maxSpeedKmh(locations)),
+	}
+
+	// one band more than configured, for speeds over the fastest
+	bands := make([]SpeedBandTime, len(prefs.Bands)+1)
+	for i := range bands {
+		bands[i] = SpeedBandTime{Color: prefs.bandColor(i), Label: prefs.bandLabel(i)}
+	}
+
+	var (
+		moving  time.Duration
+		idle    time.Duration
+		gaps    time.Duration
+		movedKm float64
+	)
+	for i := 1; i < len(locations); i++ {
+		from, to := locations[i-1], locations[i]
+		fromTime, err := time.Parse(time.RFC3339, from.Timestamp)
+		if err != nil {
+			continue
+		}
+		toTime, err := time.Parse(time.RFC3339, to.Timestamp)
+		if err != nil || !toTime.After(fromTime) {
+			continue
+		}
+		elapsed := toTime.Sub(fromTime)
+		if from.Segment != to.Segment {
+			gaps += elapsed
+			continue
+		}
+
+		legKm := 0.0
+		if from.Latitude != nil && from.Longitude != nil && to.Latitude != nil && to.Longitude != nil {
+			legKm = haversineKm(*from.Latitude, *from.Longitude, *to.Latitude, *to.Longitude)
+		}
+		speed := legKm / elapsed.Hours()
+		if from.Speed != nil {
+			speed = *from.Speed
+		}
+
+		if speed > idleSpeedKmh {
+			moving += elapsed
+			movedKm += legKm
+		} else {
+			idle += elapsed
+		}
+		bands[prefs.bandIndex(speed)].Seconds += int64(math.Round(elapsed.Seconds()))
+	}
+
+	stats.MovingSeconds = int64(math.Round(moving.Seconds()))
+	stats.IdleSeconds = int64(math.Round(idle.Seconds()))
+	stats.GapSeconds = int64(math.Round(gaps.Seconds()))
+	if len(locations) > 1 {
+		first, firstErr := time.Parse(time.RFC3339, locations[0].Timestamp)
+		last, lastErr := time.Parse(time.RFC3339, locations[len(locations)-1].Timestamp)
+		if firstErr == nil && lastErr == nil && last.After(first) {
+			stats.DurationSeconds = int64(math.Round(last.Sub(first).Seconds()))
+		}
+	}
+	if moving > 0 {
+		stats.AverageSpeed = prefs.speed(movedKm / moving.Hours())
+	}
+	stats.SpeedBands = bands
+
+	return stats
+}
+
+// HandleTripStats answers /api/trip/:tripID/stats with just the trip's stats, units and cleaning report, so the trip
+// list can fill in its stats columns without drawing every trip's map. It takes the same tokenId, start, end and
+// resolution params as /api/trip/:tripID.
+func HandleTripStats(settings *config.Settings, client *dimo.Client, store SessionStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tripID := c.Params("tripID")
+
+		zones, err := userPrivacyZones(c, store)
+		if err != nil {
+			return tripError(c, tripID, err)
+		}
+		prefs, err := speedPreferences(c, settings, store)
+		if err != nil {
+			return tripError(c, tripID, err)
+		}
+
+		locations, _, err := tripLocations(c, client, store, tripID, c.Query("start"), c.Query("end"))
+		if err != nil {
+			return tripError(c, tripID, err)
+		}
+		locations, cleaning := cleanTrack(locations, zones)
+
+		return c.JSON(fiber.Map{
+			"stats":    calculateTripStats(locations, prefs),
+			"units":    prefs.units(),
+			"cleaning": cleaning,
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func statsSample(at time.Time, lat, speed float64, segment int) LocationData {
+	lon := 13.4
+	return LocationData{
+		Timestamp: at.Format(time.RFC3339),
+		Latitude:  &lat,
+		Longitude: &lon,
+		Speed:     &speed,
+		Segment:   segment,
+	}
+}
+
+func TestCalculateTripStatsSkipsGapsBetweenSegments(t *testing.T) {
+	prefs := SpeedPreferences{Unit: unitKmh, Bands: []SpeedBand{{Threshold: 50, Color: "green"}}, OverColor: "red"}
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+
+	locations := []LocationData{
+		statsSample(start, 52.500, 40, 0),
+		statsSample(start.Add(time.Minute), 52.505, 40, 0),
+		// the signal was lost for an hour, during which the last speed sampled says nothing
+		statsSample(start.Add(61*time.Minute), 52.600, 0, 1),
+		statsSample(start.Add(62*time.Minute), 52.600, 0, 1),
+	}
+
+	stats := calculateTripStats(locations, prefs)
+	if stats.MovingSeconds != 60 {
+		t.Errorf("MovingSeconds = %d, want 60", stats.MovingSeconds)
+	}
+	if stats.IdleSeconds != 60 {
+		t.Errorf("IdleSeconds = %d, want 60", stats.IdleSeconds)
+	}
+	if stats.GapSeconds != 3600 {
+		t.Errorf("GapSeconds = %d, want 3600", stats.GapSeconds)
+	}
+	if stats.DurationSeconds != 62*60 {
+		t.Errorf("DurationSeconds = %d, want %d", stats.DurationSeconds, 62*60)
+	}
+	var banded int64
+	for _, band := range stats.SpeedBands {
+		banded += band.Seconds
+	}
+	if banded != stats.MovingSeconds+stats.IdleSeconds {
+		t.Errorf("speed bands add up to %d seconds, want %d", banded, stats.MovingSeconds+stats.IdleSeconds)
+	}
+}
+
+func TestCalculateTripStatsAddsDistancePerSegment(t *testing.T) {
+	prefs := SpeedPreferences{Unit: unitKmh, Bands: []SpeedBand{{Threshold: 50, Color: "green"}}, OverColor: "red"}
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+
+	// three segments of 0.01 degrees of latitude each, split by a lost signal and a privacy zone that together
+	// skip 0.2 degrees, about 22 km that must not be counted
+	locations := []LocationData{
+		statsSample(start, 52.50, 60, 0),
+		statsSample(start.Add(time.Minute), 52.51, 60, 0),
+		statsSample(start.Add(20*time.Minute), 52.61, 60, 1),
+		statsSample(start.Add(21*time.Minute), 52.62, 60, 1),
+		statsSample(start.Add(40*time.Minute), 52.72, 60, 2),
+		statsSample(start.Add(41*time.Minute), 52.73, 60, 2),
+	}
+	legKm := haversineKm(52.50, 13.4, 52.51, 13.4)
+
+	stats := calculateTripStats(locations, prefs)
+	if want := 3 * legKm; math.Abs(stats.Distance-want) > 0.01 {
+		t.Errorf("Distance = %.3f km, want %.3f", stats.Distance, want)
+	}
+	if stats.MovingSeconds != 180 || stats.GapSeconds != 38*60 || stats.DurationSeconds != 41*60 {
+		t.Errorf("moving %d, gap %d, duration %d seconds; want 180, %d, %d", stats.MovingSeconds, stats.GapSeconds, stats.DurationSeconds, 38*60, 41*60)
+	}
+	// the average is the distance moved over the time moving, both without the gaps
+	if want := 3 * legKm / (3.0 / 60); math.Abs(stats.AverageSpeed-want) > 0.1 {
+		t.Errorf("AverageSpeed = %.1f, want %.1f", stats.AverageSpeed, want)
+	}
+	if got := pathDistanceKm(locations); math.Abs(got-3*legKm) > 0.01 {
+		t.Errorf("pathDistanceKm() = %.3f, want %.3f", got, 3*legKm)
+	}
+}
@@ -0,0 +1,240 @@
+package controllers
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/dimo-network/trips-web-app/api/internal/dimo"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// archiveWorkers is how many trips' telemetry is fetched at once for a bulk export.
+	archiveWorkers = 4
+	// maxArchiveTrips caps a single bulk export; larger histories have to be narrowed with from and to.
+	maxArchiveTrips = 500
+)
+
+// tripTelemetry is one trip's cleaned telemetry, or why it couldn't be fetched.
+type tripTelemetry struct {
+	trip      dimo.Trip
+	locations []LocationData
+	cleaning  TrackCleaning
+	err       error
+}
+
+// HandleTripsExport streams a zip of every trip in the from/to range, one GeoJSON or GPX file per trip plus a
+// summary.csv. Telemetry is fetched by a small worker pool while the archive is being written, so the response
+// starts straight away and is never held in memory as a whole.
+func (t *TripsController) HandleTripsExport(c *fiber.Ctx) error {
+	tokenID, err := strconv.ParseInt(c.Params("tokenid"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid token ID"})
+	}
+	from, to, err := parseTripRange(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	formatName := c.Query("format", "geojson")
+	if formatName != "geojson" && formatName != "gpx" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "format must be geojson or gpx"})
+	}
+	format := exportFormats[formatName]
+
+	trips, truncated, err := QueryTripsAPI(tokenID, t.client, t.store, c)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query trips API")
+		return renderUpstreamError(c, err, "Failed to fetch trips")
+	}
+	if truncated {
+		log.Warn().Int64("tokenId", tokenID).Msg("Exporting from a truncated trip history")
+	}
+	trips = filterTripsByRange(trips, from, to)
+	if len(trips) > maxArchiveTrips {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("%d trips match; narrow the date range to at most %d", len(trips), maxArchiveTrips),
+		})
+	}
+
+	// the workers can't use the request, which is over once streaming starts, so they get tokens from the session
+	// directly; fetching the first one now turns a failed exchange into an error page rather than a broken zip
+	tokens, err := sessionPrivilegeTokens(c, t.client, t.store)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get privilege token")
+		return renderUpstreamError(c, err, "Failed to fetch trips")
+	}
+	if _, err := tokens.token(c.UserContext(), tokenID, false); err != nil {
+		log.Error().Err(err).Msg("Failed to get privilege token")
+		return renderUpstreamError(c, err, "Failed to fetch trips")
+	}
+	zones, err := userPrivacyZones(c, t.store)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load privacy zones")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to export trips"})
+	}
+	prefs, err := speedPreferences(c, &t.settings, t.store)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load speed preferences")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to export trips"})
+	}
+
+	log.Info().Int64("tokenId", tokenID).Int("trips", len(trips)).Str("format", formatName).Msg("Exporting trips")
+
+	client := t.client
+	c.Attachment(fmt.Sprintf("vehicle_%d_trips.zip", tokenID))
+	c.Set(fiber.HeaderContentType, "application/zip")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		// the request is over by the time the body is written, so the export gets its own context; it is cancelled
+		// as soon as a write fails, which stops the workers when the client goes away
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		if err := writeTripsArchive(ctx, w, client, tokens, tokenID, trips, zones, prefs, format); err != nil {
+			log.Error().Err(err).Int64("tokenId", tokenID).Msg("Trip export aborted")
+		}
+	})
+
+	return nil
+}
+
+// writeTripsArchive writes the zip, in trip order, flushing after every trip so the client sees progress.
+// Trips whose telemetry couldn't be fetched are left out and their error is recorded in summary.csv, which stays
+// in metric units whatever prefs say so archives can be compared.
+func writeTripsArchive(ctx context.Context, w *bufio.Writer, client *dimo.Client, tokens *privilegeTokens, tokenID int64, trips []dimo.Trip, zones []PrivacyZone, prefs SpeedPreferences, format exportFormat) error {
+	archive := zip.NewWriter(w)
+	summary := [][]string{{"trip_id", "start", "end", "duration_seconds", "distance_km", "max_speed_kmh", "filtered_points", "error"}}
+
+	for result := range fetchTripTelemetry(ctx, client, tokens, tokenID, trips, zones) {
+		export := <-result
+		summary = append(summary, tripSummaryRow(export))
+		if export.err != nil {
+			log.Warn().Err(export.err).Str("tripId", export.trip.ID).Msg("Leaving trip out of export")
+			continue
+		}
+
+		file, err := archive.Create(fmt.Sprintf("trip_%s.%s", export.trip.ID, format.extension))
+		if err != nil {
+			return errors.Wrap(err, "error adding trip to archive")
+		}
+		estimatedStart := maskLocation(export.trip.Start.EstimatedLocation, zones)
+		if err := format.write(file, export.trip.ID, export.locations, estimatedStart, prefs); err != nil {
+			return errors.Wrapf(err, "error writing trip %s", export.trip.ID)
+		}
+		if err := w.Flush(); err != nil {
+			return errors.Wrap(err, "error streaming archive")
+		}
+	}
+
+	file, err := archive.Create("summary.csv")
+	if err != nil {
+		return errors.Wrap(err, "error adding summary to archive")
+	}
+	if err := csv.NewWriter(file).WriteAll(summary); err != nil {
+		return errors.Wrap(err, "error writing summary")
+	}
+	if err := archive.Close(); err != nil {
+		return errors.Wrap(err, "error finishing archive")
+	}
+	return w.Flush()
+}
+
+// fetchTripTelemetry fetches and cleans every trip's telemetry on archiveWorkers goroutines, hiding the privacy
+// zones. It hands back one channel per trip, in trip order, each receiving that trip's result. Only a couple of
+// batches are let ahead of the reader, so a slow client holds back the fetching rather than letting finished trips
+// pile up in memory. A long export outlives its privilege token, so each trip takes the session's current one, and
+// a fresh one is exchanged when the Telemetry API turns it away.
+func fetchTripTelemetry(ctx context.Context, client *dimo.Client, tokens *privilegeTokens, tokenID int64, trips []dimo.Trip, zones []PrivacyZone) <-chan chan tripTelemetry {
+	type job struct {
+		trip   dimo.Trip
+		result chan tripTelemetry
+	}
+	pending := make(chan chan tripTelemetry, 2*archiveWorkers)
+	jobs := make(chan job)
+
+	go func() {
+		defer close(pending)
+		defer close(jobs)
+		for _, trip := range trips {
+			result := make(chan tripTelemetry, 1)
+			select {
+			case pending <- result:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case jobs <- job{trip: trip, result: result}:
+			case <-ctx.Done():
+				result <- tripTelemetry{trip: trip, err: ctx.Err()}
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < archiveWorkers; i++ {
+		go func() {
+			for j := range jobs {
+				export := tripTelemetry{trip: j.trip}
+				start, startErr := time.Parse(time.RFC3339, j.trip.Start.Time)
+				end, endErr := time.Parse(time.RFC3339, j.trip.End.Time)
+				if startErr != nil || endErr != nil {
+					export.err = errors.New("trip has invalid start or end time")
+				} else {
+					export.locations, export.err = fetchArchiveLocations(ctx, client, tokens, dimo.SignalsQuery{
+						TokenID:  tokenID,
+						Interval: formatInterval(adaptiveInterval(end.Sub(start))),
+						From:     start,
+						To:       end,
+					})
+					export.locations, export.cleaning = cleanTrack(export.locations, zones)
+				}
+				j.result <- export
+			}
+		}()
+	}
+
+	return pending
+}
+
+// fetchArchiveLocations fetches one trip's telemetry for an export, exchanging a fresh privilege token and trying
+// once more if the one in hand has expired.
+func fetchArchiveLocations(ctx context.Context, client *dimo.Client, tokens *privilegeTokens, query dimo.SignalsQuery) ([]LocationData, error) {
+	privilegeToken, err := tokens.token(ctx, query.TokenID, false)
+	if err != nil {
+		return nil, err
+	}
+	locations, err := fetchLocations(ctx, client, privilegeToken, query)
+	if !unauthorized(err) {
+		return locations, err
+	}
+
+	if privilegeToken, err = tokens.token(ctx, query.TokenID, true); err != nil {
+		return nil, err
+	}
+	return fetchLocations(ctx, client, privilegeToken, query)
+}
+
+func tripSummaryRow(export tripTelemetry) []string {
+	row := []string{export.trip.ID, export.trip.Start.Time, export.trip.End.Time, "", "", "", "", ""}
+
+	start, startErr := time.Parse(time.RFC3339, export.trip.Start.Time)
+	end, endErr := time.Parse(time.RFC3339, export.trip.End.Time)
+	if startErr == nil && endErr == nil {
+		row[3] = strconv.FormatInt(int64(end.Sub(start).Seconds()), 10)
+	}
+	if export.err != nil {
+		row[7] = export.err.Error()
+		return row
+	}
+	row[4] = strconv.FormatFloat(pathDistanceKm(export.locations), 'f', 3, 64)
+	row[5] = strconv.FormatFloat(maxSpeedKmh(export.locations), 'f', 1, 64)
+	row[6] = strconv.Itoa(export.cleaning.Filtered)
+
+	return row
+}
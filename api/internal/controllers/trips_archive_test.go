@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"testing"
+	"time"
+
+	"github.com/dimo-network/trips-web-app/api/internal/dimo"
+	"github.com/dimo-network/trips-web-app/api/internal/session"
+)
+
+func TestWriteTripsArchiveOutlivesPrivilegeTokens(t *testing.T) {
+	upstream := newFakeDIMO(t)
+	// every privilege token expires after a few uses, well before the export is done
+	upstream.tokenUses = 3
+	settings := upstream.settings()
+	client := dimo.NewClient(&settings)
+	tokens := &privilegeTokens{client: client, store: session.NewMemoryStore(), sessionID: "session-1", idToken: "id-token"}
+
+	trips := testTrips("trip", time.Date(2024, 5, 1, 18, 0, 0, 0, time.UTC), 20)
+	var out bytes.Buffer
+	w := bufio.NewWriter(&out)
+	if err := writeTripsArchive(context.Background(), w, client, tokens, 7, trips, nil, exportTestPreferences(), exportFormats["geojson"]); err != nil {
+		t.Fatalf("writeTripsArchive() error = %v", err)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archive.File) != len(trips)+1 {
+		t.Errorf("archive has %d files, want %d trips and a summary", len(archive.File), len(trips))
+	}
+
+	summaryFile, err := archive.Open("summary.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(summaryFile).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows[1:] {
+		if row[7] != "" {
+			t.Errorf("trip %s failed: %s", row[0], row[7])
+		}
+	}
+
+	if got := upstream.callCount("token-exchange"); got < 2 {
+		t.Errorf("token exchanged %d times, want a fresh token once the first expired", got)
+	}
+}
@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"context"
+	"math"
+	"strconv"
+
+	"github.com/dimo-network/trips-web-app/api/internal/dimo"
+	"github.com/dimo-network/trips-web-app/api/internal/eth"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+)
+
+// upstreamStatus maps an error from the DIMO client to the status the app should answer with.
+func upstreamStatus(err error) int {
+	if errors.Is(err, dimo.ErrCircuitOpen) {
+		return fiber.StatusServiceUnavailable
+	}
+	if errors.Is(err, eth.ErrInvalidAddress) {
+		return fiber.StatusBadRequest
+	}
+
+	var apiErr *dimo.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case fiber.StatusBadRequest:
+			return fiber.StatusBadRequest
+		case fiber.StatusUnauthorized, fiber.StatusForbidden:
+			return fiber.StatusForbidden
+		case fiber.StatusNotFound:
+			return fiber.StatusNotFound
+		case fiber.StatusTooManyRequests:
+			return fiber.StatusTooManyRequests
+		default:
+			return fiber.StatusBadGateway
+		}
+	}
+
+	var gqlErr *dimo.GraphQLError
+	if errors.As(err, &gqlErr) {
+		return fiber.StatusBadGateway
+	}
+
+	var timeoutErr interface{ Timeout() bool }
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &timeoutErr) && timeoutErr.Timeout()) {
+		return fiber.StatusGatewayTimeout
+	}
+
+	return fiber.StatusInternalServerError
+}
+
+// circuitOpen returns the breaker error behind err, if the request was refused because an upstream is down.
+func circuitOpen(err error) (*dimo.CircuitOpenError, bool) {
+	var openErr *dimo.CircuitOpenError
+	if errors.As(err, &openErr) {
+		return openErr, true
+	}
+	return nil, false
+}
+
+func retryAfterSeconds(openErr *dimo.CircuitOpenError) int {
+	return int(math.Ceil(openErr.RetryAfter.Seconds()))
+}
+
+// renderUpstreamError answers a page request that failed upstream. While a breaker is open the degraded page is
+// shown instead of a bare error string.
+func renderUpstreamError(c *fiber.Ctx, err error, message string) error {
+	if openErr, ok := circuitOpen(err); ok {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfterSeconds(openErr)))
+		return c.Status(fiber.StatusServiceUnavailable).Render("degraded", fiber.Map{
+			"Service":    string(openErr.Service),
+			"RetryAfter": retryAfterSeconds(openErr),
+		})
+	}
+
+	return c.Status(upstreamStatus(err)).SendString(message + ": " + err.Error())
+}
+
+// upstreamErrorJSON is renderUpstreamError for routes called from Javascript.
+func upstreamErrorJSON(c *fiber.Ctx, err error, message string) error {
+	if openErr, ok := circuitOpen(err); ok {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfterSeconds(openErr)))
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error":      message,
+			"degraded":   true,
+			"service":    string(openErr.Service),
+			"retryAfter": retryAfterSeconds(openErr),
+		})
+	}
+
+	return c.Status(upstreamStatus(err)).JSON(fiber.Map{"error": message})
+}
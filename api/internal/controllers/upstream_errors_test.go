@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dimo-network/trips-web-app/api/internal/dimo"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/template/handlebars/v2"
+	"github.com/pkg/errors"
+)
+
+func TestRenderUpstreamErrorShowsDegradedPage(t *testing.T) {
+	app := fiber.New(fiber.Config{Views: handlebars.New("../../views", ".hbs")})
+	app.Get("/open", func(c *fiber.Ctx) error {
+		err := errors.Wrap(&dimo.CircuitOpenError{Service: dimo.TripsAPI, RetryAfter: 12500 * time.Millisecond}, "error getting trips")
+		return renderUpstreamError(c, err, "Failed to get trips")
+	})
+	app.Get("/failed", func(c *fiber.Ctx) error {
+		return renderUpstreamError(c, &dimo.APIError{Service: dimo.TripsAPI, StatusCode: fiber.StatusInternalServerError}, "Failed to get trips")
+	})
+
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/open", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != fiber.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", resp.StatusCode, fiber.StatusServiceUnavailable)
+	}
+	if got := resp.Header.Get(fiber.HeaderRetryAfter); got != "13" {
+		t.Errorf("Retry-After = %q, want 13", got)
+	}
+	for _, want := range []string{"Temporarily Unavailable", "trips-api", "13 seconds"} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("degraded page does not mention %q", want)
+		}
+	}
+
+	// any other upstream failure is still a plain error
+	resp, err = app.Test(httptest.NewRequest(fiber.MethodGet, "/failed", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	if resp.StatusCode != fiber.StatusBadGateway {
+		t.Errorf("status = %d, want %d", resp.StatusCode, fiber.StatusBadGateway)
+	}
+	if strings.Contains(string(body), "Temporarily Unavailable") {
+		t.Error("a plain upstream error rendered the degraded page")
+	}
+}
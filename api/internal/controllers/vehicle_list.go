@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dimo-network/trips-web-app/api/internal/dimo"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+)
+
+// VehicleListQuery is what /vehicles/me was asked to show: which list, a search over make, model and year,
+// a sort order and a page.
+type VehicleListQuery struct {
+	View   string
+	Search string
+	Sort   string
+	Order  string
+	Page   int
+	Limit  int
+}
+
+// SortOption is an entry in the sort dropdown.
+type SortOption struct {
+	Value    string
+	Label    string
+	Selected bool
+}
+
+var vehicleSortFields = []SortOption{
+	{Value: "", Label: "Default"},
+	{Value: "make", Label: "Make"},
+	{Value: "model", Label: "Model"},
+	{Value: "year", Label: "Year"},
+	{Value: "tokenId", Label: "Vehicle ID"},
+}
+
+func parseVehicleListQuery(c *fiber.Ctx) (VehicleListQuery, error) {
+	query := VehicleListQuery{
+		View:   c.Query("view", "owned"),
+		Search: strings.TrimSpace(c.Query("q")),
+		Sort:   c.Query("sort"),
+		Order:  c.Query("order", "asc"),
+	}
+
+	if query.View != "owned" && query.View != "shared" {
+		return query, errors.New("view must be owned or shared")
+	}
+	if !validSortField(query.Sort) {
+		return query, errors.New("sort must be one of make, model, year or tokenId")
+	}
+	if query.Order != "asc" && query.Order != "desc" {
+		return query, errors.New("order must be asc or desc")
+	}
+
+	var err error
+	query.Page, query.Limit, err = parsePaging(c)
+	return query, err
+}
+
+func validSortField(field string) bool {
+	for _, option := range vehicleSortFields {
+		if option.Value == field {
+			return true
+		}
+	}
+	return false
+}
+
+// sortOptions returns the sort dropdown with the current choice selected.
+func (q VehicleListQuery) sortOptions() []SortOption {
+	options := make([]SortOption, len(vehicleSortFields))
+	for i, option := range vehicleSortFields {
+		option.Selected = option.Value == q.Sort
+		options[i] = option
+	}
+	return options
+}
+
+// filterVehicles keeps the vehicles matching every word of search against their make, model or year.
+func filterVehicles(vehicles []dimo.Vehicle, search string) []dimo.Vehicle {
+	terms := strings.Fields(strings.ToLower(search))
+	if len(terms) == 0 {
+		return vehicles
+	}
+
+	filtered := make([]dimo.Vehicle, 0, len(vehicles))
+	for _, vehicle := range vehicles {
+		haystack := strings.ToLower(vehicle.Definition.Make + " " + vehicle.Definition.Model + " " + strconv.Itoa(vehicle.Definition.Year))
+		matches := true
+		for _, term := range terms {
+			if !strings.Contains(haystack, term) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			filtered = append(filtered, vehicle)
+		}
+	}
+	return filtered
+}
+
+// sortVehicles orders vehicles in place. An empty field keeps the order the Identity API returned.
+func sortVehicles(vehicles []dimo.Vehicle, field, order string) {
+	var less func(a, b dimo.Vehicle) bool
+	switch field {
+	case "make":
+		less = func(a, b dimo.Vehicle) bool {
+			return strings.ToLower(a.Definition.Make) < strings.ToLower(b.Definition.Make)
+		}
+	case "model":
+		less = func(a, b dimo.Vehicle) bool {
+			return strings.ToLower(a.Definition.Model) < strings.ToLower(b.Definition.Model)
+		}
+	case "year":
+		less = func(a, b dimo.Vehicle) bool { return a.Definition.Year < b.Definition.Year }
+	case "tokenId":
+		less = func(a, b dimo.Vehicle) bool { return a.TokenID < b.TokenID }
+	default:
+		return
+	}
+
+	sort.SliceStable(vehicles, func(i, j int) bool {
+		if order == "desc" {
+			return less(vehicles[j], vehicles[i])
+		}
+		return less(vehicles[i], vehicles[j])
+	})
+}
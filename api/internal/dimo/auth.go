@@ -0,0 +1,83 @@
+package dimo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+// TokenResponse is the token payload returned by the auth server's token endpoint.
+type TokenResponse struct {
+	IDToken      string `json:"id_token"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// Challenge is the message a wallet signs to log in, and the state that ties the signature back to it.
+type Challenge struct {
+	State     string `json:"state"`
+	Challenge string `json:"challenge"`
+}
+
+// GenerateChallenge asks the auth server for a challenge for address to sign. Retrying is safe: each attempt
+// only issues a fresh challenge, and the one that is answered is the one the user signs.
+func (c *Client) GenerateChallenge(ctx context.Context, address string) (*Challenge, error) {
+	form := url.Values{}
+	form.Add("client_id", c.settings.ClientID)
+	form.Add("domain", c.settings.Domain)
+	form.Add("scope", c.settings.Scope)
+	form.Add("response_type", c.settings.ResponseType)
+	form.Add("address", address)
+
+	var resp Challenge
+	if err := c.do(ctx, AuthAPI, http.MethodPost, c.settings.AuthURL, "", form, &resp, true); err != nil {
+		return nil, err
+	}
+	if resp.State == "" || resp.Challenge == "" {
+		return nil, errors.New("state or challenge missing from generate challenge response")
+	}
+
+	return &resp, nil
+}
+
+// SubmitChallenge trades a signed challenge for tokens. It is never retried: the auth server spends the state on
+// the first attempt, even if its response was lost.
+func (c *Client) SubmitChallenge(ctx context.Context, state, signature string) (*TokenResponse, error) {
+	form := url.Values{}
+	form.Add("client_id", c.settings.ClientID)
+	form.Add("domain", c.settings.Domain)
+	form.Add("grant_type", c.settings.GrantType)
+	form.Add("state", state)
+	form.Add("signature", signature)
+
+	var resp TokenResponse
+	if err := c.do(ctx, AuthAPI, http.MethodPost, c.settings.SubmitChallengeURL, "", form, &resp, false); err != nil {
+		return nil, err
+	}
+	if resp.IDToken == "" {
+		return nil, errors.New("id_token not found in submit challenge response")
+	}
+
+	return &resp, nil
+}
+
+// RefreshTokens trades a refresh token for a new set of tokens. It is never retried: with refresh token rotation
+// the first attempt may already have spent the token, even if its response was lost.
+func (c *Client) RefreshTokens(ctx context.Context, refreshToken string) (*TokenResponse, error) {
+	form := url.Values{}
+	form.Add("client_id", c.settings.ClientID)
+	form.Add("grant_type", "refresh_token")
+	form.Add("refresh_token", refreshToken)
+
+	var resp TokenResponse
+	if err := c.do(ctx, AuthAPI, http.MethodPost, c.settings.TokenURL, "", form, &resp, false); err != nil {
+		return nil, err
+	}
+	if resp.IDToken == "" {
+		return nil, errors.New("id_token not found in token refresh response")
+	}
+
+	return &resp, nil
+}
@@ -0,0 +1,57 @@
+package dimo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/dimo-network/trips-web-app/api/internal/config"
+)
+
+func TestGenerateChallengeRetriesAndSendsTheForm(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		if got := r.PostForm.Get("address"); got != "0xabc" {
+			t.Errorf("address = %q, want 0xabc", got)
+		}
+		if got := r.PostForm.Get("client_id"); got != "client" {
+			t.Errorf("client_id = %q, want client", got)
+		}
+		_ = json.NewEncoder(w).Encode(Challenge{State: "state", Challenge: "sign me"})
+	}))
+	defer server.Close()
+
+	c := newTestClient(&config.Settings{AuthURL: server.URL, ClientID: "client"})
+	challenge, err := c.GenerateChallenge(context.Background(), "0xabc")
+	if err != nil {
+		t.Fatalf("GenerateChallenge() error = %v", err)
+	}
+	if challenge.State != "state" || challenge.Challenge != "sign me" {
+		t.Errorf("GenerateChallenge() = %+v", challenge)
+	}
+	if calls := atomic.LoadInt32(&calls); calls != 2 {
+		t.Errorf("server called %d times, want 2", calls)
+	}
+}
+
+func TestSubmitChallengeIsNotRetried(t *testing.T) {
+	server := newFlakyServer(t, http.StatusServiceUnavailable)
+	c := newTestClient(&config.Settings{SubmitChallengeURL: server.URL})
+
+	if _, err := c.SubmitChallenge(context.Background(), "state", "0xsig"); err == nil {
+		t.Fatal("SubmitChallenge() succeeded against a failing server")
+	}
+	if got := server.callCount(); got != 1 {
+		t.Errorf("server called %d times, want 1", got)
+	}
+}
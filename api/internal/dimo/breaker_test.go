@@ -0,0 +1,146 @@
+package dimo
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dimo-network/trips-web-app/api/internal/config"
+	"github.com/pkg/errors"
+)
+
+type testClock struct {
+	at time.Time
+}
+
+func (c *testClock) now() time.Time { return c.at }
+
+func newTestBreaker(threshold int, cooldown time.Duration) (*breaker, *testClock) {
+	clock := &testClock{at: time.Now()}
+	b := newBreaker(TripsAPI, threshold, cooldown)
+	b.now = clock.now
+	return b, clock
+}
+
+func TestBreakerLifecycle(t *testing.T) {
+	b, clock := newTestBreaker(3, 30*time.Second)
+
+	// closed: failures below the threshold let calls through
+	for i := 0; i < 2; i++ {
+		if err := b.allow(); err != nil {
+			t.Fatalf("allow() while closed error = %v", err)
+		}
+		b.record(false)
+	}
+
+	// the threshold-th failure in a row opens it
+	if err := b.allow(); err != nil {
+		t.Fatalf("allow() while closed error = %v", err)
+	}
+	b.record(false)
+	clock.at = clock.at.Add(10 * time.Second)
+	err := b.allow()
+	var openErr *CircuitOpenError
+	if !errors.As(err, &openErr) {
+		t.Fatalf("allow() while open error = %v, want a CircuitOpenError", err)
+	}
+	if openErr.RetryAfter != 20*time.Second {
+		t.Errorf("RetryAfter = %s, want 20s", openErr.RetryAfter)
+	}
+
+	// half-open after the cooldown: one probe goes through, others are still refused
+	clock.at = clock.at.Add(20 * time.Second)
+	if err := b.allow(); err != nil {
+		t.Fatalf("allow() for the probe error = %v", err)
+	}
+	if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("allow() during the probe error = %v, want ErrCircuitOpen", err)
+	}
+
+	// a failed probe re-opens it for another cooldown
+	b.record(false)
+	if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("allow() after a failed probe error = %v, want ErrCircuitOpen", err)
+	}
+
+	// a successful probe closes it and forgets the failures
+	clock.at = clock.at.Add(30 * time.Second)
+	if err := b.allow(); err != nil {
+		t.Fatalf("allow() for the second probe error = %v", err)
+	}
+	b.record(true)
+	for i := 0; i < 2; i++ {
+		if err := b.allow(); err != nil {
+			t.Fatalf("allow() after closing error = %v", err)
+		}
+		b.record(false)
+	}
+	if err := b.allow(); err != nil {
+		t.Errorf("allow() after fewer than threshold new failures error = %v", err)
+	}
+}
+
+func TestBreakerReleaseLetsAnotherProbeThrough(t *testing.T) {
+	b, clock := newTestBreaker(1, 30*time.Second)
+
+	b.record(false)
+	clock.at = clock.at.Add(30 * time.Second)
+	if err := b.allow(); err != nil {
+		t.Fatalf("allow() for the probe error = %v", err)
+	}
+
+	// the probe was cancelled, which says nothing about the upstream
+	b.release()
+	if err := b.allow(); err != nil {
+		t.Errorf("allow() after a released probe error = %v", err)
+	}
+}
+
+func TestClientFailsFastWhileBreakerIsOpen(t *testing.T) {
+	server := newFlakyServer(t, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError)
+	c := newTestClient(&config.Settings{APIMaxRetries: -1, BreakerThreshold: 3})
+	b, clock := c.breakers[TripsAPI], &testClock{at: time.Now()}
+	b.now = clock.now
+
+	for i := 0; i < 3; i++ {
+		if err := c.do(context.Background(), TripsAPI, http.MethodGet, server.URL, "", nil, &okResponse{}, true); errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("call %d refused by the breaker before the threshold", i+1)
+		}
+	}
+
+	err := c.do(context.Background(), TripsAPI, http.MethodGet, server.URL, "", nil, &okResponse{}, true)
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("do() error = %v, want ErrCircuitOpen", err)
+	}
+	if got := server.callCount(); got != 3 {
+		t.Errorf("server called %d times, want 3", got)
+	}
+
+	// other upstreams have breakers of their own
+	if err := c.breakers[TelemetryAPI].allow(); err != nil {
+		t.Errorf("telemetry breaker allow() error = %v", err)
+	}
+
+	// the server has recovered by the time the cooldown ends
+	clock.at = clock.at.Add(defaultBreakerCooldown)
+	if err := c.do(context.Background(), TripsAPI, http.MethodGet, server.URL, "", nil, &okResponse{}, true); err != nil {
+		t.Fatalf("do() after the cooldown error = %v", err)
+	}
+	if b.state != breakerClosed {
+		t.Errorf("breaker state = %d after a successful probe, want closed", b.state)
+	}
+}
+
+func TestClientErrorsDoNotTripBreaker(t *testing.T) {
+	server := newFlakyServer(t, http.StatusNotFound, http.StatusNotFound, http.StatusNotFound)
+	c := newTestClient(&config.Settings{BreakerThreshold: 2})
+
+	for i := 0; i < 3; i++ {
+		err := c.do(context.Background(), TripsAPI, http.MethodGet, server.URL, "", nil, &okResponse{}, true)
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+			t.Fatalf("do() error = %v, want the upstream's 404", err)
+		}
+	}
+}
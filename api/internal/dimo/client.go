@@ -0,0 +1,264 @@
+package dimo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+
+	"github.com/dimo-network/trips-web-app/api/internal/config"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const defaultTimeout = 15 * time.Second
+
+// Service names the upstream DIMO API a request went to. It is carried on errors so callers can tell them apart.
+type Service string
+
+const (
+	TripsAPI         Service = "trips-api"
+	TelemetryAPI     Service = "telemetry-api"
+	DeviceDataAPI    Service = "device-data-api"
+	IdentityAPI      Service = "identity-api"
+	TokenExchangeAPI Service = "token-exchange-api"
+	UsersAPI         Service = "users-api"
+	AuthAPI          Service = "auth-api"
+)
+
+// APIError is returned when an upstream answers with a non-2xx status.
+type APIError struct {
+	Service    Service
+	StatusCode int
+	Body       string
+	RetryAfter string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s responded with status %d: %s", e.Service, e.StatusCode, e.Body)
+}
+
+// GraphQLError is returned when a GraphQL API answers 200 but reports errors and no usable data.
+type GraphQLError struct {
+	Service  Service
+	Messages []string
+}
+
+func (e *GraphQLError) Error() string {
+	return fmt.Sprintf("%s returned errors: %s", e.Service, strings.Join(e.Messages, "; "))
+}
+
+// decodeError is returned when an upstream answers 2xx with a body we cannot parse. Retrying won't help.
+type decodeError struct {
+	err error
+}
+
+func (e *decodeError) Error() string { return e.err.Error() }
+func (e *decodeError) Unwrap() error { return e.err }
+
+// Client talks to the DIMO APIs the app depends on. It is safe for concurrent use.
+//
+// Idempotent requests are retried with jittered exponential backoff on transient failures, and every
+// upstream sits behind its own circuit breaker so an outage fails fast instead of tying up requests.
+type Client struct {
+	httpClient *http.Client
+	settings   *config.Settings
+	breakers   map[Service]*breaker
+
+	maxRetries     int
+	retryBaseDelay time.Duration
+	retryMaxDelay  time.Duration
+}
+
+func NewClient(settings *config.Settings) *Client {
+	timeout := defaultTimeout
+	if settings.APITimeoutSeconds > 0 {
+		timeout = time.Duration(settings.APITimeoutSeconds) * time.Second
+	}
+	maxRetries := defaultMaxRetries
+	if settings.APIMaxRetries > 0 {
+		maxRetries = settings.APIMaxRetries
+	} else if settings.APIMaxRetries < 0 {
+		// a negative value turns retries off
+		maxRetries = 0
+	}
+	threshold := defaultBreakerThreshold
+	if settings.BreakerThreshold > 0 {
+		threshold = settings.BreakerThreshold
+	}
+	cooldown := defaultBreakerCooldown
+	if settings.BreakerCooldownSeconds > 0 {
+		cooldown = time.Duration(settings.BreakerCooldownSeconds) * time.Second
+	}
+
+	breakers := make(map[Service]*breaker)
+	for _, service := range []Service{TripsAPI, TelemetryAPI, DeviceDataAPI, IdentityAPI, TokenExchangeAPI, UsersAPI, AuthAPI} {
+		breakers[service] = newBreaker(service, threshold, cooldown)
+	}
+
+	return &Client{
+		httpClient:     &http.Client{Timeout: timeout},
+		settings:       settings,
+		breakers:       breakers,
+		maxRetries:     maxRetries,
+		retryBaseDelay: defaultRetryBaseDelay,
+		retryMaxDelay:  defaultRetryMaxDelay,
+	}
+}
+
+type GraphQLRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables,omitempty"`
+}
+
+type graphQLResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message string   `json:"message"`
+		Path    []string `json:"path"`
+	} `json:"errors"`
+}
+
+// do sends a request with an optional bearer token and decodes a 2xx JSON response into out. The body may be nil,
+// url.Values to send a form, or anything else to send as JSON. Requests marked idempotent are retried on transient
+// failures; all requests go through the service's breaker.
+func (c *Client) do(ctx context.Context, service Service, method, url, token string, body, out interface{}, idempotent bool) error {
+	var (
+		payload     []byte
+		contentType string
+	)
+	switch b := body.(type) {
+	case nil:
+	case neturl.Values:
+		payload, contentType = []byte(b.Encode()), "application/x-www-form-urlencoded"
+	default:
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return errors.Wrapf(err, "error marshalling %s request", service)
+		}
+		contentType = "application/json"
+	}
+
+	attempts := 1
+	if idempotent {
+		attempts += c.maxRetries
+	}
+
+	br := c.breakers[service]
+	var err error
+	for attempt := 1; ; attempt++ {
+		if br != nil {
+			if openErr := br.allow(); openErr != nil {
+				if err != nil {
+					// keep the upstream's own error, it says more than "the breaker is open"
+					return err
+				}
+				return openErr
+			}
+		}
+
+		err = c.attempt(ctx, service, method, url, token, contentType, payload, out)
+
+		if br != nil {
+			switch {
+			case countsAsFailure(ctx, err):
+				br.record(false)
+			case ctx.Err() != nil:
+				br.release()
+			default:
+				br.record(true)
+			}
+		}
+
+		if err == nil || attempt >= attempts || !isTransient(ctx, err) {
+			return err
+		}
+
+		delay, ok := c.retryAfter(err)
+		if !ok {
+			delay = c.backoff(attempt)
+		}
+		log.Warn().Err(err).Str("service", string(service)).Int("attempt", attempt).Dur("delay", delay).Msg("Retrying upstream request")
+		if sleepErr := sleep(ctx, delay); sleepErr != nil {
+			return err
+		}
+	}
+}
+
+// attempt makes a single HTTP round trip.
+func (c *Client) attempt(ctx context.Context, service Service, method, url, token, contentType string, payload []byte, out interface{}) error {
+	var reqBody io.Reader
+	if payload != nil {
+		reqBody = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return errors.Wrapf(err, "error creating %s request", service)
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "error making request to %s", service)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrapf(err, "error reading response from %s", service)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &APIError{
+			Service:    service,
+			StatusCode: resp.StatusCode,
+			Body:       string(respBody),
+			RetryAfter: resp.Header.Get("Retry-After"),
+		}
+	}
+
+	log.Debug().Str("service", string(service)).Msgf("Response body: %s", string(respBody))
+
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return &decodeError{err: errors.Wrapf(err, "error parsing response from %s", service)}
+	}
+
+	return nil
+}
+
+// graphQL runs a GraphQL query and decodes its data into out. Queries don't change anything, so they are retried
+// like GETs. Errors reported alongside data are logged; errors without any data are returned as a GraphQLError.
+func (c *Client) graphQL(ctx context.Context, service Service, url, token string, request GraphQLRequest, out interface{}) error {
+	var resp graphQLResponse
+	if err := c.do(ctx, service, http.MethodPost, url, token, request, &resp, true); err != nil {
+		return err
+	}
+
+	if len(resp.Errors) > 0 {
+		messages := make([]string, 0, len(resp.Errors))
+		for _, e := range resp.Errors {
+			messages = append(messages, e.Message)
+		}
+		if len(resp.Data) == 0 || string(resp.Data) == "null" {
+			return &GraphQLError{Service: service, Messages: messages}
+		}
+		log.Error().Strs("errors", messages).Str("service", string(service)).Msg("Partial errors in GraphQL response")
+	}
+
+	if err := json.Unmarshal(resp.Data, out); err != nil {
+		return errors.Wrapf(err, "error parsing data from %s", service)
+	}
+
+	return nil
+}
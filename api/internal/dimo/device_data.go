@@ -0,0 +1,23 @@
+package dimo
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// RawDeviceStatus is the status-raw payload: signal name to an object holding value, timestamp and source.
+// The set of signals depends on the device, so it is kept as a generic map.
+type RawDeviceStatus map[string]interface{}
+
+// DeviceStatus fetches the latest raw signals reported for a vehicle.
+func (c *Client) DeviceStatus(ctx context.Context, privilegeToken string, tokenID int64) (RawDeviceStatus, error) {
+	url := fmt.Sprintf("%s/vehicle/%d/status-raw", c.settings.DeviceDataAPIURL, tokenID)
+
+	var status RawDeviceStatus
+	if err := c.do(ctx, DeviceDataAPI, http.MethodGet, url, privilegeToken, nil, &status, true); err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
@@ -0,0 +1,189 @@
+package dimo
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/dimo-network/trips-web-app/api/internal/eth"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// vehiclesPageSize is the largest page the Identity API serves.
+	vehiclesPageSize = 100
+	// maxVehiclePages bounds how many pages one listing follows, so a runaway cursor can't loop forever.
+	maxVehiclePages = 50
+)
+
+type Vehicle struct {
+	TokenID  int64  `json:"tokenId"`
+	Name     string `json:"name"`
+	Earnings struct {
+		TotalTokens string `json:"totalTokens"`
+	} `json:"earnings"`
+	Definition struct {
+		Make  string `json:"make"`
+		Model string `json:"model"`
+		Year  int    `json:"year"`
+	} `json:"definition"`
+	AftermarketDevice struct {
+		Address      string `json:"address"`
+		Serial       string `json:"serial"`
+		Manufacturer struct {
+			Name string `json:"name"`
+		} `json:"manufacturer"`
+	} `json:"aftermarketDevice"`
+}
+
+// vehicleFields is the selection shared by the owned and shared vehicle queries.
+const vehicleFields = `
+                tokenId,
+                name,
+                earnings {
+                    totalTokens
+                },
+                definition {
+                    make,
+                    model,
+                    year
+                },
+                aftermarketDevice {
+                    address,
+                    serial,
+                    manufacturer {
+                        name
+                    }
+                }`
+
+// OwnedVehicles lists the vehicles owned by ethAddress.
+func (c *Client) OwnedVehicles(ctx context.Context, ethAddress string) ([]Vehicle, error) {
+	owner, err := eth.ParseAddress(ethAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.vehicles(ctx, "OwnedVehicles", "owner", owner)
+}
+
+// SharedVehicles lists the vehicles other owners have granted ethAddress privileges on.
+func (c *Client) SharedVehicles(ctx context.Context, ethAddress string) ([]Vehicle, error) {
+	grantee, err := eth.ParseAddress(ethAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.vehicles(ctx, "SharedVehicles", "privileged", grantee)
+}
+
+// vehicles follows the Identity API's cursors until every vehicle matching filterBy { filter: address } is fetched.
+func (c *Client) vehicles(ctx context.Context, operation, filter, address string) ([]Vehicle, error) {
+	var vehicles []Vehicle
+	var after *string
+
+	for page := 0; page < maxVehiclePages; page++ {
+		request := newQuery(operation).
+			addressVar("address", address).
+			intVar("first", vehiclesPageSize).
+			optionalStringVar("after", after).
+			build(`
+        vehicles(first: $first, after: $after, filterBy: { ` + filter + `: $address }) {
+            nodes {` + vehicleFields + `
+            }
+            pageInfo {
+                hasNextPage
+                endCursor
+            }
+        }
+    `)
+
+		var data struct {
+			Vehicles struct {
+				Nodes    []Vehicle `json:"nodes"`
+				PageInfo struct {
+					HasNextPage bool   `json:"hasNextPage"`
+					EndCursor   string `json:"endCursor"`
+				} `json:"pageInfo"`
+			} `json:"vehicles"`
+		}
+		if err := c.graphQL(ctx, IdentityAPI, c.settings.IdentityAPIURL, "", request, &data); err != nil {
+			return nil, err
+		}
+
+		vehicles = append(vehicles, data.Vehicles.Nodes...)
+
+		pageInfo := data.Vehicles.PageInfo
+		if !pageInfo.HasNextPage || pageInfo.EndCursor == "" {
+			if vehicles == nil {
+				vehicles = []Vehicle{}
+			}
+			return vehicles, nil
+		}
+		after = &pageInfo.EndCursor
+	}
+
+	log.Warn().Str("operation", operation).Int("vehicles", len(vehicles)).Msg("Stopped following vehicle cursors at the page limit")
+	return vehicles, nil
+}
+
+// VehicleAccess is what the Identity API knows about who may use a vehicle: its owner and the privileges it has
+// granted to one particular user.
+type VehicleAccess struct {
+	Owner      string
+	Privileges []Privilege
+}
+
+type Privilege struct {
+	ID        int64     `json:"id"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Allows reports whether ethAddress owns the vehicle or holds an unexpired grant of privilege on it.
+func (a *VehicleAccess) Allows(ethAddress string, privilege int64) bool {
+	if strings.EqualFold(a.Owner, ethAddress) {
+		return true
+	}
+	for _, p := range a.Privileges {
+		if p.ID == privilege && p.ExpiresAt.After(time.Now()) {
+			return true
+		}
+	}
+	return false
+}
+
+// VehicleAccess looks up the owner of tokenID and the privileges granted on it to ethAddress.
+func (c *Client) VehicleAccess(ctx context.Context, tokenID int64, ethAddress string) (*VehicleAccess, error) {
+	user, err := eth.ParseAddress(ethAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	request := newQuery("VehicleAccess").
+		intVar("tokenId", tokenID).
+		addressVar("user", user).
+		build(`
+        vehicle(tokenId: $tokenId) {
+            owner
+            privileges(first: 100, filterBy: { user: $user }) {
+                nodes {
+                    id
+                    expiresAt
+                }
+            }
+        }
+    `)
+
+	var data struct {
+		Vehicle struct {
+			Owner      string `json:"owner"`
+			Privileges struct {
+				Nodes []Privilege `json:"nodes"`
+			} `json:"privileges"`
+		} `json:"vehicle"`
+	}
+	if err := c.graphQL(ctx, IdentityAPI, c.settings.IdentityAPIURL, "", request, &data); err != nil {
+		return nil, err
+	}
+
+	return &VehicleAccess{Owner: data.Vehicle.Owner, Privileges: data.Vehicle.Privileges.Nodes}, nil
+}
@@ -0,0 +1,67 @@
+package dimo
+
+import (
+	"strings"
+	"time"
+)
+
+// queryBuilder assembles a named GraphQL operation whose inputs are all passed as variables, so values never
+// end up in the query text. Each setter declares the variable with the GraphQL type the upstream schema expects.
+type queryBuilder struct {
+	operation    string
+	declarations []string
+	variables    map[string]interface{}
+}
+
+func newQuery(operation string) *queryBuilder {
+	return &queryBuilder{operation: operation, variables: map[string]interface{}{}}
+}
+
+func (q *queryBuilder) variable(name, graphQLType string, value interface{}) *queryBuilder {
+	q.declarations = append(q.declarations, "$"+name+": "+graphQLType)
+	q.variables[name] = value
+	return q
+}
+
+// addressVar declares an Identity API Address variable. The value must already have been through eth.ParseAddress.
+func (q *queryBuilder) addressVar(name, address string) *queryBuilder {
+	return q.variable(name, "Address!", address)
+}
+
+func (q *queryBuilder) intVar(name string, value int64) *queryBuilder {
+	return q.variable(name, "Int!", value)
+}
+
+func (q *queryBuilder) stringVar(name, value string) *queryBuilder {
+	return q.variable(name, "String!", value)
+}
+
+// optionalStringVar declares a nullable String variable; a nil value is sent as null.
+func (q *queryBuilder) optionalStringVar(name string, value *string) *queryBuilder {
+	if value == nil {
+		return q.variable(name, "String", nil)
+	}
+	return q.variable(name, "String", *value)
+}
+
+// timeVar declares a Telemetry API Time variable, sent as RFC 3339 in UTC.
+func (q *queryBuilder) timeVar(name string, value time.Time) *queryBuilder {
+	return q.variable(name, "Time!", value.UTC().Format(time.RFC3339Nano))
+}
+
+// build wraps selection, which refers to the declared variables as $name, in the operation.
+func (q *queryBuilder) build(selection string) GraphQLRequest {
+	var sb strings.Builder
+	sb.WriteString("query ")
+	sb.WriteString(q.operation)
+	if len(q.declarations) > 0 {
+		sb.WriteString("(")
+		sb.WriteString(strings.Join(q.declarations, ", "))
+		sb.WriteString(")")
+	}
+	sb.WriteString(" {")
+	sb.WriteString(selection)
+	sb.WriteString("}")
+
+	return GraphQLRequest{Query: sb.String(), Variables: q.variables}
+}
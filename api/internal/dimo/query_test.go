@@ -0,0 +1,83 @@
+package dimo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dimo-network/trips-web-app/api/internal/config"
+	"github.com/dimo-network/trips-web-app/api/internal/eth"
+	"github.com/pkg/errors"
+)
+
+func TestQueryBuilderDeclaresVariables(t *testing.T) {
+	after := "cursor"
+	request := newQuery("Example").
+		addressVar("owner", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed").
+		intVar("first", 50).
+		optionalStringVar("after", &after).
+		optionalStringVar("before", nil).
+		timeVar("from", time.Date(2024, 3, 1, 12, 0, 0, 0, time.FixedZone("CET", 3600))).
+		build(` vehicles(first: $first) { id } `)
+
+	wantQuery := "query Example($owner: Address!, $first: Int!, $after: String, $before: String, $from: Time!) { vehicles(first: $first) { id } }"
+	if request.Query != wantQuery {
+		t.Errorf("Query = %q, want %q", request.Query, wantQuery)
+	}
+	wantVariables := map[string]interface{}{
+		"owner":  "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+		"first":  int64(50),
+		"after":  "cursor",
+		"before": nil,
+		"from":   "2024-03-01T11:00:00Z",
+	}
+	for name, want := range wantVariables {
+		if got, ok := request.Variables[name]; !ok || got != want {
+			t.Errorf("variable %s = %#v, want %#v", name, got, want)
+		}
+	}
+}
+
+func TestIdentityQueriesSendAddressesAsVariables(t *testing.T) {
+	// a value crafted to break out of the filter if it were ever pasted into the query text
+	const injection = `0x0000000000000000000000000000000000000000" }) { nodes { owner } } #`
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		var request GraphQLRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Error(err)
+		}
+		if strings.Contains(strings.ToLower(request.Query), "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed") {
+			t.Errorf("address interpolated into the query: %s", request.Query)
+		}
+		if got := request.Variables["address"]; got != "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed" {
+			t.Errorf("address variable = %v, want the checksummed address", got)
+		}
+		_, _ = w.Write([]byte(`{"data":{"vehicles":{"nodes":[],"pageInfo":{"hasNextPage":false}}}}`))
+	}))
+	defer server.Close()
+
+	c := newTestClient(&config.Settings{IdentityAPIURL: server.URL})
+	if _, err := c.OwnedVehicles(context.Background(), "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"); err != nil {
+		t.Fatalf("OwnedVehicles() error = %v", err)
+	}
+
+	for _, address := range []string{injection, "0x5AAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", "not an address"} {
+		if _, err := c.SharedVehicles(context.Background(), address); !errors.Is(err, eth.ErrInvalidAddress) {
+			t.Errorf("SharedVehicles(%q) error = %v, want ErrInvalidAddress", address, err)
+		}
+		if _, err := c.VehicleAccess(context.Background(), 1, address); !errors.Is(err, eth.ErrInvalidAddress) {
+			t.Errorf("VehicleAccess(%q) error = %v, want ErrInvalidAddress", address, err)
+		}
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("Identity API called %d times, want only the valid request", got)
+	}
+}
@@ -0,0 +1,98 @@
+package dimo
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultMaxRetries       = 2
+	defaultRetryBaseDelay   = 200 * time.Millisecond
+	defaultRetryMaxDelay    = 2 * time.Second
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+)
+
+// backoff returns how long to wait before retry number attempt (starting at 1), using full jitter so that
+// replicas retrying the same outage don't hit the upstream in lockstep.
+func (c *Client) backoff(attempt int) time.Duration {
+	ceiling := c.retryBaseDelay << (attempt - 1)
+	if ceiling <= 0 || ceiling > c.retryMaxDelay {
+		ceiling = c.retryMaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// retryAfter honours a Retry-After header given in seconds, capped at the maximum backoff.
+func (c *Client) retryAfter(err error) (time.Duration, bool) {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.RetryAfter == "" {
+		return 0, false
+	}
+	seconds, convErr := strconv.Atoi(apiErr.RetryAfter)
+	if convErr != nil || seconds < 0 {
+		return 0, false
+	}
+	delay := time.Duration(seconds) * time.Second
+	if delay > c.retryMaxDelay {
+		delay = c.retryMaxDelay
+	}
+	return delay, true
+}
+
+// isTransient reports whether err is worth retrying: network failures, timeouts of a single attempt,
+// rate limiting and server-side errors. Anything caused by the caller, including a cancelled context, is not.
+func isTransient(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		default:
+			return apiErr.StatusCode >= 500
+		}
+	}
+
+	var gqlErr *GraphQLError
+	var decodeErr *decodeError
+	if errors.As(err, &gqlErr) || errors.As(err, &decodeErr) {
+		return false
+	}
+
+	// transport errors: connection refused or reset, DNS failures, client timeouts
+	return true
+}
+
+// countsAsFailure reports whether err says something about the upstream's health. Client errors such as
+// an expired privilege token or an unknown vehicle do not trip the breaker.
+func countsAsFailure(ctx context.Context, err error) bool {
+	if err == nil {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= 500
+	}
+	return isTransient(ctx, err)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
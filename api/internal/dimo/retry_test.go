@@ -0,0 +1,199 @@
+package dimo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dimo-network/trips-web-app/api/internal/config"
+	"github.com/pkg/errors"
+)
+
+// flakyServer answers with the queued statuses in order and 200 once they run out, counting every request.
+type flakyServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	delay    time.Duration
+	calls    int
+}
+
+func newFlakyServer(t *testing.T, statuses ...int) *flakyServer {
+	t.Helper()
+	fs := &flakyServer{statuses: statuses}
+	fs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fs.mu.Lock()
+		fs.calls++
+		status, delay := http.StatusOK, time.Duration(0)
+		if len(fs.statuses) > 0 {
+			status, fs.statuses = fs.statuses[0], fs.statuses[1:]
+			// only failing responses are slow, so a timeout test can recover on the next attempt
+			delay = fs.delay
+		}
+		fs.mu.Unlock()
+
+		if delay > 0 {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(delay):
+			}
+		}
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "0")
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	t.Cleanup(fs.Close)
+	return fs
+}
+
+func (fs *flakyServer) callCount() int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.calls
+}
+
+func newTestClient(settings *config.Settings) *Client {
+	c := NewClient(settings)
+	c.retryBaseDelay = time.Millisecond
+	c.retryMaxDelay = 5 * time.Millisecond
+	return c
+}
+
+type okResponse struct {
+	OK bool `json:"ok"`
+}
+
+func TestDoRetriesTransientFailures(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+	}{
+		{name: "server errors", statuses: []int{http.StatusInternalServerError, http.StatusBadGateway}},
+		{name: "unavailable", statuses: []int{http.StatusServiceUnavailable, http.StatusGatewayTimeout}},
+		{name: "rate limited", statuses: []int{http.StatusTooManyRequests, http.StatusTooManyRequests}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFlakyServer(t, tt.statuses...)
+			c := newTestClient(&config.Settings{})
+
+			var out okResponse
+			if err := c.do(context.Background(), TripsAPI, http.MethodGet, server.URL, "", nil, &out, true); err != nil {
+				t.Fatalf("do() error = %v", err)
+			}
+			if !out.OK {
+				t.Error("response was not decoded")
+			}
+			if got, want := server.callCount(), len(tt.statuses)+1; got != want {
+				t.Errorf("server called %d times, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestDoRetriesTimeouts(t *testing.T) {
+	server := newFlakyServer(t, http.StatusOK)
+	server.delay = time.Second
+	c := newTestClient(&config.Settings{})
+	c.httpClient.Timeout = 50 * time.Millisecond
+
+	var out okResponse
+	if err := c.do(context.Background(), TripsAPI, http.MethodGet, server.URL, "", nil, &out, true); err != nil {
+		t.Fatalf("do() error = %v", err)
+	}
+	if got := server.callCount(); got != 2 {
+		t.Errorf("server called %d times, want 2", got)
+	}
+}
+
+func TestDoGivesUpAfterMaxRetries(t *testing.T) {
+	server := newFlakyServer(t, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	c := newTestClient(&config.Settings{APIMaxRetries: 2})
+
+	err := c.do(context.Background(), TripsAPI, http.MethodGet, server.URL, "", nil, &okResponse{}, true)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("do() error = %v, want the upstream's 503", err)
+	}
+	if got := server.callCount(); got != 3 {
+		t.Errorf("server called %d times, want 3", got)
+	}
+}
+
+func TestDoDoesNotRetry(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		idempotent bool
+	}{
+		{name: "bad request", status: http.StatusBadRequest, idempotent: true},
+		{name: "unauthorized", status: http.StatusUnauthorized, idempotent: true},
+		{name: "not found", status: http.StatusNotFound, idempotent: true},
+		{name: "non-idempotent server error", status: http.StatusServiceUnavailable, idempotent: false},
+		{name: "non-idempotent rate limit", status: http.StatusTooManyRequests, idempotent: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFlakyServer(t, tt.status)
+			c := newTestClient(&config.Settings{})
+
+			err := c.do(context.Background(), TripsAPI, http.MethodPost, server.URL, "", map[string]string{}, &okResponse{}, tt.idempotent)
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.status {
+				t.Fatalf("do() error = %v, want status %d", err, tt.status)
+			}
+			if got := server.callCount(); got != 1 {
+				t.Errorf("server called %d times, want 1", got)
+			}
+		})
+	}
+}
+
+func TestDoStopsRetryingWhenCancelled(t *testing.T) {
+	server := newFlakyServer(t, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	c := newTestClient(&config.Settings{})
+	c.retryBaseDelay, c.retryMaxDelay = time.Second, time.Second
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.do(ctx, TripsAPI, http.MethodGet, server.URL, "", nil, &okResponse{}, true); err == nil {
+		t.Fatal("do() succeeded, want error")
+	}
+	if got := server.callCount(); got != 1 {
+		t.Errorf("server called %d times, want 1", got)
+	}
+}
+
+func TestBackoffGrowsUpToMax(t *testing.T) {
+	c := NewClient(&config.Settings{})
+	c.retryBaseDelay = 100 * time.Millisecond
+	c.retryMaxDelay = 300 * time.Millisecond
+
+	ceilings := map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 300 * time.Millisecond, 10: 300 * time.Millisecond}
+	for attempt, ceiling := range ceilings {
+		for i := 0; i < 100; i++ {
+			if got := c.backoff(attempt); got < 0 || got > ceiling {
+				t.Fatalf("backoff(%d) = %s, want within [0, %s]", attempt, got, ceiling)
+			}
+		}
+	}
+}
+
+func TestRetryAfterIsCapped(t *testing.T) {
+	c := newTestClient(&config.Settings{})
+
+	delay, ok := c.retryAfter(&APIError{StatusCode: http.StatusTooManyRequests, RetryAfter: "120"})
+	if !ok || delay != c.retryMaxDelay {
+		t.Errorf("retryAfter() = %s, %v, want %s, true", delay, ok, c.retryMaxDelay)
+	}
+	if _, ok := c.retryAfter(&APIError{StatusCode: http.StatusTooManyRequests, RetryAfter: "Wed, 21 Oct 2015 07:28:00 GMT"}); ok {
+		t.Error("retryAfter() accepted an HTTP date")
+	}
+}
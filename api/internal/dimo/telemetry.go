@@ -0,0 +1,119 @@
+package dimo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ExtraSignals are the optional signals a SignalsQuery can ask for on top of location and speed, keyed by the
+// name they come back under in Signal.Values.
+var ExtraSignals = map[string]string{
+	"fuelLevel":     "powertrainFuelSystemRelativeLevel(agg: AVG)",
+	"stateOfCharge": "powertrainTractionBatteryStateOfChargeCurrent(agg: AVG)",
+	"odometer":      "powertrainTransmissionTravelledDistance(agg: MAX)",
+	"engineRpm":     "powertrainCombustionEngineSpeed(agg: AVG)",
+}
+
+type Signal struct {
+	Timestamp                time.Time `json:"timestamp"`
+	CurrentLocationLongitude *float64  `json:"currentLocationLongitude"`
+	CurrentLocationLatitude  *float64  `json:"currentLocationLatitude"`
+	Speed                    *float64  `json:"speed"`
+	// Values holds the ExtraSignals that were asked for and had data in this interval.
+	Values map[string]float64 `json:"-"`
+}
+
+// UnmarshalJSON decodes the fixed signals and collects any ExtraSignals into Values.
+func (s *Signal) UnmarshalJSON(data []byte) error {
+	type fixed Signal
+	if err := json.Unmarshal(data, (*fixed)(s)); err != nil {
+		return err
+	}
+
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+	for name := range ExtraSignals {
+		raw, ok := all[name]
+		if !ok {
+			continue
+		}
+		var value *float64
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return fmt.Errorf("error decoding signal %s: %w", name, err)
+		}
+		if value != nil {
+			if s.Values == nil {
+				s.Values = map[string]float64{}
+			}
+			s.Values[name] = *value
+		}
+	}
+	return nil
+}
+
+// SignalsQuery selects the aggregated signals for one vehicle over a time range.
+type SignalsQuery struct {
+	TokenID  int64
+	Interval string
+	From     time.Time
+	To       time.Time
+	// Extra names ExtraSignals to fetch as well.
+	Extra []string
+	// AverageSpeed averages the speed over each interval instead of taking its peak, which follows changes of
+	// speed more closely.
+	AverageSpeed bool
+}
+
+// Signals fetches aggregated location and speed signals, plus any extra signals asked for, from the Telemetry API.
+func (c *Client) Signals(ctx context.Context, privilegeToken string, query SignalsQuery) ([]Signal, error) {
+	// field names can't be sent as variables, so only the fixed selections in ExtraSignals make it into the query
+	extra := make([]string, 0, len(query.Extra))
+	for _, name := range query.Extra {
+		selection, ok := ExtraSignals[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown signal %q", name)
+		}
+		extra = append(extra, name+": "+selection)
+	}
+	sort.Strings(extra)
+
+	speedAggregation := "MAX"
+	if query.AverageSpeed {
+		speedAggregation = "AVG"
+	}
+
+	request := newQuery("Signals").
+		intVar("tokenId", query.TokenID).
+		stringVar("interval", query.Interval).
+		timeVar("from", query.From).
+		timeVar("to", query.To).
+		build(`
+	  signals(
+		tokenId: $tokenId
+		interval: $interval
+		from: $from
+		to: $to
+	  ) {
+		timestamp
+		speed(agg: ` + speedAggregation + `)
+		currentLocationLatitude(agg: AVG)
+		currentLocationLongitude(agg: AVG)
+		` + strings.Join(extra, "\n\t\t") + `
+	  }
+	`)
+
+	var data struct {
+		Signals []Signal `json:"signals"`
+	}
+	if err := c.graphQL(ctx, TelemetryAPI, c.settings.TelemetryAPIURL, privilegeToken, request, &data); err != nil {
+		return nil, err
+	}
+
+	return data.Signals, nil
+}
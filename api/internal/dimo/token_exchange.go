@@ -0,0 +1,36 @@
+package dimo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+type TokenExchangeRequest struct {
+	NFTContractAddress string  `json:"nftContractAddress"`
+	Privileges         []int64 `json:"privileges"`
+	TokenID            int64   `json:"tokenID"`
+}
+
+type TokenExchangeResponse struct {
+	Token string `json:"token"`
+}
+
+// ExchangeToken trades the user's id_token for a privilege token scoped to one vehicle.
+func (c *Client) ExchangeToken(ctx context.Context, userToken string, tokenID int64, privileges []int64) (string, error) {
+	request := TokenExchangeRequest{
+		NFTContractAddress: c.settings.PrivilegeNFTContractAddr,
+		Privileges:         privileges,
+		TokenID:            tokenID,
+	}
+
+	var resp TokenExchangeResponse
+	if err := c.do(ctx, TokenExchangeAPI, http.MethodPost, c.settings.TokenExchangeAPIURL, userToken, request, &resp, false); err != nil {
+		return "", err
+	}
+	if resp.Token == "" {
+		return "", errors.New("token not found in response from token exchange API")
+	}
+
+	return resp.Token, nil
+}
@@ -0,0 +1,74 @@
+package dimo
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+)
+
+type Trip struct {
+	ID    string    `json:"id"`
+	Start TripPoint `json:"start"`
+	End   TripPoint `json:"end"`
+}
+
+// TripPoint is one end of a trip. EstimatedLocation is set when the Trips API had to guess the location.
+type TripPoint struct {
+	Time              string  `json:"time"`
+	Location          LatLon  `json:"location"`
+	EstimatedLocation *LatLon `json:"estimatedLocation"`
+}
+
+// LatLon represents latitude and longitude coordinates.
+type LatLon struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// TripsResponse is one page of a vehicle's trips. The Trips API pages by number.
+type TripsResponse struct {
+	Trips       []Trip `json:"trips"`
+	CurrentPage int    `json:"currentPage"`
+	TotalPages  int    `json:"totalPages"`
+}
+
+// maxTripPages bounds how many Trips API pages AllTrips walks for one vehicle.
+const maxTripPages = 100
+
+// Trips fetches one page (1-based) of the trips recorded for a vehicle, limit trips long, or the Trips API's default
+// page size when limit is 0. privilegeToken must grant access to tokenID.
+func (c *Client) Trips(ctx context.Context, privilegeToken string, tokenID int64, page, limit int) (*TripsResponse, error) {
+	url := fmt.Sprintf("%s/vehicle/%d/trips?page=%d", c.settings.TripsAPIBaseURL, tokenID, page)
+	if limit > 0 {
+		url += fmt.Sprintf("&limit=%d", limit)
+	}
+
+	var resp TripsResponse
+	if err := c.do(ctx, TripsAPI, http.MethodGet, url, privilegeToken, nil, &resp, true); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// AllTrips walks every page of a vehicle's trips. The Trips API has no date filters, so a full history is the only
+// way to filter and count trips by date. truncated is set when the history ran past maxTripPages and the rest
+// was left out.
+func (c *Client) AllTrips(ctx context.Context, privilegeToken string, tokenID int64) (trips []Trip, truncated bool, err error) {
+	for page := 1; page <= maxTripPages; page++ {
+		resp, err := c.Trips(ctx, privilegeToken, tokenID, page, 0)
+		if err != nil {
+			return nil, false, err
+		}
+		trips = append(trips, resp.Trips...)
+
+		if len(resp.Trips) == 0 || page >= resp.TotalPages {
+			return trips, false, nil
+		}
+	}
+
+	log.Warn().Int64("tokenId", tokenID).Int("trips", len(trips)).Msg("Stopped walking trip pages at the page limit")
+	return trips, true, nil
+}
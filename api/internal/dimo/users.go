@@ -0,0 +1,22 @@
+package dimo
+
+import (
+	"context"
+	"net/http"
+)
+
+type User struct {
+	Email struct {
+		Address string `json:"address"`
+	} `json:"email"`
+}
+
+// User fetches the profile of the user the token belongs to.
+func (c *Client) User(ctx context.Context, userToken string) (*User, error) {
+	var user User
+	if err := c.do(ctx, UsersAPI, http.MethodGet, c.settings.UsersAPIBaseURL+"/user", userToken, nil, &user, true); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
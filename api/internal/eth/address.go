@@ -0,0 +1,58 @@
+// Package eth holds the small pieces of Ethereum tooling the app needs without pulling in go-ethereum.
+package eth
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidAddress is matched by errors.Is for every address that fails ParseAddress.
+var ErrInvalidAddress = errors.New("invalid ethereum address")
+
+// ParseAddress validates a 0x-prefixed, 20-byte hex address and returns it in EIP-55 checksum form.
+// Mixed-case input must carry a correct checksum; all-lowercase or all-uppercase input has none to check.
+func ParseAddress(s string) (string, error) {
+	if len(s) != 42 || !strings.HasPrefix(s, "0x") {
+		return "", fmt.Errorf("%w: %q must be 0x followed by 40 hex characters", ErrInvalidAddress, s)
+	}
+
+	body := s[2:]
+	if _, err := hex.DecodeString(body); err != nil {
+		return "", fmt.Errorf("%w: %q is not hex", ErrInvalidAddress, s)
+	}
+
+	checksummed := ChecksumAddress(body)
+	lower, upper := strings.ToLower(body), strings.ToUpper(body)
+	if body != lower && body != upper && "0x"+body != checksummed {
+		return "", fmt.Errorf("%w: %q has a bad checksum", ErrInvalidAddress, s)
+	}
+
+	return checksummed, nil
+}
+
+// ChecksumAddress applies EIP-55 mixed-case encoding to 40 hex characters, with or without a 0x prefix.
+// It does not validate its input; use ParseAddress for that.
+func ChecksumAddress(s string) string {
+	body := strings.ToLower(strings.TrimPrefix(s, "0x"))
+	hash := Keccak256([]byte(body))
+
+	out := []byte(body)
+	for i, ch := range out {
+		if ch < 'a' || ch > 'f' {
+			continue
+		}
+		// each hex character is checked against the matching nibble of the hash
+		nibble := hash[i/2]
+		if i%2 == 0 {
+			nibble >>= 4
+		}
+		if nibble&0x0f >= 8 {
+			out[i] = ch - 'a' + 'A'
+		}
+	}
+
+	return "0x" + string(out)
+}
@@ -0,0 +1,63 @@
+package eth
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		// checksummed examples from EIP-55
+		{name: "valid checksum", input: "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", want: "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"},
+		{name: "valid checksum with digits", input: "0xD1220A0cf47c7B9Be7A2E6BA89F429762e7b9aDb", want: "0xD1220A0cf47c7B9Be7A2E6BA89F429762e7b9aDb"},
+		{name: "all lowercase", input: "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", want: "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"},
+		{name: "all uppercase", input: "0x5AAEB6053F3E94C9B9A09F33669435E7EF1BEAED", want: "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"},
+		{name: "invalid mixed case", input: "0x5AAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", wantErr: true},
+		{name: "too short", input: "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAe", wantErr: true},
+		{name: "too long", input: "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed0", wantErr: true},
+		{name: "missing 0x prefix", input: "5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed00", wantErr: true},
+		{name: "not hex", input: "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeg", wantErr: true},
+		{name: "empty", input: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAddress(tt.input)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidAddress) {
+					t.Errorf("ParseAddress(%q) error = %v, want ErrInvalidAddress", tt.input, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseAddress(%q) error = %v", tt.input, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseAddress(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChecksumAddress(t *testing.T) {
+	for _, want := range []string{
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+		"0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359",
+		"0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB",
+		"0xD1220A0cf47c7B9Be7A2E6BA89F429762e7b9aDb",
+		"0x52908400098527886E0F7030069857D2E4169EE7",
+		"0xde709f2102306220921060314715629080e2fb77",
+	} {
+		// with and without the prefix
+		if got := ChecksumAddress(want); got != want {
+			t.Errorf("ChecksumAddress(%q) = %q", want, got)
+		}
+		if got := ChecksumAddress(want[2:]); got != want {
+			t.Errorf("ChecksumAddress(%q) = %q, want %q", want[2:], got, want)
+		}
+	}
+}
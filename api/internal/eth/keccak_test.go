@@ -0,0 +1,32 @@
+package eth
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestKeccak256(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "empty", input: "", want: "c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470"},
+		{name: "abc", input: "abc", want: "4e03657aea45a94fc7d47ba826c8d667c0d1e6e33a64a036ec44f58fa12d6c45"},
+		{name: "sentence", input: "The quick brown fox jumps over the lazy dog", want: "4d741b6f1eb29cb2a9b9911c82f56fa8d73b04959d3d9d222895df6c0b28aa15"},
+		// either side of the 136 byte block boundary, where the padding lands in the same or a new block
+		{name: "one short of a block", input: strings.Repeat("a", 135), want: "34367dc248bbd832f4e3e69dfaac2f92638bd0bbd18f2912ba4ef454919cf446"},
+		{name: "exactly a block", input: strings.Repeat("a", 136), want: "a6c4d403279fe3e0af03729caada8374b5ca54d8065329a3ebcaeb4b60aa386e"},
+		{name: "one over a block", input: strings.Repeat("a", 137), want: "d869f639c7046b4929fc92a4d988a8b22c55fbadb802c0c66ebcd484f1915f39"},
+		{name: "several blocks", input: strings.Repeat("a", 300), want: "5b7e0e47a96f32a88b4f14ca177982790807c40e1a105742ba0fc1babe1ef826"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			digest := Keccak256([]byte(tt.input))
+			if got := hex.EncodeToString(digest[:]); got != tt.want {
+				t.Errorf("Keccak256() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
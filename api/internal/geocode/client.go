@@ -0,0 +1,195 @@
+// Package geocode turns coordinates into short place names through a reverse geocoding service, such as
+// Nominatim or a hosted API that speaks the same protocol.
+package geocode
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultTimeout = 15 * time.Second
+	// userAgent identifies the app, as Nominatim's usage policy asks.
+	userAgent = "dimo-trips-web-app"
+	// zoom is the detail Nominatim resolves to; 17 is a major or minor street.
+	zoom = "17"
+)
+
+// Provider looks up the place at a position. An empty name with no error means there is nothing there to name,
+// such as open sea.
+type Provider interface {
+	Reverse(ctx context.Context, latitude, longitude float64) (string, error)
+}
+
+// Error is returned when the geocoding service fails a request.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("geocoding responded with status %d: %s", e.StatusCode, e.Message)
+}
+
+// Client is a Provider backed by a Nominatim-compatible /reverse endpoint.
+type Client struct {
+	httpClient *http.Client
+	serviceURL *url.URL
+
+	// requests are spaced at least interval apart; public Nominatim allows one a second
+	interval time.Duration
+	mu       sync.Mutex
+	next     time.Time
+}
+
+// NewClient returns a client for the reverse endpoint at serviceURL, for example
+// https://nominatim.openstreetmap.org/reverse. Any query params on it, such as an API key, are sent with every
+// request. At most ratePerSecond requests are made a second; zero or less means no limit.
+func NewClient(serviceURL string, timeout time.Duration, ratePerSecond int) (*Client, error) {
+	parsed, err := url.Parse(serviceURL)
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		return nil, errors.Errorf("invalid geocoding URL %q", serviceURL)
+	}
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	client := &Client{httpClient: &http.Client{Timeout: timeout}, serviceURL: parsed}
+	if ratePerSecond > 0 {
+		client.interval = time.Second / time.Duration(ratePerSecond)
+	}
+	return client, nil
+}
+
+type reverseResponse struct {
+	Error   string            `json:"error"`
+	Name    string            `json:"name"`
+	Display string            `json:"display_name"`
+	Address map[string]string `json:"address"`
+}
+
+// Reverse returns a short name for the place at a position, such as "Main Street, Springfield".
+func (c *Client) Reverse(ctx context.Context, latitude, longitude float64) (string, error) {
+	if err := c.wait(ctx); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.requestURL(latitude, longitude), nil)
+	if err != nil {
+		return "", errors.Wrap(err, "error creating geocoding request")
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		// the URL may carry an API key, so keep it out of the error
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return "", errors.Wrap(err, "error making geocoding request")
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", errors.Wrap(err, "error reading geocoding response")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", &Error{StatusCode: resp.StatusCode, Message: string(body)}
+	}
+
+	var parsed reverseResponse
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return "", &Error{StatusCode: resp.StatusCode, Message: string(body)}
+	}
+	// Nominatim answers 200 with an error, "Unable to geocode", where there is nothing to name
+	if parsed.Error != "" {
+		return "", nil
+	}
+
+	return shortName(parsed), nil
+}
+
+// wait blocks until the next request is allowed.
+func (c *Client) wait(ctx context.Context) error {
+	if c.interval == 0 {
+		return nil
+	}
+
+	c.mu.Lock()
+	now := time.Now()
+	slot := c.next
+	if slot.Before(now) {
+		slot = now
+	}
+	c.next = slot.Add(c.interval)
+	c.mu.Unlock()
+
+	timer := time.NewTimer(slot.Sub(now))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *Client) requestURL(latitude, longitude float64) string {
+	u := *c.serviceURL
+	params := u.Query()
+	params.Set("format", "jsonv2")
+	params.Set("lat", strconv.FormatFloat(latitude, 'f', -1, 64))
+	params.Set("lon", strconv.FormatFloat(longitude, 'f', -1, 64))
+	params.Set("zoom", zoom)
+	params.Set("addressdetails", "1")
+	u.RawQuery = params.Encode()
+	return u.String()
+}
+
+// shortName is the place itself, or else its street, and the town it is in, falling back to the start of the
+// full display name when the address has neither.
+func shortName(place reverseResponse) string {
+	first := place.Name
+	if first == "" {
+		first = firstOf(place.Address, "road", "pedestrian", "footway", "path")
+	}
+	town := firstOf(place.Address, "city", "town", "village", "hamlet", "municipality", "suburb", "county")
+
+	switch {
+	case first != "" && town != "" && first != town:
+		return first + ", " + town
+	case first != "":
+		return first
+	case town != "":
+		return town
+	}
+
+	display := strings.Split(place.Display, ",")
+	if len(display) > 2 {
+		display = display[:2]
+	}
+	for i := range display {
+		display[i] = strings.TrimSpace(display[i])
+	}
+	return strings.Join(display, ", ")
+}
+
+func firstOf(address map[string]string, keys ...string) string {
+	for _, key := range keys {
+		if value := address[key]; value != "" {
+			return value
+		}
+	}
+	return ""
+}
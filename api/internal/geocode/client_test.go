@@ -0,0 +1,171 @@
+package geocode
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// reverseServer records the requests it gets and answers each with status and body.
+type reverseServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []*http.Request
+	times    []time.Time
+}
+
+func newReverseServer(t *testing.T, status int, body string) *reverseServer {
+	t.Helper()
+	rs := &reverseServer{}
+	rs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rs.mu.Lock()
+		rs.requests = append(rs.requests, r)
+		rs.times = append(rs.times, time.Now())
+		rs.mu.Unlock()
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(rs.Close)
+	return rs
+}
+
+func (rs *reverseServer) recorded() []*http.Request {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return append([]*http.Request(nil), rs.requests...)
+}
+
+func newTestClient(t *testing.T, serviceURL string, ratePerSecond int) *Client {
+	t.Helper()
+	c, err := NewClient(serviceURL, time.Second, ratePerSecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestReverseNamesPlace(t *testing.T) {
+	server := newReverseServer(t, http.StatusOK, `{"name":"","display_name":"12, Main Street, Springfield, USA","address":{"road":"Main Street","city":"Springfield"}}`)
+	c := newTestClient(t, server.URL+"/reverse?key=secret", 0)
+
+	name, err := c.Reverse(context.Background(), 39.7817, -89.6501)
+	if err != nil {
+		t.Fatalf("Reverse() error = %v", err)
+	}
+	if name != "Main Street, Springfield" {
+		t.Errorf("Reverse() = %q, want Main Street, Springfield", name)
+	}
+
+	requests := server.recorded()
+	if len(requests) != 1 {
+		t.Fatalf("server got %d requests, want 1", len(requests))
+	}
+	if got := requests[0].Header.Get("User-Agent"); got != userAgent {
+		t.Errorf("User-Agent = %q, want %q", got, userAgent)
+	}
+	query := requests[0].URL.Query()
+	for param, want := range map[string]string{
+		"key":    "secret",
+		"format": "jsonv2",
+		"lat":    "39.7817",
+		"lon":    "-89.6501",
+		"zoom":   zoom,
+	} {
+		if got := query.Get(param); got != want {
+			t.Errorf("%s = %q, want %q", param, got, want)
+		}
+	}
+}
+
+func TestReverseWithNothingToName(t *testing.T) {
+	server := newReverseServer(t, http.StatusOK, `{"error":"Unable to geocode"}`)
+	c := newTestClient(t, server.URL+"/reverse", 0)
+
+	name, err := c.Reverse(context.Background(), 0.5, -30)
+	if err != nil {
+		t.Fatalf("Reverse() error = %v", err)
+	}
+	if name != "" {
+		t.Errorf("Reverse() = %q, want no name", name)
+	}
+}
+
+func TestReverseReturnsServiceErrors(t *testing.T) {
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusServiceUnavailable} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			server := newReverseServer(t, status, "try again later")
+			c := newTestClient(t, server.URL+"/reverse", 0)
+
+			_, err := c.Reverse(context.Background(), 52.5, 13.4)
+			var geoErr *Error
+			if !errors.As(err, &geoErr) {
+				t.Fatalf("Reverse() error = %v, want an *Error", err)
+			}
+			if geoErr.StatusCode != status || geoErr.Message != "try again later" {
+				t.Errorf("Reverse() error = %+v, want status %d", geoErr, status)
+			}
+		})
+	}
+}
+
+func TestReverseKeepsAPIKeyOutOfErrors(t *testing.T) {
+	server := newReverseServer(t, http.StatusOK, "{}")
+	server.Close()
+	c := newTestClient(t, server.URL+"/reverse?key=secret", 0)
+
+	_, err := c.Reverse(context.Background(), 52.5, 13.4)
+	if err == nil {
+		t.Fatal("Reverse() succeeded against a closed server")
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("Reverse() error %q leaks the API key", err)
+	}
+}
+
+func TestReverseSpacesRequests(t *testing.T) {
+	server := newReverseServer(t, http.StatusOK, `{"name":"Somewhere"}`)
+	c := newTestClient(t, server.URL+"/reverse", 20)
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.Reverse(context.Background(), 52.5, 13.4); err != nil {
+			t.Fatalf("Reverse() error = %v", err)
+		}
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	for i := 1; i < len(server.times); i++ {
+		// allow for timer slack; without the limit the requests would be microseconds apart
+		if gap := server.times[i].Sub(server.times[i-1]); gap < 40*time.Millisecond {
+			t.Errorf("requests %d and %d were %s apart, want about 50ms", i-1, i, gap)
+		}
+	}
+}
+
+func TestShortName(t *testing.T) {
+	tests := []struct {
+		name  string
+		place reverseResponse
+		want  string
+	}{
+		{name: "named place", place: reverseResponse{Name: "City Hall", Address: map[string]string{"city": "Springfield"}}, want: "City Hall, Springfield"},
+		{name: "street only", place: reverseResponse{Address: map[string]string{"road": "Main Street"}}, want: "Main Street"},
+		{name: "town only", place: reverseResponse{Address: map[string]string{"village": "Ogdenville"}}, want: "Ogdenville"},
+		{name: "name is the town", place: reverseResponse{Name: "Springfield", Address: map[string]string{"city": "Springfield"}}, want: "Springfield"},
+		{name: "display name fallback", place: reverseResponse{Display: "Lake Springfield, Sangamon County, Illinois"}, want: "Lake Springfield, Sangamon County"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shortName(tt.place); got != tt.want {
+				t.Errorf("shortName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
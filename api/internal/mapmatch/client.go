@@ -0,0 +1,175 @@
+// Package mapmatch snaps GPS tracks to the road network through an OSRM-compatible match service, such as a
+// local OSRM container or a hosted map matching API that speaks the same protocol.
+package mapmatch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxBatchSize is OSRM's default limit on coordinates per match request.
+	maxBatchSize   = 100
+	defaultTimeout = 15 * time.Second
+)
+
+// Point is one sample of a track, in WGS84 degrees. Time is optional; when every point has one they are sent
+// along to help the matcher.
+type Point struct {
+	Longitude float64
+	Latitude  float64
+	Time      time.Time
+}
+
+// Matching is a stretch of track snapped to the roads. Confidence is the matcher's, between 0 and 1; stretches that
+// couldn't be matched are returned as they came in, with a confidence of 0.
+type Matching struct {
+	Coordinates [][]float64
+	Confidence  float64
+}
+
+// Error is returned when the match service fails a request.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("map matching responded with status %d: %s %s", e.StatusCode, e.Code, e.Message)
+}
+
+type Client struct {
+	httpClient *http.Client
+	serviceURL *url.URL
+}
+
+// NewClient returns a client for the match service at serviceURL, which includes the profile, for example
+// http://localhost:5000/match/v1/driving. Any query params on it, such as an API key, are sent with every request.
+func NewClient(serviceURL string, timeout time.Duration) (*Client, error) {
+	parsed, err := url.Parse(serviceURL)
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		return nil, errors.Errorf("invalid map matching URL %q", serviceURL)
+	}
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return &Client{httpClient: &http.Client{Timeout: timeout}, serviceURL: parsed}, nil
+}
+
+// Match snaps points to the roads. Long tracks are sent in batches that overlap by one point, so the stretches
+// join up.
+func (c *Client) Match(ctx context.Context, points []Point) ([]Matching, error) {
+	var matchings []Matching
+	for start := 0; start < len(points)-1; start += maxBatchSize - 1 {
+		end := start + maxBatchSize
+		if end > len(points) {
+			end = len(points)
+		}
+
+		batch, err := c.matchBatch(ctx, points[start:end])
+		if err != nil {
+			return nil, err
+		}
+		matchings = append(matchings, batch...)
+	}
+	return matchings, nil
+}
+
+type matchResponse struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Matchings []struct {
+		Confidence float64 `json:"confidence"`
+		Geometry   struct {
+			Coordinates [][]float64 `json:"coordinates"`
+		} `json:"geometry"`
+	} `json:"matchings"`
+}
+
+func (c *Client) matchBatch(ctx context.Context, points []Point) ([]Matching, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.requestURL(points), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating map matching request")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		// the URL may carry an API key, so keep it out of the error
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, errors.Wrap(err, "error making map matching request")
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading map matching response")
+	}
+
+	var parsed matchResponse
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, &Error{StatusCode: resp.StatusCode, Message: string(body)}
+	}
+
+	switch {
+	case parsed.Code == "NoMatch" || parsed.Code == "NoSegment":
+		// nothing nearby to snap to, so keep what was driven
+		return []Matching{{Coordinates: coordinates(points)}}, nil
+	case resp.StatusCode != http.StatusOK || parsed.Code != "Ok":
+		return nil, &Error{StatusCode: resp.StatusCode, Code: parsed.Code, Message: parsed.Message}
+	}
+
+	matchings := make([]Matching, 0, len(parsed.Matchings))
+	for _, m := range parsed.Matchings {
+		matchings = append(matchings, Matching{Coordinates: m.Geometry.Coordinates, Confidence: m.Confidence})
+	}
+	return matchings, nil
+}
+
+// requestURL appends the batch's coordinates to the service URL and asks for full GeoJSON geometry.
+func (c *Client) requestURL(points []Point) string {
+	coords := make([]string, len(points))
+	timestamps := make([]string, len(points))
+	withTimes := true
+	for i, p := range points {
+		coords[i] = strconv.FormatFloat(p.Longitude, 'f', 6, 64) + "," + strconv.FormatFloat(p.Latitude, 'f', 6, 64)
+		// OSRM rejects timestamps that go backwards, so only send them when they're all there and in order
+		if p.Time.IsZero() || (i > 0 && p.Time.Before(points[i-1].Time)) {
+			withTimes = false
+		}
+		timestamps[i] = strconv.FormatInt(p.Time.Unix(), 10)
+	}
+
+	u := *c.serviceURL
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.Join(coords, ";")
+
+	params := u.Query()
+	params.Set("geometries", "geojson")
+	params.Set("overview", "full")
+	if withTimes {
+		params.Set("timestamps", strings.Join(timestamps, ";"))
+	}
+	u.RawQuery = params.Encode()
+
+	return u.String()
+}
+
+func coordinates(points []Point) [][]float64 {
+	coords := make([][]float64, len(points))
+	for i, p := range points {
+		coords[i] = []float64{p.Longitude, p.Latitude}
+	}
+	return coords
+}
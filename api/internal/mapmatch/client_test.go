@@ -0,0 +1,223 @@
+package mapmatch
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// matchServer records the requests it gets and answers each with respond.
+type matchServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+func newMatchServer(t *testing.T, respond func(w http.ResponseWriter, r *http.Request)) *matchServer {
+	t.Helper()
+	ms := &matchServer{}
+	ms.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ms.mu.Lock()
+		ms.requests = append(ms.requests, r)
+		ms.mu.Unlock()
+		respond(w, r)
+	}))
+	t.Cleanup(ms.Close)
+	return ms
+}
+
+func (ms *matchServer) recorded() []*http.Request {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return append([]*http.Request(nil), ms.requests...)
+}
+
+func newTestClient(t *testing.T, serviceURL string) *Client {
+	t.Helper()
+	c, err := NewClient(serviceURL, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// requestPoints reads the coordinates a match request was sent for back out of its path.
+func requestPoints(t *testing.T, r *http.Request) []string {
+	t.Helper()
+	_, coords, found := strings.Cut(r.URL.Path, "/match/v1/driving/")
+	if !found {
+		t.Fatalf("unexpected request path %q", r.URL.Path)
+	}
+	return strings.Split(coords, ";")
+}
+
+func testPoints(n int) []Point {
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	points := make([]Point, n)
+	for i := range points {
+		points[i] = Point{Longitude: 13.4 + float64(i)*0.001, Latitude: 52.5, Time: start.Add(time.Duration(i) * time.Second)}
+	}
+	return points
+}
+
+func TestMatchSnapsPoints(t *testing.T) {
+	server := newMatchServer(t, func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"code":"Ok","matchings":[{"confidence":0.9,"geometry":{"coordinates":[[13.4001,52.5001],[13.4011,52.5001],[13.4021,52.5001]]}}]}`))
+	})
+	c := newTestClient(t, server.URL+"/match/v1/driving?api_key=secret")
+
+	matchings, err := c.Match(context.Background(), testPoints(3))
+	if err != nil {
+		t.Fatalf("Match() error = %v", err)
+	}
+	if len(matchings) != 1 || matchings[0].Confidence != 0.9 || len(matchings[0].Coordinates) != 3 {
+		t.Fatalf("Match() = %+v, want the one snapped matching", matchings)
+	}
+	if matchings[0].Coordinates[0][0] != 13.4001 {
+		t.Errorf("first coordinate = %v, want the snapped one", matchings[0].Coordinates[0])
+	}
+
+	requests := server.recorded()
+	if len(requests) != 1 {
+		t.Fatalf("server got %d requests, want 1", len(requests))
+	}
+	query := requests[0].URL.Query()
+	for param, want := range map[string]string{
+		"api_key":    "secret",
+		"geometries": "geojson",
+		"overview":   "full",
+		"timestamps": "1714550400;1714550401;1714550402",
+	} {
+		if got := query.Get(param); got != want {
+			t.Errorf("%s = %q, want %q", param, got, want)
+		}
+	}
+	if got := requestPoints(t, requests[0]); got[0] != "13.400000,52.500000" {
+		t.Errorf("first point sent = %q, want longitude first", got[0])
+	}
+}
+
+func TestMatchFallsBackToRawPointsWhenNothingMatches(t *testing.T) {
+	for _, code := range []string{"NoMatch", "NoSegment"} {
+		t.Run(code, func(t *testing.T) {
+			server := newMatchServer(t, func(w http.ResponseWriter, _ *http.Request) {
+				// OSRM answers these with a 400
+				w.WriteHeader(http.StatusBadRequest)
+				_ = json.NewEncoder(w).Encode(map[string]string{"code": code, "message": "Could not match the trace."})
+			})
+			c := newTestClient(t, server.URL+"/match/v1/driving")
+
+			points := testPoints(3)
+			matchings, err := c.Match(context.Background(), points)
+			if err != nil {
+				t.Fatalf("Match() error = %v", err)
+			}
+			if len(matchings) != 1 || matchings[0].Confidence != 0 {
+				t.Fatalf("Match() = %+v, want one unmatched stretch", matchings)
+			}
+			for i, p := range points {
+				if got := matchings[0].Coordinates[i]; got[0] != p.Longitude || got[1] != p.Latitude {
+					t.Errorf("coordinate %d = %v, want the raw point %v", i, got, p)
+				}
+			}
+		})
+	}
+}
+
+func TestMatchReturnsServiceErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		wantCode string
+	}{
+		{name: "invalid input", status: http.StatusBadRequest, body: `{"code":"InvalidQuery","message":"Query string malformed"}`, wantCode: "InvalidQuery"},
+		{name: "server error", status: http.StatusInternalServerError, body: `internal error`},
+		{name: "rate limited", status: http.StatusTooManyRequests, body: `{"code":"TooManyRequests","message":"slow down"}`, wantCode: "TooManyRequests"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newMatchServer(t, func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			})
+			c := newTestClient(t, server.URL+"/match/v1/driving")
+
+			_, err := c.Match(context.Background(), testPoints(3))
+			var matchErr *Error
+			if !errors.As(err, &matchErr) {
+				t.Fatalf("Match() error = %v, want an *Error", err)
+			}
+			if matchErr.StatusCode != tt.status || matchErr.Code != tt.wantCode {
+				t.Errorf("Match() error = %+v, want status %d and code %q", matchErr, tt.status, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestMatchKeepsAPIKeyOutOfErrors(t *testing.T) {
+	server := newMatchServer(t, func(http.ResponseWriter, *http.Request) {})
+	server.Close()
+	c := newTestClient(t, server.URL+"/match/v1/driving?api_key=secret")
+
+	_, err := c.Match(context.Background(), testPoints(3))
+	if err == nil {
+		t.Fatal("Match() succeeded against a closed server")
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("Match() error %q leaks the API key", err)
+	}
+}
+
+func TestMatchBatchesLongTracks(t *testing.T) {
+	server := newMatchServer(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"code":"Ok","matchings":[{"confidence":1,"geometry":{"coordinates":[[0,0]]}}]}`))
+	})
+	c := newTestClient(t, server.URL+"/match/v1/driving")
+
+	points := testPoints(150)
+	matchings, err := c.Match(context.Background(), points)
+	if err != nil {
+		t.Fatalf("Match() error = %v", err)
+	}
+	if len(matchings) != 2 {
+		t.Errorf("Match() returned %d matchings, want one per batch", len(matchings))
+	}
+
+	requests := server.recorded()
+	if len(requests) != 2 {
+		t.Fatalf("server got %d requests, want 2", len(requests))
+	}
+	first, second := requestPoints(t, requests[0]), requestPoints(t, requests[1])
+	if len(first) != maxBatchSize || len(second) != len(points)-maxBatchSize+1 {
+		t.Errorf("batches of %d and %d points, want %d and %d", len(first), len(second), maxBatchSize, len(points)-maxBatchSize+1)
+	}
+	// the batches share a point so the stretches join up
+	if first[len(first)-1] != second[0] {
+		t.Errorf("second batch starts at %q, want the first batch's last point %q", second[0], first[len(first)-1])
+	}
+}
+
+func TestMatchOmitsOutOfOrderTimestamps(t *testing.T) {
+	server := newMatchServer(t, func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"code":"Ok","matchings":[]}`))
+	})
+	c := newTestClient(t, server.URL+"/match/v1/driving")
+
+	points := testPoints(3)
+	points[2].Time = points[0].Time
+	if _, err := c.Match(context.Background(), points); err != nil {
+		t.Fatalf("Match() error = %v", err)
+	}
+	if got := server.recorded()[0].URL.Query().Get("timestamps"); got != "" {
+		t.Errorf("timestamps = %q, want none for a track that goes back in time", got)
+	}
+}
@@ -0,0 +1,118 @@
+package session
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/pkg/errors"
+)
+
+// MemoryStore keeps sessions in process memory. Sessions are lost on restart and are not shared
+// between replicas, so it is only suitable for local development and single-instance deployments.
+type MemoryStore struct {
+	cache *cache.Cache
+	// mu keeps Touch and DeleteIfEqual from acting on a key written or deleted while they run
+	mu sync.Mutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{cache: cache.New(cache.NoExpiration, 10*time.Hour)}
+}
+
+func (m *MemoryStore) Get(_ context.Context, key string) (string, bool, error) {
+	value, found := m.cache.Get(key)
+	if !found {
+		return "", false, nil
+	}
+	return value.(string), true, nil
+}
+
+func (m *MemoryStore) Set(_ context.Context, key, value string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cache.Set(key, value, ttl)
+	return nil
+}
+
+// SetNX sets key only if it isn't already set, and reports whether it did.
+func (m *MemoryStore) SetNX(_ context.Context, key, value string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.cache.Add(key, value, ttl) == nil, nil
+}
+
+// DeleteIfEqual deletes key only if it still holds value, and reports whether it did.
+func (m *MemoryStore) DeleteIfEqual(_ context.Context, key, value string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if current, found := m.cache.Get(key); !found || current != value {
+		return false, nil
+	}
+	m.cache.Delete(key)
+	return true, nil
+}
+
+// Incr adds one to the counter at key and returns its new value. A counter that doesn't exist yet starts at 1 and
+// expires after ttl; later increments leave its expiry alone.
+func (m *MemoryStore) Incr(_ context.Context, key string, ttl time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, expires, found := m.cache.GetWithExpiration(key)
+	remaining := cache.NoExpiration
+	if !expires.IsZero() {
+		// go-cache reads a TTL that isn't positive as "never expires", so a counter expiring right now starts over
+		if remaining = time.Until(expires); remaining <= 0 {
+			found = false
+		}
+	}
+	if !found {
+		m.cache.Set(key, "1", ttl)
+		return 1, nil
+	}
+
+	count, err := strconv.ParseInt(value.(string), 10, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "%s is not a counter", key)
+	}
+	count++
+	m.cache.Set(key, strconv.FormatInt(count, 10), remaining)
+	return count, nil
+}
+
+// Touch gives an existing key a new TTL without changing its value.
+func (m *MemoryStore) Touch(_ context.Context, key string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if value, found := m.cache.Get(key); found {
+		m.cache.Set(key, value, ttl)
+	}
+	return nil
+}
+
+// Durable is false: everything is lost on restart.
+func (m *MemoryStore) Durable() bool {
+	return false
+}
+
+func (m *MemoryStore) Keys(_ context.Context, prefix string) ([]string, error) {
+	var keys []string
+	for key := range m.cache.Items() {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (m *MemoryStore) Delete(_ context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range keys {
+		m.cache.Delete(key)
+	}
+	return nil
+}
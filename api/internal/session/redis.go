@@ -0,0 +1,154 @@
+package session
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisDialTimeout = 5 * time.Second
+	redisIOTimeout   = 5 * time.Second
+	redisMaxIdle     = 16
+	redisScanCount   = 500
+)
+
+// RedisOptions configures the connection to a Redis-protocol server (Redis, Valkey, KeyDB, ElastiCache...).
+type RedisOptions struct {
+	Addr     string
+	Password string
+	DB       int
+	TLS      bool
+}
+
+// RedisStore keeps sessions in a Redis-protocol server so they survive restarts and are shared
+// across replicas.
+type RedisStore struct {
+	client *redis.Client
+}
+
+// deleteIfEqualScript deletes KEYS[1] only if it holds ARGV[1]; running it server-side makes the check and the
+// delete a single step no other client can get between.
+var deleteIfEqualScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// incrScript increments KEYS[1] and, when that created it, gives it a TTL of ARGV[1] milliseconds. Doing both in one
+// script means a counter can never be left behind without an expiry.
+var incrScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if count == 1 and tonumber(ARGV[1]) > 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return count
+`)
+
+// globEscaper escapes the characters SCAN MATCH treats as wildcards.
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+func NewRedisStore(opts RedisOptions) *RedisStore {
+	options := &redis.Options{
+		Addr:         opts.Addr,
+		Password:     opts.Password,
+		DB:           opts.DB,
+		DialTimeout:  redisDialTimeout,
+		ReadTimeout:  redisIOTimeout,
+		WriteTimeout: redisIOTimeout,
+		MaxIdleConns: redisMaxIdle,
+		// managed servers often don't know CLIENT SETINFO; there's nothing to gain from sending it
+		DisableIdentity: true,
+	}
+	if opts.TLS {
+		host, _, _ := net.SplitHostPort(opts.Addr)
+		options.TLSConfig = &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	}
+	return &RedisStore{client: redis.NewClient(options)}
+}
+
+// Ping checks that the server is reachable and accepts our credentials.
+func (r *RedisStore) Ping(ctx context.Context) error {
+	return errors.Wrap(r.client.Ping(ctx).Err(), "error connecting to redis")
+}
+
+// Close releases the store's connections.
+func (r *RedisStore) Close() error {
+	return r.client.Close()
+}
+
+func (r *RedisStore) Get(ctx context.Context, key string) (string, bool, error) {
+	value, err := r.client.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, errors.Wrap(err, "error reading from redis")
+	}
+	return value, true, nil
+}
+
+func (r *RedisStore) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	return errors.Wrap(r.client.Set(ctx, key, value, ttl).Err(), "error writing to redis")
+}
+
+// SetNX sets key only if it isn't already set, and reports whether it did.
+func (r *RedisStore) SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	set, err := r.client.SetNX(ctx, key, value, ttl).Result()
+	return set, errors.Wrap(err, "error writing to redis")
+}
+
+// DeleteIfEqual deletes key only if it still holds value, and reports whether it did.
+func (r *RedisStore) DeleteIfEqual(ctx context.Context, key, value string) (bool, error) {
+	deleted, err := deleteIfEqualScript.Run(ctx, r.client, []string{key}, value).Int()
+	return deleted == 1, errors.Wrap(err, "error deleting from redis")
+}
+
+// Incr adds one to the counter at key and returns its new value. A counter that doesn't exist yet starts at 1 and
+// expires after ttl; later increments leave its expiry alone.
+func (r *RedisStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	count, err := incrScript.Run(ctx, r.client, []string{key}, ttl.Milliseconds()).Int64()
+	return count, errors.Wrap(err, "error incrementing redis counter")
+}
+
+// Touch gives an existing key a new TTL without changing its value.
+func (r *RedisStore) Touch(ctx context.Context, key string, ttl time.Duration) error {
+	var err error
+	if ttl > 0 {
+		err = r.client.PExpire(ctx, key, ttl).Err()
+	} else {
+		err = r.client.Persist(ctx, key).Err()
+	}
+	return errors.Wrap(err, "error writing to redis")
+}
+
+// Durable is true: the server outlives the app and is shared by its replicas.
+func (r *RedisStore) Durable() bool {
+	return true
+}
+
+// Keys walks the keyspace with SCAN rather than KEYS so a large store is never blocked.
+func (r *RedisStore) Keys(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	iter := r.client.Scan(ctx, 0, globEscaper.Replace(prefix)+"*", redisScanCount).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, errors.Wrap(err, "error listing redis keys")
+	}
+	return keys, nil
+}
+
+func (r *RedisStore) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return errors.Wrap(r.client.Del(ctx, keys...).Err(), "error deleting from redis")
+}
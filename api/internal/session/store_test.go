@@ -0,0 +1,290 @@
+package session
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// store is what the controllers need from a session backend.
+type store interface {
+	Get(ctx context.Context, key string) (string, bool, error)
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+	Keys(ctx context.Context, prefix string) ([]string, error)
+	SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error)
+	DeleteIfEqual(ctx context.Context, key, value string) (bool, error)
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	Touch(ctx context.Context, key string, ttl time.Duration) error
+}
+
+func newTestRedis(t *testing.T) (*RedisStore, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	store := NewRedisStore(RedisOptions{Addr: server.Addr()})
+	t.Cleanup(func() { _ = store.Close() })
+	return store, server
+}
+
+func TestStores(t *testing.T) {
+	redisStore, _ := newTestRedis(t)
+	for name, s := range map[string]store{"memory": NewMemoryStore(), "redis": redisStore} {
+		t.Run(name, func(t *testing.T) { testStore(t, s) })
+	}
+}
+
+func testStore(t *testing.T, s store) {
+	ctx := context.Background()
+
+	if _, found, err := s.Get(ctx, "missing"); err != nil || found {
+		t.Fatalf("Get(missing) = found %v, err %v; want not found", found, err)
+	}
+
+	if err := s.Set(ctx, "session_a", "one", time.Hour); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if value, found, err := s.Get(ctx, "session_a"); err != nil || !found || value != "one" {
+		t.Fatalf("Get(session_a) = %q, %v, %v; want one", value, found, err)
+	}
+
+	for _, key := range []string{"session_b", "session_*", "other_a"} {
+		if err := s.Set(ctx, key, key, 0); err != nil {
+			t.Fatalf("Set(%s) error = %v", key, err)
+		}
+	}
+	keys, err := s.Keys(ctx, "session_")
+	if err != nil {
+		t.Fatalf("Keys() error = %v", err)
+	}
+	sort.Strings(keys)
+	if want := []string{"session_*", "session_a", "session_b"}; !equal(keys, want) {
+		t.Errorf("Keys(session_) = %v, want %v", keys, want)
+	}
+	// wildcards in the prefix are matched literally
+	if keys, err := s.Keys(ctx, "session_*"); err != nil || !equal(keys, []string{"session_*"}) {
+		t.Errorf("Keys(session_*) = %v, %v; want [session_*]", keys, err)
+	}
+
+	if err := s.Delete(ctx, "session_a", "session_b", "missing"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, found, _ := s.Get(ctx, "session_a"); found {
+		t.Error("session_a still present after Delete")
+	}
+	if err := s.Delete(ctx); err != nil {
+		t.Errorf("Delete() with no keys error = %v", err)
+	}
+
+	if set, err := s.SetNX(ctx, "lock", "first", time.Minute); err != nil || !set {
+		t.Fatalf("SetNX() on a free key = %v, %v; want set", set, err)
+	}
+	if set, err := s.SetNX(ctx, "lock", "second", time.Minute); err != nil || set {
+		t.Fatalf("SetNX() on a taken key = %v, %v; want not set", set, err)
+	}
+	if value, _, _ := s.Get(ctx, "lock"); value != "first" {
+		t.Errorf("SetNX() overwrote the lock with %q", value)
+	}
+
+	if err := s.Touch(ctx, "lock", time.Hour); err != nil {
+		t.Fatalf("Touch() error = %v", err)
+	}
+	if value, _, _ := s.Get(ctx, "lock"); value != "first" {
+		t.Errorf("Touch() changed the value to %q", value)
+	}
+	if err := s.Touch(ctx, "missing", time.Hour); err != nil {
+		t.Fatalf("Touch() on a missing key error = %v", err)
+	}
+	if _, found, _ := s.Get(ctx, "missing"); found {
+		t.Error("Touch() created a missing key")
+	}
+
+	if deleted, err := s.DeleteIfEqual(ctx, "lock", "second"); err != nil || deleted {
+		t.Fatalf("DeleteIfEqual() with another value = %v, %v; want not deleted", deleted, err)
+	}
+	if value, _, _ := s.Get(ctx, "lock"); value != "first" {
+		t.Errorf("DeleteIfEqual() with another value removed the key, now %q", value)
+	}
+	if deleted, err := s.DeleteIfEqual(ctx, "lock", "first"); err != nil || !deleted {
+		t.Fatalf("DeleteIfEqual() with the held value = %v, %v; want deleted", deleted, err)
+	}
+	if _, found, _ := s.Get(ctx, "lock"); found {
+		t.Error("DeleteIfEqual() left the key in place")
+	}
+	if deleted, err := s.DeleteIfEqual(ctx, "missing", ""); err != nil || deleted {
+		t.Errorf("DeleteIfEqual() on a missing key = %v, %v; want not deleted", deleted, err)
+	}
+
+	for want := int64(1); want <= 3; want++ {
+		if count, err := s.Incr(ctx, "counter", time.Hour); err != nil || count != want {
+			t.Fatalf("Incr() = %d, %v; want %d", count, err, want)
+		}
+	}
+	if value, _, _ := s.Get(ctx, "counter"); value != "3" {
+		t.Errorf("counter = %q, want 3", value)
+	}
+	if err := s.Set(ctx, "not-a-counter", "value", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Incr(ctx, "not-a-counter", time.Hour); err == nil {
+		t.Error("Incr() on a non-numeric value succeeded")
+	}
+}
+
+func TestStoresIncrConcurrently(t *testing.T) {
+	redisStore, _ := newTestRedis(t)
+	for name, s := range map[string]store{"memory": NewMemoryStore(), "redis": redisStore} {
+		t.Run(name, func(t *testing.T) {
+			var wg sync.WaitGroup
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if _, err := s.Incr(context.Background(), "counter", time.Hour); err != nil {
+						t.Error(err)
+					}
+				}()
+			}
+			wg.Wait()
+			if value, _, _ := s.Get(context.Background(), "counter"); value != "50" {
+				t.Errorf("counter = %q after 50 concurrent increments", value)
+			}
+		})
+	}
+}
+
+func TestStoresIncrKeepsTheFirstExpiry(t *testing.T) {
+	s, server := newTestRedis(t)
+	ctx := context.Background()
+
+	if _, err := s.Incr(ctx, "counter", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	server.FastForward(40 * time.Second)
+	if _, err := s.Incr(ctx, "counter", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	server.FastForward(30 * time.Second)
+	if _, found, _ := s.Get(ctx, "counter"); found {
+		t.Error("a later Incr() pushed the counter's expiry back")
+	}
+
+	memory := NewMemoryStore()
+	if _, err := memory.Incr(ctx, "counter", 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, err := memory.Incr(ctx, "counter", 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, found, _ := memory.Get(ctx, "counter"); found {
+		t.Error("a later Incr() pushed the memory counter's expiry back")
+	}
+}
+
+func TestRedisStoreExpiry(t *testing.T) {
+	s, server := newTestRedis(t)
+	ctx := context.Background()
+
+	if err := s.Set(ctx, "short", "v", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set(ctx, "forever", "v", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set(ctx, "touched", "v", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Touch(ctx, "touched", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	server.FastForward(2 * time.Minute)
+
+	if _, found, _ := s.Get(ctx, "short"); found {
+		t.Error("key with a TTL survived past it")
+	}
+	if _, found, _ := s.Get(ctx, "forever"); !found {
+		t.Error("key without a TTL expired")
+	}
+	if _, found, _ := s.Get(ctx, "touched"); !found {
+		t.Error("Touch() didn't extend the TTL")
+	}
+}
+
+func TestRedisStoreKeysPagesThroughScan(t *testing.T) {
+	s, server := newTestRedis(t)
+	ctx := context.Background()
+
+	for i := 0; i < 3*redisScanCount; i++ {
+		_ = server.Set("session_"+strconv.Itoa(i), "v")
+	}
+	keys, err := s.Keys(ctx, "session_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3*redisScanCount {
+		t.Errorf("Keys() returned %d keys, want %d", len(keys), 3*redisScanCount)
+	}
+}
+
+func TestRedisStoreRecoversFromErrorReplies(t *testing.T) {
+	s, server := newTestRedis(t)
+	ctx := context.Background()
+
+	// GET on a list is a WRONGTYPE error reply; the connection must still be usable afterwards
+	if _, err := server.Push("list", "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, _, err := s.Get(ctx, "list"); err == nil {
+			t.Fatal("Get() on a list succeeded, want WRONGTYPE error")
+		}
+		if err := s.Set(ctx, "key", "value", 0); err != nil {
+			t.Fatalf("Set() after an error reply: %v", err)
+		}
+		if value, _, err := s.Get(ctx, "key"); err != nil || value != "value" {
+			t.Fatalf("Get() after an error reply = %q, %v; want value", value, err)
+		}
+	}
+}
+
+func TestRedisStoreAuth(t *testing.T) {
+	server := miniredis.RunT(t)
+	server.RequireAuth("secret")
+
+	wrong := NewRedisStore(RedisOptions{Addr: server.Addr(), Password: "wrong"})
+	defer wrong.Close()
+	if err := wrong.Ping(context.Background()); err == nil {
+		t.Error("Ping() with a wrong password succeeded")
+	}
+
+	right := NewRedisStore(RedisOptions{Addr: server.Addr(), Password: "secret", DB: 2})
+	defer right.Close()
+	if err := right.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping() error = %v", err)
+	}
+	if err := right.Set(context.Background(), "key", "value", 0); err != nil {
+		t.Fatal(err)
+	}
+	server.Select(2)
+	if value, err := server.Get("key"); err != nil || value != "value" {
+		t.Errorf("key not written to DB 2: %q, %v", value, err)
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -16,6 +16,16 @@ class DIMODexMessage {
   }
 };
 
+// fetches the CSRF token the API expects on every POST; the cookie it is paired with is sent along with credentials
+async function csrfHeaders(): Promise<Record<string, string>> {
+  const response = await fetch(`${import.meta.env.DIMO_API_BASEURL}/auth/csrf`, { credentials: 'include' });
+  if (!response.ok) {
+    throw new Error('Failed to fetch CSRF token');
+  }
+  const { csrfToken } = await response.json();
+  return { 'X-Csrf-Token': csrfToken };
+}
+
 function App() {
   const [status, setStatus] = useState<AuthenticationStatus>("unauthenticated");
   const account = useAccount();
@@ -26,7 +36,8 @@ function App() {
         
       const response = await fetch(`${import.meta.env.DIMO_API_BASEURL}/auth/web3/generate_challenge`, {
         method: 'POST',
-        headers: { 'Content-Type': 'application/json' },
+        credentials: 'include',
+        headers: { 'Content-Type': 'application/json', ...(await csrfHeaders()) },
         body: JSON.stringify({
             address,
         }),
@@ -57,7 +68,8 @@ function App() {
     verify: async ({ message, signature }) => {
       const verifyRes = await fetch(`${import.meta.env.DIMO_API_BASEURL}/auth/web3/submit_challenge`, {
         method: 'POST',
-        headers: { 'Content-Type': 'application/json' },
+        credentials: 'include',
+        headers: { 'Content-Type': 'application/json', ...(await csrfHeaders()) },
         body: JSON.stringify({ state: message.state, signature }),
       });
   
@@ -71,7 +83,11 @@ function App() {
     },
   
     signOut: async () => {
-      await fetch('/api/logout');
+      await fetch(`${import.meta.env.DIMO_API_BASEURL}/auth/logout`, {
+        method: 'POST',
+        credentials: 'include',
+        headers: await csrfHeaders(),
+      });
     },
   
   });